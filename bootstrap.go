@@ -0,0 +1,118 @@
+package tdigest
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// QuantileCI processes pending data and returns a bootstrap confidence
+// interval for the value at quantile q, as FrozenDigest.QuantileCI does.
+func (t *TDigest) QuantileCI(q, confidence float64, iterations int, rng *rand.Rand) (lo, hi float64) {
+	t.Flush()
+	f := t.frozen()
+	return f.QuantileCI(q, confidence, iterations, rng)
+}
+
+// QuantileCI returns an interval that holds the value at quantile q with the
+// given confidence, such as 0.95, estimated by a Poisson bootstrap over the
+// centroids. Each of the iterations replicates gives every centroid a weight
+// drawn from a Poisson distribution with its own weight as the mean, as if
+// the values it merged had been resampled, and recomputes the quantile; the
+// interval runs between the percentiles of the replicates that leave
+// (1-confidence)/2 of them on each side. rng supplies the random numbers; if
+// it is nil, a source seeded with the current time is used.
+//
+// The means of the centroids are kept, so the interval accounts for the
+// sampling error of the ranks but not for the compression of the digest. It
+// returns NaN, NaN for an empty digest, a q that Quantile rejects, a
+// confidence outside (0, 1) and fewer than one iteration, and if too many
+// replicates resample nothing to form the interval. f is not modified,
+// and the buffers for the replicates are allocated once per call.
+func (f *FrozenDigest) QuantileCI(q, confidence float64, iterations int, rng *rand.Rand) (lo, hi float64) {
+	if _, err := f.QuantileE(q); err != nil || !(confidence > 0 && confidence < 1) || iterations < 1 {
+		return math.NaN(), math.NaN()
+	}
+	if rng == nil {
+		rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	n := f.processed.Len()
+	r := FrozenDigest{
+		processed:  make(CentroidList, 0, n),
+		cumulative: make([]float64, 0, n+1),
+		min:        f.min,
+		max:        f.max,
+	}
+	if f.ranges != nil {
+		r.ranges = make([]CentroidRange, 0, n)
+	}
+	// The Poisson means are the weights scaled up to a total of at least
+	// 1, since a digest of less weight would mostly resample nothing; only
+	// the proportions of the weights matter to the quantile. Even so some
+	// replicates come out empty and are drawn again, up to a bound.
+	scale := 1.0
+	if f.count < 1 {
+		scale = 1 / f.count
+	}
+	maxDraws := 10*iterations + 100
+	replicates := make([]float64, 0, iterations)
+	for draws := 0; len(replicates) < iterations; draws++ {
+		if draws == maxDraws {
+			return math.NaN(), math.NaN()
+		}
+		r.processed, r.cumulative = r.processed[:0], r.cumulative[:0]
+		if r.ranges != nil {
+			r.ranges = r.ranges[:0]
+		}
+		var cumulative kahanSum
+		for i, c := range f.processed {
+			w := poisson(rng, c.Weight*scale)
+			if w == 0 {
+				continue
+			}
+			r.processed = append(r.processed, Centroid{Mean: c.Mean, Weight: w})
+			if r.ranges != nil {
+				r.ranges = append(r.ranges, f.ranges[i])
+			}
+			r.cumulative = append(r.cumulative, cumulative.value()+w/2)
+			cumulative.add(w)
+		}
+		if len(r.processed) == 0 {
+			continue
+		}
+		r.count = cumulative.value()
+		r.cumulative = append(r.cumulative, r.count)
+		replicates = append(replicates, r.quantile(q))
+	}
+	sort.Float64s(replicates)
+	return percentile(replicates, (1-confidence)/2), percentile(replicates, (1+confidence)/2)
+}
+
+// poisson returns a number drawn from a Poisson distribution with the given
+// mean. Means of 30 and more use the normal approximation, rounded and
+// clamped at zero.
+func poisson(rng *rand.Rand, mean float64) float64 {
+	if mean >= 30 {
+		return math.Max(0, math.Round(mean+math.Sqrt(mean)*rng.NormFloat64()))
+	}
+	// Knuth's method counts uniform draws until their product falls below
+	// exp(-mean).
+	limit, p, k := math.Exp(-mean), rng.Float64(), 0.0
+	for p > limit {
+		p *= rng.Float64()
+		k++
+	}
+	return k
+}
+
+// percentile interpolates linearly between the sorted values at fraction p of
+// the way through them.
+func percentile(sorted []float64, p float64) float64 {
+	index := p * float64(len(sorted)-1)
+	i := int(index)
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return weightedAverage(sorted[i], float64(i+1)-index, sorted[i+1], index-float64(i))
+}
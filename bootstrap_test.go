@@ -0,0 +1,108 @@
+package tdigest_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/influxdata/tdigest"
+)
+
+func TestTdigest_QuantileCICoverage(t *testing.T) {
+	// Over many samples from a known distribution, the 95% interval holds
+	// the true quantile in about 95% of them.
+	const trials, n = 200, 2000
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		truth := math.Sqrt2 * math.Erfinv(2*q-1)
+		rng := rand.New(rand.NewSource(1))
+		covered := 0
+		for i := 0; i < trials; i++ {
+			td := tdigest.NewWithCompression(100)
+			for j := 0; j < n; j++ {
+				td.Add(rng.NormFloat64(), 1)
+			}
+			lo, hi := td.QuantileCI(q, 0.95, 200, rng)
+			if !(lo <= td.Quantile(q) && td.Quantile(q) <= hi) {
+				t.Errorf("q %g: interval [%g, %g] does not hold the estimate %g", q, lo, hi, td.Quantile(q))
+			}
+			if lo <= truth && truth <= hi {
+				covered++
+			}
+		}
+		if coverage := float64(covered) / trials; coverage < 0.9 || coverage > 0.99 {
+			t.Errorf("q %g: 95%% interval covered the true quantile in %g of trials", q, coverage)
+		} else {
+			t.Logf("q %g: coverage %g", q, coverage)
+		}
+	}
+}
+
+func TestTdigest_QuantileCI(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	for _, x := range NormalData {
+		td.Add(x, 1)
+	}
+	before := append(tdigest.CentroidList(nil), td.Centroids()...)
+	f := td.Snapshot()
+
+	// Wider confidence gives a wider interval, and the same random numbers
+	// give the same interval.
+	lo95, hi95 := f.QuantileCI(0.99, 0.95, 500, rand.New(rand.NewSource(1)))
+	lo50, hi50 := f.QuantileCI(0.99, 0.5, 500, rand.New(rand.NewSource(1)))
+	if !(lo95 < lo50 && lo50 < hi50 && hi50 < hi95) {
+		t.Errorf("95%% interval [%g, %g], 50%% interval [%g, %g]", lo95, hi95, lo50, hi50)
+	}
+	if lo, hi := td.QuantileCI(0.99, 0.95, 500, rand.New(rand.NewSource(1))); lo != lo95 || hi != hi95 {
+		t.Errorf("TDigest interval [%g, %g], snapshot interval [%g, %g]", lo, hi, lo95, hi95)
+	}
+	if diff := cmp.Diff(before, td.Centroids()); diff != "" {
+		t.Errorf("QuantileCI modified the digest (-before +after):\n%s", diff)
+	}
+
+	allocs := testing.AllocsPerRun(10, func() {
+		f.QuantileCI(0.99, 0.95, 1000, rand.New(rand.NewSource(1)))
+	})
+	if allocs > 10 {
+		t.Errorf("%g allocations for 1000 iterations", allocs)
+	}
+}
+
+func TestTdigest_QuantileCIInvalid(t *testing.T) {
+	td := tdigest.New()
+	td.Add(1, 1)
+	rng := rand.New(rand.NewSource(1))
+	for _, tt := range []struct {
+		name          string
+		digest        *tdigest.TDigest
+		q, confidence float64
+		iterations    int
+	}{
+		{"empty", tdigest.New(), 0.5, 0.95, 100},
+		{"quantile", td, 1.5, 0.95, 100},
+		{"confidence", td, 0.5, 1, 100},
+		{"nan confidence", td, 0.5, math.NaN(), 100},
+		{"iterations", td, 0.5, 0.95, 0},
+	} {
+		if lo, hi := tt.digest.QuantileCI(tt.q, tt.confidence, tt.iterations, rng); !math.IsNaN(lo) || !math.IsNaN(hi) {
+			t.Errorf("%s: got [%g, %g], want NaN", tt.name, lo, hi)
+		}
+	}
+	// A single value gives a single point, and a nil source is allowed.
+	if lo, hi := td.QuantileCI(0.5, 0.95, 100, nil); lo != 1 || hi != 1 {
+		t.Errorf("single value: got [%g, %g]", lo, hi)
+	}
+
+	// Digests of little weight resample in proportion to their weights,
+	// rather than resampling nothing.
+	for _, w := range []float64{1e-20, 1e-3} {
+		tiny := tdigest.New()
+		for i := 0; i < 10; i++ {
+			tiny.Add(float64(i), w)
+		}
+		lo, hi := tiny.QuantileCI(0.5, 0.95, 1000, rng)
+		if !(lo >= 0 && lo <= hi && hi <= 9) {
+			t.Errorf("weights %g: got [%g, %g]", w, lo, hi)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package tdigest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ErrInvalidBuckets is returned by Bucketizer for a number of buckets less
+// than one.
+const ErrInvalidBuckets = Error("number of buckets must be positive")
+
+// BucketOf processes pending data and returns the equal-frequency bucket of
+// x, as FrozenDigest.BucketOf does.
+func (t *TDigest) BucketOf(x float64, n int) int {
+	t.Flush()
+	f := t.frozen()
+	return f.BucketOf(x, n)
+}
+
+// BucketOf returns the 0-based index of the bucket that holds x when the
+// values of the snapshot are split into n buckets of equal weight. Bucket i
+// runs from the boundary Quantile(i/n) up to, but not including, the next;
+// values below the first boundary are in bucket 0, and values from the last
+// boundary up, including the maximum and anything above it, in bucket n-1.
+// Where a point mass makes several boundaries equal, x at that value is in
+// the last of their buckets and the others are empty. It returns -1 for an
+// n less than one, an empty snapshot or a NaN x.
+//
+// BucketOf starts from floor(CDF(x)*n) and moves to the bucket the
+// boundaries give, so it costs a CDF and a few quantiles. It agrees with a
+// Bucketizer of the same snapshot, which is cheaper for classifying many
+// values.
+func (f *FrozenDigest) BucketOf(x float64, n int) int {
+	if n < 1 || f.processed.Len() == 0 || math.IsNaN(x) {
+		return -1
+	}
+	b := int(f.cdf(x) * float64(n))
+	if b > n-1 {
+		b = n - 1
+	}
+	for b < n-1 && bucketBoundary(f, b+1, n) <= x {
+		b++
+	}
+	for b > 0 && bucketBoundary(f, b, n) > x {
+		b--
+	}
+	return b
+}
+
+// bucketBoundary returns the lower boundary of bucket i of n.
+func bucketBoundary(f *FrozenDigest, i, n int) float64 {
+	return f.quantile(float64(i) / float64(n))
+}
+
+// Bucketizer classifies values into the equal-frequency buckets of a digest,
+// as BucketOf does, from boundaries computed once. It does not change, and
+// may be used from several goroutines at once.
+type Bucketizer struct {
+	boundaries []float64
+}
+
+// Bucketizer processes pending data and returns a Bucketizer for n buckets
+// of t, as FrozenDigest.Bucketizer does.
+func (t *TDigest) Bucketizer(n int) (*Bucketizer, error) {
+	t.Flush()
+	f := t.frozen()
+	return f.Bucketizer(n)
+}
+
+// Bucketizer returns a Bucketizer for n equal-frequency buckets of the
+// snapshot, holding its n-1 inner boundaries. It returns ErrEmptyDigest for
+// an empty snapshot and an error wrapping ErrInvalidBuckets for an n less
+// than one.
+func (f *FrozenDigest) Bucketizer(n int) (*Bucketizer, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("%d buckets: %w", n, ErrInvalidBuckets)
+	}
+	if f.processed.Len() == 0 {
+		return nil, ErrEmptyDigest
+	}
+	b := &Bucketizer{boundaries: make([]float64, n-1)}
+	for i := range b.boundaries {
+		b.boundaries[i] = bucketBoundary(f, i+1, n)
+	}
+	return b, nil
+}
+
+// Len returns the number of buckets.
+func (b *Bucketizer) Len() int {
+	return len(b.boundaries) + 1
+}
+
+// Boundaries returns the lower boundaries of buckets 1 to Len()-1. They must
+// not be modified.
+func (b *Bucketizer) Boundaries() []float64 {
+	return b.boundaries
+}
+
+// Bucket returns the bucket of x, from 0 to Len()-1, or -1 if x is NaN. It
+// searches the boundaries and does not allocate.
+func (b *Bucketizer) Bucket(x float64) int {
+	if math.IsNaN(x) {
+		return -1
+	}
+	return sort.Search(len(b.boundaries), func(i int) bool { return b.boundaries[i] > x })
+}
@@ -0,0 +1,150 @@
+package tdigest_test
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+// checkBuckets checks that BucketOf and the Bucketizer agree on x, and
+// returns the bucket.
+func checkBuckets(t *testing.T, td *tdigest.TDigest, b *tdigest.Bucketizer, x float64) int {
+	t.Helper()
+	got, want := td.BucketOf(x, b.Len()), b.Bucket(x)
+	if got != want {
+		t.Errorf("BucketOf(%g, %d) = %d, Bucketizer gives %d", x, b.Len(), got, want)
+	}
+	return want
+}
+
+func TestTdigest_BucketOf(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	for _, x := range UniformData {
+		td.Add(x, 1)
+	}
+	const n = 10
+	b, err := td.Bucketizer(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != n || len(b.Boundaries()) != n-1 {
+		t.Fatalf("Bucketizer has %d buckets and %d boundaries", b.Len(), len(b.Boundaries()))
+	}
+
+	// Each bucket holds about a tenth of the data.
+	counts := make([]int, n)
+	for _, x := range UniformData {
+		counts[b.Bucket(x)]++
+	}
+	for i, c := range counts {
+		if share := float64(c) / float64(len(UniformData)); math.Abs(share-0.1) > 0.002 {
+			t.Errorf("bucket %d holds %g of the data", i, share)
+		}
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		checkBuckets(t, td, b, td.Quantile(r.Float64()))
+	}
+
+	// A boundary belongs to the bucket it starts, and values outside the
+	// data to the first or last bucket.
+	for i, x := range b.Boundaries() {
+		if got := checkBuckets(t, td, b, x); got != i+1 {
+			t.Errorf("boundary %g of bucket %d is in bucket %d", x, i+1, got)
+		}
+		if got := checkBuckets(t, td, b, math.Nextafter(x, math.Inf(-1))); got != i {
+			t.Errorf("value just below boundary %g of bucket %d is in bucket %d", x, i+1, got)
+		}
+	}
+	for x, want := range map[float64]int{
+		td.Min() - 1: 0, td.Min(): 0, td.Max(): n - 1, td.Max() + 1: n - 1,
+		math.Inf(-1): 0, math.Inf(1): n - 1,
+	} {
+		if got := checkBuckets(t, td, b, x); got != want {
+			t.Errorf("%g is in bucket %d, want %d", x, got, want)
+		}
+	}
+
+	if allocs := testing.AllocsPerRun(100, func() { b.Bucket(0.5) }); allocs != 0 {
+		t.Errorf("Bucket allocates %g times", allocs)
+	}
+}
+
+func TestTdigest_BucketOfPointMass(t *testing.T) {
+	// 45% of the weight at zero makes the boundaries of buckets 1 to 4
+	// zero too, leaving buckets 0 to 3 empty.
+	td := tdigest.NewWithCompression(100)
+	td.Add(0, 4500)
+	for i := 1; i <= 5500; i++ {
+		td.Add(float64(i)/5500, 1)
+	}
+	b, err := td.Bucketizer(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, x := range b.Boundaries()[:4] {
+		if x != 0 {
+			t.Errorf("boundary of bucket %d is %g, want 0", i+1, x)
+		}
+	}
+	if b.Boundaries()[4] <= 0 {
+		t.Errorf("boundary of bucket 5 is %g, want above 0", b.Boundaries()[4])
+	}
+	for x, want := range map[float64]int{-1: 0, 0: 4, 1e-9: 4, 1: 9} {
+		if got := checkBuckets(t, td, b, x); got != want {
+			t.Errorf("%g is in bucket %d, want %d", x, got, want)
+		}
+	}
+
+	// With all the weight at one value, every value there or above is in
+	// the last bucket.
+	constant := tdigest.New()
+	constant.Add(3, 100)
+	cb, err := constant.Bucketizer(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, want := range map[float64]int{2: 0, 3: 3, 4: 3} {
+		if got := checkBuckets(t, constant, cb, x); got != want {
+			t.Errorf("constant digest: %g is in bucket %d, want %d", x, got, want)
+		}
+	}
+}
+
+func TestTdigest_BucketOfInvalid(t *testing.T) {
+	td := tdigest.New()
+	td.Add(1, 1)
+	for _, tt := range []struct {
+		name string
+		td   *tdigest.TDigest
+		x    float64
+		n    int
+	}{
+		{"empty", tdigest.New(), 1, 10},
+		{"no buckets", td, 1, 0},
+		{"NaN", td, math.NaN(), 10},
+	} {
+		if got := tt.td.BucketOf(tt.x, tt.n); got != -1 {
+			t.Errorf("%s: BucketOf(%g, %d) = %d, want -1", tt.name, tt.x, tt.n, got)
+		}
+	}
+	if got := td.BucketOf(5, 1); got != 0 {
+		t.Errorf("BucketOf(5, 1) = %d, want 0", got)
+	}
+	if _, err := tdigest.New().Bucketizer(10); !errors.Is(err, tdigest.ErrEmptyDigest) {
+		t.Errorf("empty digest: got %v", err)
+	}
+	if _, err := td.Bucketizer(0); !errors.Is(err, tdigest.ErrInvalidBuckets) {
+		t.Errorf("no buckets: got %v", err)
+	}
+	b, err := td.Bucketizer(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 1 || b.Bucket(-5) != 0 || b.Bucket(5) != 0 || b.Bucket(math.NaN()) != -1 {
+		t.Errorf("single bucket: got %d buckets, %d, %d, %d", b.Len(), b.Bucket(-5), b.Bucket(5), b.Bucket(math.NaN()))
+	}
+}
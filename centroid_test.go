@@ -1,10 +1,17 @@
 package tdigest_test
 
 import (
+	"errors"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/influxdata/tdigest"
+	"golang.org/x/exp/rand"
 )
 
 func TestCentroid_Add(t *testing.T) {
@@ -120,3 +127,371 @@ func TestNewCentroidList(t *testing.T) {
 		})
 	}
 }
+
+func TestSortCentroids(t *testing.T) {
+	rng := rand.New(rand.NewSource(seed))
+	for _, n := range []int{0, 1, 2, 13, 100, 8000} {
+		for _, levels := range []int{0, 3} {
+			l := make(tdigest.CentroidList, n)
+			for i := range l {
+				l[i].Mean = rng.Float64()
+				if levels > 0 {
+					l[i].Mean = float64(rng.Intn(levels))
+				}
+			}
+			tdigest.SortCentroids(l)
+			if !sort.IsSorted(l) {
+				t.Errorf("centroids not sorted for n=%d levels=%d", n, levels)
+			}
+		}
+	}
+}
+
+func TestCentroidList_Validate(t *testing.T) {
+	tests := []struct {
+		name string
+		l    tdigest.CentroidList
+		want error
+		msg  string
+	}{
+		{name: "empty"},
+		{name: "valid", l: tdigest.CentroidList{{Mean: 1, Weight: 1}, {Mean: 1, Weight: 2}, {Mean: 3, Weight: 0.5}}},
+		{
+			name: "NaN mean",
+			l:    tdigest.CentroidList{{Mean: 1, Weight: 1}, {Mean: math.NaN(), Weight: 1}},
+			want: tdigest.ErrInvalidCentroid,
+			msg:  "centroid 1: Mean NaN",
+		},
+		{
+			name: "infinite mean",
+			l:    tdigest.CentroidList{{Mean: math.Inf(-1), Weight: 1}},
+			want: tdigest.ErrInvalidCentroid,
+			msg:  "centroid 0: Mean -Inf",
+		},
+		{
+			name: "zero weight",
+			l:    tdigest.CentroidList{{Mean: 1, Weight: 0}},
+			want: tdigest.ErrInvalidCentroid,
+			msg:  "centroid 0: Weight 0",
+		},
+		{
+			name: "negative weight",
+			l:    tdigest.CentroidList{{Mean: 1, Weight: 1}, {Mean: 2, Weight: 1}, {Mean: 3, Weight: -1}},
+			want: tdigest.ErrInvalidCentroid,
+			msg:  "centroid 2: Weight -1",
+		},
+		{
+			name: "infinite weight",
+			l:    tdigest.CentroidList{{Mean: 1, Weight: math.Inf(1)}},
+			want: tdigest.ErrInvalidCentroid,
+			msg:  "centroid 0: Weight +Inf",
+		},
+		{
+			name: "unsorted",
+			l:    tdigest.CentroidList{{Mean: 1, Weight: 1}, {Mean: 3, Weight: 1}, {Mean: 2, Weight: 1}},
+			want: tdigest.ErrUnsortedCentroids,
+			msg:  "centroid 2: Mean 2 is below the previous mean 3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.l.Validate()
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) || !strings.HasPrefix(err.Error(), tt.msg) {
+				t.Errorf("got %v, want %q wrapping %v", err, tt.msg, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeCentroidLists(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b tdigest.CentroidList
+		want tdigest.CentroidList
+	}{
+		{name: "both empty"},
+		{
+			name: "one empty",
+			a:    tdigest.CentroidList{{Mean: 1, Weight: 1}, {Mean: 2, Weight: 2}},
+			want: tdigest.CentroidList{{Mean: 1, Weight: 1}, {Mean: 2, Weight: 2}},
+		},
+		{
+			name: "other empty",
+			b:    tdigest.CentroidList{{Mean: 1, Weight: 1}},
+			want: tdigest.CentroidList{{Mean: 1, Weight: 1}},
+		},
+		{
+			name: "disjoint",
+			a:    tdigest.CentroidList{{Mean: 5, Weight: 1}, {Mean: 6, Weight: 1}},
+			b:    tdigest.CentroidList{{Mean: 1, Weight: 2}, {Mean: 2, Weight: 2}},
+			want: tdigest.CentroidList{{Mean: 1, Weight: 2}, {Mean: 2, Weight: 2}, {Mean: 5, Weight: 1}, {Mean: 6, Weight: 1}},
+		},
+		{
+			name: "interleaved",
+			a:    tdigest.CentroidList{{Mean: 1, Weight: 1}, {Mean: 3, Weight: 1}},
+			b:    tdigest.CentroidList{{Mean: 2, Weight: 2}, {Mean: 4, Weight: 2}},
+			want: tdigest.CentroidList{{Mean: 1, Weight: 1}, {Mean: 2, Weight: 2}, {Mean: 3, Weight: 1}, {Mean: 4, Weight: 2}},
+		},
+		{
+			name: "same means",
+			a:    tdigest.CentroidList{{Mean: 1, Weight: 1}, {Mean: 2, Weight: 1}, {Mean: 2, Weight: 3}},
+			b:    tdigest.CentroidList{{Mean: 1, Weight: 2}, {Mean: 2, Weight: 0.5}},
+			want: tdigest.CentroidList{{Mean: 1, Weight: 3}, {Mean: 2, Weight: 4.5}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := tt.a.Clone(), tt.b.Clone()
+			got := tdigest.MergeCentroidLists(tt.a, tt.b)
+			if !cmp.Equal(tt.want, got, cmpopts.EquateEmpty()) {
+				t.Errorf("MergeCentroidLists() = -want/+got %s", cmp.Diff(tt.want, got))
+			}
+			if !cmp.Equal(a, tt.a, cmpopts.EquateEmpty()) || !cmp.Equal(b, tt.b, cmpopts.EquateEmpty()) {
+				t.Error("inputs were modified")
+			}
+		})
+	}
+}
+
+func TestAppendMergedCentroidLists(t *testing.T) {
+	dst := tdigest.CentroidList{{Mean: 2, Weight: 1}}
+	a := tdigest.CentroidList{{Mean: 2, Weight: 1}, {Mean: 3, Weight: 1}}
+	b := tdigest.CentroidList{{Mean: 1, Weight: 1}}
+	got := tdigest.AppendMergedCentroidLists(dst, a, b)
+	want := tdigest.CentroidList{{Mean: 2, Weight: 1}, {Mean: 1, Weight: 1}, {Mean: 2, Weight: 1}, {Mean: 3, Weight: 1}}
+	if !cmp.Equal(want, got) {
+		t.Errorf("AppendMergedCentroidLists() = -want/+got %s", cmp.Diff(want, got))
+	}
+
+	dst = make(tdigest.CentroidList, 0, 10)
+	allocs := testing.AllocsPerRun(100, func() {
+		tdigest.AppendMergedCentroidLists(dst, a, b)
+	})
+	if allocs != 0 {
+		t.Errorf("%v allocations appending within capacity", allocs)
+	}
+	allocs = testing.AllocsPerRun(100, func() {
+		tdigest.AppendMergedCentroidLists(nil, a, b)
+	})
+	if allocs != 1 {
+		t.Errorf("%v allocations appending to nil, want 1", allocs)
+	}
+}
+
+func TestCentroidList_Aggregates(t *testing.T) {
+	var empty tdigest.CentroidList
+	if !empty.Empty() || empty.TotalWeight() != 0 || !math.IsNaN(empty.WeightedMean()) {
+		t.Errorf("empty list: %v, %v, %v", empty.Empty(), empty.TotalWeight(), empty.WeightedMean())
+	}
+	if min, max := empty.Bounds(); !math.IsNaN(min) || !math.IsNaN(max) {
+		t.Errorf("empty list has bounds %v, %v", min, max)
+	}
+
+	l := tdigest.CentroidList{{Mean: 3, Weight: 1}, {Mean: -1, Weight: 2}, {Mean: 5, Weight: 1}}
+	if l.Empty() || l.Len() != 3 {
+		t.Error("list is empty")
+	}
+	if got := l.TotalWeight(); got != 4 {
+		t.Errorf("total weight %v, want 4", got)
+	}
+	if got := l.WeightedMean(); got != 1.5 {
+		t.Errorf("weighted mean %v, want 1.5", got)
+	}
+	if min, max := l.Bounds(); min != -1 || max != 5 {
+		t.Errorf("bounds %v, %v, want -1, 5", min, max)
+	}
+}
+
+func TestCentroidList_AggregatesLarge(t *testing.T) {
+	// Each weight of 1 is below the precision of a running sum of 1e16,
+	// so a naive sum never moves.
+	const n = 1000000
+	l := make(tdigest.CentroidList, 0, n+1)
+	l = append(l, tdigest.Centroid{Mean: 0, Weight: 1e16})
+	for i := 0; i < n; i++ {
+		l = append(l, tdigest.Centroid{Mean: 1e10, Weight: 1})
+	}
+	var naive float64
+	for _, c := range l {
+		naive += c.Weight
+	}
+	if naive != 1e16 {
+		t.Fatalf("naive sum %v unexpectedly exact", naive)
+	}
+	if got := l.TotalWeight(); got != 1e16+n {
+		t.Errorf("total weight %v, want %v", got, 1e16+n)
+	}
+	// The exact mean is 1e16/(1e16+1e6), within a relative 1e-10 of 1.
+	if got, want := l.WeightedMean(), 1e16/(1e16+n); math.Abs(got-want) > 1e-15 {
+		t.Errorf("weighted mean %v, want %v", got, want)
+	}
+}
+
+func TestCentroidSum(t *testing.T) {
+	var s tdigest.CentroidSum
+	if got := s.Centroid(); got != (tdigest.Centroid{}) {
+		t.Errorf("empty sum is %v", got)
+	}
+	if err := s.Add(tdigest.Centroid{Mean: 1, Weight: -1}); err != tdigest.ErrWeightLessThanZero {
+		t.Errorf("got %v, want ErrWeightLessThanZero", err)
+	}
+	s.Add(tdigest.Centroid{Mean: 1, Weight: 0})
+	if got := s.Centroid(); got != (tdigest.Centroid{Mean: 1}) {
+		t.Errorf("sum of zero weight is %v", got)
+	}
+	s.Add(tdigest.Centroid{Mean: 2, Weight: 1})
+	s.Add(tdigest.Centroid{Mean: 5, Weight: 2})
+	if got := s.Centroid(); got != (tdigest.Centroid{Mean: 4, Weight: 3}) {
+		t.Errorf("got %v, want {4 3}", got)
+	}
+}
+
+// exactMean returns the weighted mean of cs computed exactly.
+func exactMean(cs []tdigest.Centroid) float64 {
+	const prec = 2000
+	sum, weight := new(big.Float).SetPrec(prec), new(big.Float).SetPrec(prec)
+	for _, c := range cs {
+		w := new(big.Float).SetPrec(prec).SetFloat64(c.Weight)
+		weight.Add(weight, w)
+		sum.Add(sum, w.Mul(w, new(big.Float).SetPrec(prec).SetFloat64(c.Mean)))
+	}
+	mean, _ := sum.Quo(sum, weight).Float64()
+	return mean
+}
+
+func TestCentroidSum_Adversarial(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	const n = 1000000
+	sequences := []struct {
+		name string
+		next func(i int) tdigest.Centroid
+	}{
+		{"alternating huge and tiny means", func(i int) tdigest.Centroid {
+			if i%2 == 0 {
+				return tdigest.Centroid{Mean: 1e12 * (1 + rnd.Float64()), Weight: 1}
+			}
+			return tdigest.Centroid{Mean: 1e-3 * rnd.Float64(), Weight: 1}
+		}},
+		{"weights from 1 to 1e15", func(i int) tdigest.Centroid {
+			return tdigest.Centroid{Mean: 1000 + 100*rnd.NormFloat64(), Weight: math.Pow(10, 15*rnd.Float64())}
+		}},
+	}
+	for _, seq := range sequences {
+		t.Run(seq.name, func(t *testing.T) {
+			cs := make([]tdigest.Centroid, n)
+			for i := range cs {
+				cs[i] = seq.next(i)
+			}
+			want := exactMean(cs)
+
+			var s tdigest.CentroidSum
+			var c tdigest.Centroid
+			for _, x := range cs {
+				s.Add(x)
+				c.Add(x)
+			}
+			if got := s.Centroid().Mean; math.Abs(got-want) > 1e-15*math.Abs(want) {
+				t.Errorf("CentroidSum mean %v, want %v", got, want)
+			}
+			// Centroid.Add rounds at every step, which is far less
+			// accurate but still bounded.
+			if got := c.Mean; math.Abs(got-want) > 1e-12*math.Abs(want) {
+				t.Errorf("Centroid.Add mean %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestCentroidList_IndexByMean(t *testing.T) {
+	l := tdigest.CentroidList{{Mean: 1, Weight: 1}, {Mean: 2, Weight: 1}, {Mean: 2, Weight: 3}, {Mean: 4, Weight: 1}}
+	nan := math.NaN()
+	for _, tc := range []struct {
+		x           float64
+		floor, ceil int
+	}{
+		{0, -1, 0},
+		{1, 0, 0},
+		{1.5, 0, 1},
+		{2, 2, 1},
+		{3, 2, 3},
+		{4, 3, 3},
+		{5, 3, 4},
+		{math.Inf(-1), -1, 0},
+		{math.Inf(1), 3, 4},
+		{nan, -1, 4},
+	} {
+		if got := l.FloorIndexByMean(tc.x); got != tc.floor {
+			t.Errorf("FloorIndexByMean(%g) = %d, want %d", tc.x, got, tc.floor)
+		}
+		if got := l.CeilIndexByMean(tc.x); got != tc.ceil {
+			t.Errorf("CeilIndexByMean(%g) = %d, want %d", tc.x, got, tc.ceil)
+		}
+	}
+	var empty tdigest.CentroidList
+	if f, c := empty.FloorIndexByMean(1), empty.CeilIndexByMean(1); f != -1 || c != 0 {
+		t.Errorf("empty list: floor %d, ceil %d", f, c)
+	}
+}
+
+func TestCumulativeIndex(t *testing.T) {
+	cumulative := []float64{0.5, 1.5, 1.5, 3, 4}
+	for _, tc := range []struct {
+		rank float64
+		want int
+	}{
+		{0, 0},
+		{0.5, 0},
+		{0.6, 1},
+		{1.5, 1},
+		{2, 3},
+		{4, 4},
+		{4.1, 5},
+		{math.NaN(), 5},
+	} {
+		if got := tdigest.CumulativeIndex(tc.rank, cumulative); got != tc.want {
+			t.Errorf("CumulativeIndex(%g) = %d, want %d", tc.rank, got, tc.want)
+		}
+	}
+	if got := tdigest.CumulativeIndex(1, nil); got != 0 {
+		t.Errorf("CumulativeIndex on no weights = %d, want 0", got)
+	}
+}
+
+// shuffle permutes l with r.
+func shuffle(r *rand.Rand, l tdigest.CentroidList) {
+	for i := len(l) - 1; i > 0; i-- {
+		j := r.Intn(i + 1)
+		l[i], l[j] = l[j], l[i]
+	}
+}
+
+func TestCentroidList_SortDeterministic(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	l := make(tdigest.CentroidList, 100000)
+	for i := range l {
+		l[i] = tdigest.Centroid{Mean: float64(r.Intn(8)), Weight: float64(1 + r.Intn(4))}
+	}
+	want := tdigest.NewCentroidList(l.Clone())
+	if err := want.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		shuffled := l.Clone()
+		shuffle(r, shuffled)
+		if got := tdigest.NewCentroidList(shuffled); !cmp.Equal(got, want) {
+			t.Fatalf("NewCentroidList gave a different order for shuffle %d", i)
+		}
+		shuffled = l.Clone()
+		shuffle(r, shuffled)
+		sort.Sort(shuffled)
+		if !cmp.Equal(shuffled, want) {
+			t.Fatalf("sort.Sort gave a different order for shuffle %d", i)
+		}
+	}
+}
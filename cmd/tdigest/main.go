@@ -0,0 +1,202 @@
+// Command tdigest computes quantiles of numbers read from files or standard
+// input with a t-digest, in memory bounded by the compression however large
+// the input is.
+//
+// Usage:
+//
+//	tdigest [flags] [file ...]
+//
+// Numbers are separated by spaces or newlines. Tokens that are not numbers
+// are skipped and counted on standard error. Digests can be saved and later
+// loaded or merged, to build one digest across several runs:
+//
+//	tdigest -save a.td a.log
+//	tdigest -save b.td b.log
+//	tdigest -merge -q 0.5,0.99 a.td b.td
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/tdigest"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command with the arguments args and returns its exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("tdigest", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	compression := fs.Float64("compression", 1000, "compression of the digest, unless it is loaded with -load")
+	quantiles := fs.String("q", "0.5,0.9,0.99", "comma-separated `quantiles` to print")
+	cdfs := fs.String("cdf", "", "comma-separated `values` at which to print the CDF")
+	load := fs.String("load", "", "start from the digest saved in `file`")
+	save := fs.String("save", "", "save the digest to `file`")
+	merge := fs.Bool("merge", false, "read saved digests rather than numbers from the files")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: tdigest [flags] [file ...]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	qs, err := parseList(*quantiles)
+	if err != nil {
+		fmt.Fprintf(stderr, "tdigest: -q: %v\n", err)
+		return 2
+	}
+	xs, err := parseList(*cdfs)
+	if err != nil {
+		fmt.Fprintf(stderr, "tdigest: -cdf: %v\n", err)
+		return 2
+	}
+	if *merge && fs.NArg() == 0 {
+		fmt.Fprintf(stderr, "tdigest: -merge needs files to merge\n")
+		return 2
+	}
+
+	td, err := tdigest.NewChecked(*compression)
+	if err != nil {
+		fmt.Fprintf(stderr, "tdigest: -compression: %v\n", err)
+		return 2
+	}
+	if *load != "" {
+		if td, err = loadDigest(*load); err != nil {
+			fmt.Fprintf(stderr, "tdigest: %v\n", err)
+			return 1
+		}
+	}
+
+	var skipped int
+	switch {
+	case *merge:
+		for _, name := range fs.Args() {
+			o, err := loadDigest(name)
+			if err != nil {
+				fmt.Fprintf(stderr, "tdigest: %v\n", err)
+				return 1
+			}
+			td.Merge(o)
+		}
+	case fs.NArg() == 0:
+		if skipped, err = addNumbers(td, stdin); err != nil {
+			fmt.Fprintf(stderr, "tdigest: reading standard input: %v\n", err)
+			return 1
+		}
+	default:
+		for _, name := range fs.Args() {
+			n, err := addFile(td, name)
+			skipped += n
+			if err != nil {
+				fmt.Fprintf(stderr, "tdigest: %v\n", err)
+				return 1
+			}
+		}
+	}
+	if skipped > 0 {
+		fmt.Fprintf(stderr, "tdigest: skipped %d malformed values\n", skipped)
+	}
+
+	if *save != "" {
+		b, err := td.MarshalBinary()
+		if err == nil {
+			err = ioutil.WriteFile(*save, b, 0666)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "tdigest: %v\n", err)
+			return 1
+		}
+	}
+
+	w := bufio.NewWriter(stdout)
+	fmt.Fprintf(w, "count\t%g\n", td.Count())
+	if td.Count() > 0 {
+		fmt.Fprintf(w, "min\t%g\nmax\t%g\n", td.Min(), td.Max())
+	}
+	for _, q := range qs {
+		fmt.Fprintf(w, "q(%g)\t%g\n", q, td.Quantile(q))
+	}
+	for _, x := range xs {
+		fmt.Fprintf(w, "cdf(%g)\t%g\n", x, td.CDF(x))
+	}
+	if err := w.Flush(); err != nil {
+		fmt.Fprintf(stderr, "tdigest: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// parseList parses a comma-separated list of numbers.
+func parseList(s string) ([]float64, error) {
+	var xs []float64
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		x, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, err
+		}
+		xs = append(xs, x)
+	}
+	return xs, nil
+}
+
+// loadDigest reads a digest saved with -save.
+func loadDigest(name string) (*tdigest.TDigest, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	td, err := tdigest.FromBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return td, nil
+}
+
+// addFile adds the numbers in the named file to td and returns the number of
+// tokens skipped.
+func addFile(td *tdigest.TDigest, name string) (int, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	skipped, err := addNumbers(td, f)
+	if err != nil {
+		return skipped, fmt.Errorf("%s: %w", name, err)
+	}
+	return skipped, nil
+}
+
+// maxToken is the longest token read. Longer ones fail the read rather than
+// being buffered.
+const maxToken = 1 << 16
+
+// addNumbers adds the numbers read from r to td and returns the number of
+// tokens that were not numbers, or NaN, and so were skipped.
+func addNumbers(td *tdigest.TDigest, r io.Reader) (int, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 4096), maxToken)
+	s.Split(bufio.ScanWords)
+	skipped := 0
+	for s.Scan() {
+		x, err := strconv.ParseFloat(s.Text(), 64)
+		if err != nil || x != x {
+			skipped++
+			continue
+		}
+		td.Add(x, 1)
+	}
+	return skipped, s.Err()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runCommand runs the command with args and input and returns its status,
+// standard output and standard error.
+func runCommand(args []string, input string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	status := run(args, strings.NewReader(input), &stdout, &stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+func TestRun(t *testing.T) {
+	status, out, errs := runCommand([]string{"-q", "0,1", "-cdf", "0,10"}, "1 2\n3 x\n4\n\nNaN 5")
+	if status != 0 {
+		t.Fatalf("exit status %d: %s", status, errs)
+	}
+	want := "count\t5\nmin\t1\nmax\t5\nq(0)\t1\nq(1)\t5\ncdf(0)\t0\ncdf(10)\t1\n"
+	if out != want {
+		t.Errorf("unexpected output\n%s\nwant\n%s", out, want)
+	}
+	if !strings.Contains(errs, "skipped 2 malformed values") {
+		t.Errorf("unexpected standard error %q", errs)
+	}
+}
+
+func TestRun_SaveMerge(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.td"), filepath.Join(dir, "b.td")
+	data := filepath.Join(dir, "b.log")
+	if err := ioutil.WriteFile(data, []byte("4 5 6\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if status, _, errs := runCommand([]string{"-save", a}, "1 2 3"); status != 0 {
+		t.Fatal(errs)
+	}
+	if status, _, errs := runCommand([]string{"-save", b, data}, ""); status != 0 {
+		t.Fatal(errs)
+	}
+
+	want := "count\t6\nmin\t1\nmax\t6\nq(0)\t1\nq(1)\t6\n"
+	for _, args := range [][]string{
+		{"-merge", "-q", "0,1", a, b},
+		{"-load", a, "-q", "0,1", data},
+	} {
+		status, out, errs := runCommand(args, "")
+		if status != 0 {
+			t.Fatalf("%v: exit status %d: %s", args, status, errs)
+		}
+		if out != want {
+			t.Errorf("%v: unexpected output\n%s\nwant\n%s", args, out, want)
+		}
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"-q", "x"},
+		{"-compression", "0"},
+		{"-merge"},
+		{"-load", filepath.Join(dir, "missing.td")},
+		{filepath.Join(dir, "missing.log")},
+	} {
+		if status, _, _ := runCommand(args, ""); status == 0 {
+			t.Errorf("%v: succeeded", args)
+		}
+	}
+}
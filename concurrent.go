@@ -0,0 +1,273 @@
+package tdigest
+
+import (
+	"sync"
+	"time"
+)
+
+// ConcurrentTDigest is a TDigest that is safe for concurrent use. Queries of a
+// digest with no pending data share a read lock; everything else, including
+// queries that first have to process pending data, takes the write lock.
+type ConcurrentTDigest struct {
+	mu sync.RWMutex
+	t  *TDigest
+
+	stop chan struct{} // closed to stop background compaction
+	done chan struct{} // closed when background compaction has stopped
+
+	// moved returns the digest that takes the adds of this one once a
+	// DigestVec has evicted it, and is nil until then.
+	moved func() *ConcurrentTDigest
+}
+
+// NewConcurrent returns a ConcurrentTDigest with the given compression and
+// options.
+func NewConcurrent(compression float64, opts ...Option) *ConcurrentTDigest {
+	t := NewWithCompression(compression, opts...)
+	t.queueHooks = true
+	return &ConcurrentTDigest{t: t}
+}
+
+// unlock releases the write lock and then runs the hooks triggered while it
+// was held.
+func (c *ConcurrentTDigest) unlock() {
+	events := c.t.takeHookEvents()
+	c.mu.Unlock()
+	c.t.runHooks(events)
+}
+
+// lockAdd acquires the write lock of the digest that data added to c goes
+// to, and returns it: c itself, unless a DigestVec has evicted c, in which
+// case it is the digest of the same labels that replaced it.
+func (c *ConcurrentTDigest) lockAdd() *ConcurrentTDigest {
+	for {
+		c.mu.Lock()
+		if c.moved == nil {
+			return c
+		}
+		moved := c.moved
+		c.mu.Unlock()
+		c = moved()
+	}
+}
+
+func (c *ConcurrentTDigest) Add(x, w float64) {
+	c = c.lockAdd()
+	c.t.Add(x, w)
+	c.unlock()
+}
+
+// AddN adds x with a weight of n as TDigest.AddN does.
+func (c *ConcurrentTDigest) AddN(x float64, n uint64) {
+	c = c.lockAdd()
+	c.t.AddN(x, n)
+	c.unlock()
+}
+
+// Observe adds x with a weight of 1. It makes the digest an Observer in the
+// sense of the Prometheus client library.
+func (c *ConcurrentTDigest) Observe(x float64) {
+	c.Add(x, 1)
+}
+
+func (c *ConcurrentTDigest) AddValues(xs []float64) {
+	c = c.lockAdd()
+	c.t.AddValues(xs)
+	c.unlock()
+}
+
+func (c *ConcurrentTDigest) AddCentroid(centroid Centroid) {
+	c = c.lockAdd()
+	c.t.AddCentroid(centroid)
+	c.unlock()
+}
+
+func (c *ConcurrentTDigest) AddCentroidList(l CentroidList) {
+	c = c.lockAdd()
+	c.t.AddCentroidList(l)
+	c.unlock()
+}
+
+// Merge adds the data of o. The caller must ensure o is not modified
+// concurrently.
+func (c *ConcurrentTDigest) Merge(o *TDigest) {
+	c = c.lockAdd()
+	c.t.Merge(o)
+	c.unlock()
+}
+
+// MergeBytes adds the data of the digest encoded in data, as
+// TDigest.MergeBytes does.
+func (c *ConcurrentTDigest) MergeBytes(data []byte) error {
+	c = c.lockAdd()
+	err := c.t.MergeBytes(data)
+	c.unlock()
+	return err
+}
+
+func (c *ConcurrentTDigest) Reset() {
+	c.mu.Lock()
+	c.t.Reset()
+	c.mu.Unlock()
+}
+
+func (c *ConcurrentTDigest) Flush() {
+	c.mu.Lock()
+	c.t.Flush()
+	c.unlock()
+}
+
+// StartBackgroundCompaction starts a goroutine that processes pending data
+// every interval. While it runs, adds only process synchronously once the
+// buffer of pending data is full, which keeps compression off the ingestion
+// path for bursty writers. Queries are unaffected: they still process any
+// pending data first. It does nothing if background compaction is already
+// running.
+func (c *ConcurrentTDigest) StartBackgroundCompaction(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil {
+		return
+	}
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+	c.t.background = true
+	go c.compact(interval, c.stop, c.done)
+}
+
+func (c *ConcurrentTDigest) compact(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.Flush()
+		}
+	}
+}
+
+// Stop stops background compaction and waits for the goroutine, including
+// any compaction in progress, to finish.
+func (c *ConcurrentTDigest) Stop() {
+	c.mu.Lock()
+	stop, done := c.stop, c.done
+	c.stop, c.done = nil, nil
+	c.t.background = false
+	c.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// rlock acquires the read lock once there is no pending data, so that the
+// query methods of the digest only read it. It processes pending data under
+// the write lock if needed.
+func (c *ConcurrentTDigest) rlock() {
+	c.mu.RLock()
+	for c.t.dirty {
+		c.mu.RUnlock()
+		c.mu.Lock()
+		c.t.Flush()
+		c.unlock()
+		c.mu.RLock()
+	}
+}
+
+func (c *ConcurrentTDigest) Quantile(q float64) float64 {
+	c.rlock()
+	defer c.mu.RUnlock()
+	return c.t.Quantile(q)
+}
+
+func (c *ConcurrentTDigest) CDF(x float64) float64 {
+	c.rlock()
+	defer c.mu.RUnlock()
+	return c.t.CDF(x)
+}
+
+func (c *ConcurrentTDigest) Count() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.t.Count()
+}
+
+// ExactCount returns the total weight as an integer, and whether it is
+// exact, as TDigest.ExactCount does.
+func (c *ConcurrentTDigest) ExactCount() (n uint64, exact bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.t.ExactCount()
+}
+
+// Export returns a copy of the centroids of the digest.
+func (c *ConcurrentTDigest) Export() CentroidList {
+	c.rlock()
+	defer c.mu.RUnlock()
+	return c.t.processed.Clone()
+}
+
+// ExportSnapshot returns a copy of the centroids as TDigest.ExportSnapshot
+// does, without processing pending data.
+func (c *ConcurrentTDigest) ExportSnapshot() CentroidList {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.t.ExportSnapshot()
+}
+
+// ExportUnmerged returns copies of the processed and pending centroids as
+// TDigest.ExportUnmerged does, without processing pending data.
+func (c *ConcurrentTDigest) ExportUnmerged() (processed, unprocessed CentroidList) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.t.ExportUnmerged()
+}
+
+// ForEachCentroid calls fn for each centroid as TDigest.ForEachCentroid does,
+// holding the read lock. fn must not call methods of c that modify it.
+func (c *ConcurrentTDigest) ForEachCentroid(fn func(Centroid) bool) {
+	c.rlock()
+	defer c.mu.RUnlock()
+	c.t.ForEachCentroid(fn)
+}
+
+// Snapshot returns an immutable copy of the state of the digest, taken under
+// the lock so that it is self-consistent.
+func (c *ConcurrentTDigest) Snapshot() *FrozenDigest {
+	c.mu.Lock()
+	defer c.unlock()
+	return c.t.Snapshot()
+}
+
+// SnapshotAndReset returns a Snapshot of the digest and resets it under a
+// single acquisition of the lock, so that every sample is counted in exactly
+// one snapshot even with concurrent adds.
+func (c *ConcurrentTDigest) SnapshotAndReset() *FrozenDigest {
+	c.mu.Lock()
+	defer c.unlock()
+	return c.t.SnapshotAndReset()
+}
+
+func (c *ConcurrentTDigest) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.t.Stats()
+}
+
+// String formats the digest under the read lock, so that it is formatted from
+// a consistent state.
+func (c *ConcurrentTDigest) String() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.t.String()
+}
+
+// DebugString formats every centroid of the digest under the read lock.
+func (c *ConcurrentTDigest) DebugString() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.t.DebugString()
+}
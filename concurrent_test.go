@@ -0,0 +1,133 @@
+package tdigest_test
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/tdigest"
+	"go.uber.org/goleak"
+)
+
+func TestConcurrentTDigest(t *testing.T) {
+	const writers, readers, values = 4, 4, 20000
+	td := tdigest.NewConcurrent(100)
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			data := NormalData[w*values : (w+1)*values]
+			for i, x := range data {
+				switch i % 3 {
+				case 0:
+					td.Add(x, 1)
+				case 1:
+					td.AddCentroid(tdigest.Centroid{Mean: x, Weight: 1})
+				default:
+					td.AddValues(data[i : i+1])
+				}
+			}
+		}(w)
+	}
+	done := make(chan struct{})
+	var readersWG sync.WaitGroup
+	for r := 0; r < readers; r++ {
+		readersWG.Add(1)
+		go func() {
+			defer readersWG.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if q := td.Quantile(0.5); !math.IsNaN(q) && (q < 0 || q > 20) {
+					t.Errorf("unexpected median %g", q)
+				}
+				if p := td.CDF(10); p < 0 || p > 1 {
+					t.Errorf("unexpected CDF %g", p)
+				}
+				td.Count()
+				td.Export()
+				td.ForEachCentroid(func(tdigest.Centroid) bool { return true })
+				td.Stats()
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	readersWG.Wait()
+
+	if got, want := td.Count(), float64(writers*values); got != want {
+		t.Errorf("unexpected count, got %g want %g", got, want)
+	}
+	td.Reset()
+	if got := td.Count(); got != 0 {
+		t.Errorf("unexpected count after reset: %g", got)
+	}
+}
+
+func TestConcurrentTDigest_BackgroundCompaction(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	td := tdigest.NewConcurrent(100)
+	// Stopping without having started is a no-op.
+	td.Stop()
+
+	// With a long interval the weight trigger no longer processes on add.
+	td.StartBackgroundCompaction(time.Hour)
+	td.StartBackgroundCompaction(time.Hour)
+	for _, x := range NormalData[:100] {
+		td.Add(x, 1)
+	}
+	if got := td.Stats().Unprocessed; got != 100 {
+		t.Errorf("unexpected unprocessed centroids, got %d want 100", got)
+	}
+	td.Stop()
+
+	td.StartBackgroundCompaction(time.Millisecond)
+	defer td.Stop()
+	for _, x := range NormalData[100:10000] {
+		td.Add(x, 1)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for td.Stats().Unprocessed != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("background compaction did not process pending data")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got, want := td.Count(), 10000.0; got != want {
+		t.Errorf("unexpected count, got %g want %g", got, want)
+	}
+	if q := td.Quantile(0.5); math.Abs(q-10) > 0.1 {
+		t.Errorf("unexpected median %g", q)
+	}
+}
+
+func TestConcurrentTDigest_Logging(t *testing.T) {
+	td := tdigest.NewConcurrent(100)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, x := range NormalData[:20000] {
+			td.Add(x, 1)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			if got, want := len(td.ExportSnapshot()), td.Stats().Processed+td.Stats().Unprocessed; got != want {
+				t.Errorf("unexpected number of centroids, got %d want %d", got, want)
+			}
+			return
+		default:
+		}
+		_ = fmt.Sprint(td)
+		td.ExportSnapshot()
+	}
+}
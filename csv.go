@@ -0,0 +1,149 @@
+package tdigest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidCSV is returned by ImportCSV for input that does not describe a
+// digest.
+const ErrInvalidCSV = Error("invalid CSV digest")
+
+// ExportCSV processes pending data and writes the centroids of t as CSV, as
+// FrozenDigest.ExportCSV does.
+func (t *TDigest) ExportCSV(w io.Writer) error {
+	t.Flush()
+	f := t.frozen()
+	return f.ExportCSV(w)
+}
+
+// ExportCSV writes the centroids of the snapshot as CSV with the columns
+// mean, weight and cumulative, the last being the total weight of the
+// centroid and those before it. The minimum and maximum come first, on lines
+// of the form "# min=1.5", which readers such as pandas skip as comments.
+// Numbers are written with as many digits as needed to read them back
+// exactly.
+func (f *FrozenDigest) ExportCSV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if f.processed.Len() > 0 {
+		fmt.Fprintf(bw, "# min=%s\n# max=%s\n", formatCSV(f.min), formatCSV(f.max))
+	}
+	bw.WriteString("mean,weight,cumulative\n")
+	var cumulative kahanSum
+	for _, c := range f.processed {
+		cumulative.add(c.Weight)
+		fmt.Fprintf(bw, "%s,%s,%s\n", formatCSV(c.Mean), formatCSV(c.Weight), formatCSV(cumulative.value()))
+	}
+	return bw.Flush()
+}
+
+func formatCSV(x float64) string {
+	return strconv.FormatFloat(x, 'g', -1, 64)
+}
+
+// ImportCSV returns a digest with the given compression holding the
+// centroids written by ExportCSV. It needs a header naming the mean and
+// weight columns, in any order; other columns, including cumulative, are
+// ignored. Lines may end in CRLF. Without min and max comments the bounds are
+// taken to be the first and last means.
+//
+// It returns an error wrapping ErrInvalidCSV, and giving the line number, for
+// a malformed line, a mean that is not finite or is less than the one before,
+// a weight that is not positive and finite, or bounds that do not enclose the
+// means, and one wrapping ErrInvalidCSV if the decoded digest fails
+// CheckInvariants.
+func ImportCSV(r io.Reader, compression float64) (*TDigest, error) {
+	s := bufio.NewScanner(r)
+	min, max := math.NaN(), math.NaN()
+	meanCol, weightCol, columns := -1, -1, 0
+	var l CentroidList
+	line := 0
+	fail := func(format string, args ...interface{}) error {
+		return fmt.Errorf("line %d: %s: %w", line, fmt.Sprintf(format, args...), ErrInvalidCSV)
+	}
+	for s.Scan() {
+		line++
+		text := strings.TrimSpace(s.Text())
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "#") {
+			kv := strings.SplitN(strings.TrimSpace(text[1:]), "=", 2)
+			if len(kv) != 2 || (kv[0] != "min" && kv[0] != "max") {
+				continue
+			}
+			x, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+			if err != nil || math.IsNaN(x) {
+				return nil, fail("invalid %s %q", kv[0], kv[1])
+			}
+			if kv[0] == "min" {
+				min = x
+			} else {
+				max = x
+			}
+			continue
+		}
+		fields := strings.Split(text, ",")
+		if meanCol < 0 {
+			for i, name := range fields {
+				switch strings.ToLower(strings.Trim(strings.TrimSpace(name), `"`)) {
+				case "mean":
+					meanCol = i
+				case "weight":
+					weightCol = i
+				}
+			}
+			if meanCol < 0 || weightCol < 0 {
+				return nil, fail("header %q lacks a mean or weight column", text)
+			}
+			columns = len(fields)
+			continue
+		}
+		if len(fields) != columns {
+			return nil, fail("%d fields, want %d", len(fields), columns)
+		}
+		mean, err := strconv.ParseFloat(strings.TrimSpace(fields[meanCol]), 64)
+		if err != nil || math.IsNaN(mean) || math.IsInf(mean, 0) {
+			return nil, fail("invalid mean %q", fields[meanCol])
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(fields[weightCol]), 64)
+		if err != nil || !validWeight(weight) {
+			return nil, fail("invalid weight %q", fields[weightCol])
+		}
+		if n := len(l); n > 0 && mean < l[n-1].Mean {
+			return nil, fail("mean %v is less than the one before, %v", mean, l[n-1].Mean)
+		}
+		l = append(l, Centroid{Mean: mean, Weight: weight})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if meanCol < 0 {
+		return nil, fail("no header")
+	}
+
+	t := NewWithCompression(compression)
+	if len(l) == 0 {
+		return t, nil
+	}
+	if math.IsNaN(min) {
+		min = l[0].Mean
+	}
+	if math.IsNaN(max) {
+		max = l[len(l)-1].Mean
+	}
+	if !(min <= l[0].Mean && max >= l[len(l)-1].Mean) {
+		return nil, fail("bounds [%v, %v] do not enclose the means", min, max)
+	}
+	t.AddCentroidList(l)
+	t.updateBounds(min)
+	t.updateBounds(max)
+	if err := checkDecoded(t, ErrInvalidCSV); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
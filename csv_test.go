@@ -0,0 +1,71 @@
+package tdigest_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestTdigest_ExportCSV(t *testing.T) {
+	td := tdigest.NewWithCompression(1000)
+	td.AddValues(NormalData)
+	var buf bytes.Buffer
+	if err := td.ExportCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "\nmean,weight,cumulative\n") {
+		t.Errorf("missing header in\n%.200s", buf.String())
+	}
+	back, err := tdigest.ImportCSV(&buf, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Count() != td.Count() || back.Min() != td.Min() || back.Max() != td.Max() {
+		t.Errorf("unexpected digest %v", back)
+	}
+	for q := 0.0; q <= 1; q += 0.001 {
+		if got, want := back.Quantile(q), td.Quantile(q); got != want {
+			t.Errorf("q=%v: got %v, want %v", q, got, want)
+		}
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	// Scientific notation, CRLF, reordered columns and no cumulative.
+	in := "weight,mean\r\n1e0,-1.5E-1\r\n2,2.5e+1\r\n\r\n"
+	td, err := tdigest.ImportCSV(strings.NewReader(in), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if td.Count() != 3 || td.Min() != -0.15 || td.Max() != 25 {
+		t.Errorf("unexpected digest %v [%v, %v]", td, td.Min(), td.Max())
+	}
+
+	empty, err := tdigest.ImportCSV(strings.NewReader("mean,weight,cumulative\n"), 100)
+	if err != nil || empty.Count() != 0 {
+		t.Errorf("unexpected empty digest %v, %v", empty, err)
+	}
+}
+
+func TestImportCSV_Invalid(t *testing.T) {
+	for in, wantLine := range map[string]string{
+		"":                              "line 0:",
+		"mean,count\n1,1\n":             "line 1:",
+		"mean,weight\n1,1\nx,1\n":       "line 3:",
+		"mean,weight\n1,0\n":            "line 2:",
+		"mean,weight\n1,1,1\n":          "line 2:",
+		"mean,weight\n2,1\n\n1,1\n":     "line 4:",
+		"mean,weight\nInf,1\n":          "line 2:",
+		"# min=x\nmean,weight\n":        "line 1:",
+		"# min=5\nmean,weight\n1,1\n":   "line 3:",
+		"# max=0.5\nmean,weight\n1,1\n": "line 3:",
+	} {
+		_, err := tdigest.ImportCSV(strings.NewReader(in), 100)
+		if !errors.Is(err, tdigest.ErrInvalidCSV) || !strings.HasPrefix(err.Error(), wantLine) {
+			t.Errorf("%q: unexpected error %v, want one at %s", in, err, wantLine)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package tdigest
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// DebugDump writes every part of the state of t to w in aligned columns, to
+// attach to bug reports: its parameters, counts, bounds and buffer
+// capacities, then a table of the processed centroids with the cumulative
+// weight and range of quantiles each covers, then the pending centroids in
+// the order they were added. If maxCentroids is positive, each table shows
+// at most that many centroids, eliding the middle. It does not modify t.
+func (t *TDigest) DebugDump(w io.Writer, maxCentroids int) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	s := t.Stats()
+	processed := t.processedWeight.value()
+	fmt.Fprintf(tw, "compression\t%g\n", t.compression)
+	fmt.Fprintf(tw, "count\t%g\t(processed %g, pending %g)\n", t.Count(), processed, t.unprocessedWeight.value())
+	fmt.Fprintf(tw, "min\t%g\n", t.Min())
+	fmt.Fprintf(tw, "max\t%g\n", t.Max())
+	fmt.Fprintf(tw, "dropped\t%d\n", s.Dropped)
+	fmt.Fprintf(tw, "processes\t%d\n", s.Processes)
+	fmt.Fprintf(tw, "processed\t%d\t(capacity %d, limit %d)\n", s.Processed, s.ProcessedCap, t.maxProcessed)
+	fmt.Fprintf(tw, "pending\t%d\t(capacity %d, limit %d)\n", s.Unprocessed, s.UnprocessedCap, t.maxUnprocessed)
+	fmt.Fprintf(tw, "scratch\t(capacity %d)\n", s.ScratchCap)
+	fmt.Fprintf(tw, "cumulative\t(capacity %d)\n", s.CumulativeCap)
+
+	fmt.Fprintf(tw, "\nprocessed centroids\n")
+	fmt.Fprintf(tw, "index\tmean\tweight\tcumulative\tquantiles\n")
+	var cumulative kahanSum
+	elide(tw, 5, t.processed.Len(), maxCentroids, func(i int) {
+		c := t.processed[i]
+		lo := cumulative.value()
+		cumulative.add(c.Weight)
+		hi := cumulative.value()
+		fmt.Fprintf(tw, "%d\t%g\t%g\t%g\t[%.6g, %.6g]\n", i, c.Mean, c.Weight, hi, lo/processed, hi/processed)
+	}, func(i int) {
+		cumulative.add(t.processed[i].Weight)
+	})
+
+	fmt.Fprintf(tw, "\npending centroids\n")
+	fmt.Fprintf(tw, "index\tmean\tweight\n")
+	elide(tw, 3, t.unprocessed.Len(), maxCentroids, func(i int) {
+		c := t.unprocessed[i]
+		fmt.Fprintf(tw, "%d\t%g\t%g\n", i, c.Mean, c.Weight)
+	}, func(int) {})
+	return tw.Flush()
+}
+
+// elide calls show for the rows [0, n) of a table of the given number of
+// columns that shows at most max rows, the first and last ones if max is
+// positive and less than n, and skip for the others, writing a line in their
+// place. The calls are in order.
+func elide(w io.Writer, columns, n, max int, show, skip func(i int)) {
+	if max <= 0 || max >= n {
+		max = n
+	}
+	head, tail := (max+1)/2, max/2
+	for i := 0; i < n; i++ {
+		switch {
+		case i < head || i >= n-tail:
+			show(i)
+		case i == head:
+			fmt.Fprintf(w, "%s(%d elided)\n", strings.Repeat("...\t", columns-1), n-max)
+			fallthrough
+		default:
+			skip(i)
+		}
+	}
+}
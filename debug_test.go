@@ -0,0 +1,71 @@
+package tdigest_test
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// checkGolden compares got with the named file in testdata, or writes it
+// there with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s; got\n%s", path, got)
+	}
+}
+
+func TestTdigest_DebugDump(t *testing.T) {
+	td := tdigest.NewWithCompression(10)
+	for i := 0; i < 100; i++ {
+		td.Add(float64(i*i%97), 1)
+	}
+	td.Flush()
+	td.AddValues([]float64{5, 1.5, 200})
+	td.Add(0, 0)
+
+	before := td.ExportSnapshot()
+	var buf bytes.Buffer
+	if err := td.DebugDump(&buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "debugdump.golden", buf.Bytes())
+
+	buf.Reset()
+	if err := td.DebugDump(&buf, 5); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "debugdump_elided.golden", buf.Bytes())
+
+	if after := td.ExportSnapshot(); !equalCentroids(before, after) {
+		t.Error("DebugDump modified the digest")
+	}
+}
+
+func equalCentroids(a, b tdigest.CentroidList) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
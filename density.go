@@ -0,0 +1,74 @@
+package tdigest
+
+import "math"
+
+// DensityAtQuantile processes pending data and returns the slope of Quantile
+// at q, as FrozenDigest.DensityAtQuantile does.
+func (t *TDigest) DensityAtQuantile(q float64) float64 {
+	t.Flush()
+	f := t.frozen()
+	return f.DensityAtQuantile(q)
+}
+
+// DensityAtQuantile returns the derivative of Quantile with respect to q,
+// the quantile density, which is also 1/PDF(Quantile(q)). It is large where
+// the values are sparse and small where they are dense, so it shows where
+// Quantile is steep. Quantile interpolates linearly between centroids, and
+// this is the slope of the segment q falls on: the span between the means of
+// two neighbouring centroids over the fraction of the weight between them,
+// or between the minimum or maximum and the outer centroids in the tails.
+// At the point between two segments it is the slope of the one Quantile
+// takes that point from.
+//
+// Where Quantile is flat, as it is across a point mass, the result is zero.
+// It returns NaN for an empty digest and for a q that is NaN or outside
+// [0, 1].
+func (f *FrozenDigest) DensityAtQuantile(q float64) float64 {
+	if f.processed.Len() == 0 || !(q >= 0 && q <= 1) {
+		return math.NaN()
+	}
+	return f.quantileSlope(q*f.count) * f.count
+}
+
+// quantileSlope returns the slope of quantile with respect to the weight
+// index, following the same segments.
+func (f *FrozenDigest) quantileSlope(index float64) float64 {
+	if index <= f.cumulative[0] {
+		return (f.processed[0].Mean - f.min) / f.cumulative[0]
+	}
+
+	lower := CumulativeIndex(index, f.cumulative)
+	if lower == 0 {
+		lower = 1
+	}
+	if lower == len(f.cumulative) {
+		lower--
+	}
+
+	if lower+1 != len(f.cumulative) {
+		if f.ranges != nil {
+			ra, rb := f.ranges[lower-1], f.ranges[lower]
+			if ra.Max <= rb.Min {
+				left, right := f.cumulative[lower-1], f.cumulative[lower]
+				mid := f.weightBefore(lower)
+				if index <= mid {
+					return (ra.Max - f.processed[lower-1].Mean) / (mid - left)
+				}
+				return (f.processed[lower].Mean - rb.Min) / (right - mid)
+			}
+		}
+		left, right := f.cumulative[lower-1], f.cumulative[lower]
+		if f.processed[lower-1].Mean == f.min {
+			left = f.weightBefore(lower)
+		}
+		if f.processed[lower].Mean == f.max {
+			right = f.weightBefore(lower)
+		}
+		if index <= left || index >= right {
+			return 0
+		}
+		return (f.processed[lower].Mean - f.processed[lower-1].Mean) / (right - left)
+	}
+
+	return (f.max - f.processed[lower-1].Mean) / (f.count - f.cumulative[lower-1])
+}
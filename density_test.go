@@ -0,0 +1,109 @@
+package tdigest_test
+
+import (
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestTdigest_DensityAtQuantile(t *testing.T) {
+	sortedUniform := append([]float64(nil), UniformData...)
+	sort.Float64s(sortedUniform)
+	for _, tt := range []struct {
+		name  string
+		data  []float64
+		opts  []tdigest.Option
+		truth func(q float64) float64 // 1/PDF(Quantile(q))
+	}{
+		{
+			name: "normal",
+			data: NormalData,
+			truth: func(q float64) float64 {
+				z := math.Sqrt2 * math.Erfinv(2*q-1)
+				return Sigma * math.Sqrt(2*math.Pi) * math.Exp(z*z/2)
+			},
+		},
+		{
+			name:  "uniform",
+			data:  UniformData,
+			truth: func(float64) float64 { return 100 },
+		},
+		{
+			// Sorted input gives centroids whose ranges do not overlap,
+			// which Quantile interpolates through.
+			name:  "sorted uniform with ranges",
+			data:  sortedUniform,
+			opts:  []tdigest.Option{tdigest.WithCentroidRanges()},
+			truth: func(float64) float64 { return 100 },
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			td := tdigest.NewWithCompression(100, tt.opts...)
+			for _, x := range tt.data {
+				td.Add(x, 1)
+			}
+			// DensityAtQuantile is the slope of Quantile, which finite
+			// differences match except across the joins of its segments.
+			var points, mismatches int
+			var sumErr, maxErr float64
+			for q := 0.01; q < 0.99; q += 0.001 {
+				got := td.DensityAtQuantile(q)
+				const h = 1e-7
+				numeric := (td.Quantile(q+h) - td.Quantile(q-h)) / (2 * h)
+				if math.Abs(got/numeric-1) > 1e-3 {
+					mismatches++
+				}
+				relErr := math.Abs(got/tt.truth(q) - 1)
+				sumErr += relErr
+				maxErr = math.Max(maxErr, relErr)
+				points++
+			}
+			if mismatches > points/100 {
+				t.Errorf("%d of %d slopes differ from finite differences of Quantile", mismatches, points)
+			}
+			// The slope of each segment is an estimate from two centroids.
+			if meanErr := sumErr / float64(points); meanErr > 0.03 || maxErr > 0.2 {
+				t.Errorf("relative error %g on average and %g at most from the true density", meanErr, maxErr)
+			}
+			for _, q := range []float64{0, 1e-6, 1 - 1e-6, 1} {
+				if got := td.DensityAtQuantile(q); !(got > 0) || math.IsInf(got, 0) {
+					t.Errorf("DensityAtQuantile(%g) = %g in the tail", q, got)
+				}
+			}
+		})
+	}
+}
+
+func TestTdigest_DensityAtQuantilePointMass(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	td.Add(0, 4000)
+	for i := 1; i <= 6000; i++ {
+		td.Add(float64(i)/6000, 1)
+	}
+	for _, q := range []float64{0, 0.1, 0.2, 0.3} {
+		if got := td.DensityAtQuantile(q); got != 0 {
+			t.Errorf("DensityAtQuantile(%g) = %g inside the point mass, want 0", q, got)
+		}
+	}
+	if got := td.DensityAtQuantile(0.7); !(got > 0) || math.Abs(got-1/0.6) > 0.2 {
+		t.Errorf("DensityAtQuantile(0.7) = %g, want about %g", got, 1/0.6)
+	}
+
+	constant := tdigest.New()
+	constant.Add(3, 10)
+	for _, q := range []float64{0, 0.5, 1} {
+		if got := constant.DensityAtQuantile(q); got != 0 {
+			t.Errorf("constant digest: DensityAtQuantile(%g) = %g, want 0", q, got)
+		}
+	}
+	for _, q := range []float64{-0.1, 1.1, math.NaN()} {
+		if got := td.DensityAtQuantile(q); !math.IsNaN(got) {
+			t.Errorf("DensityAtQuantile(%g) = %g, want NaN", q, got)
+		}
+	}
+	if got := tdigest.New().DensityAtQuantile(0.5); !math.IsNaN(got) {
+		t.Errorf("empty digest: got %g, want NaN", got)
+	}
+}
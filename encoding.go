@@ -0,0 +1,348 @@
+package tdigest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// ErrInvalidEncoding is returned by FromBytes for data that is not a digest
+// encoded by MarshalBinary.
+const ErrInvalidEncoding = Error("invalid encoded digest")
+
+// encodingVersion is the first field of an encoded digest, and
+// rangesEncodingVersion that of one that keeps centroid ranges.
+const (
+	encodingVersion       = 1
+	rangesEncodingVersion = 2
+)
+
+// encodedHeaderSize is the size of the fields of an encoded digest before its
+// centroids: the version, min, max, compression and number of centroids.
+const encodedHeaderSize = 4 + 8 + 8 + 8 + 4
+
+// MarshalBinary processes pending data and encodes the digest. The encoding
+// is, in big-endian order, a uint32 version of 1, the minimum, maximum and
+// compression as float64s, a uint32 number of centroids and then the weight
+// and mean of each centroid as float64s. Options are not encoded, except
+// that a digest created WithCentroidRanges is encoded with version 2, which
+// follows the mean of each centroid with the minimum and maximum of its
+// range.
+func (t *TDigest) MarshalBinary() ([]byte, error) {
+	t.Flush()
+	f := t.frozen()
+	return f.MarshalBinary()
+}
+
+// MarshalBinary encodes the snapshot as TDigest.MarshalBinary does.
+func (f *FrozenDigest) MarshalBinary() ([]byte, error) {
+	n := f.processed.Len()
+	version, size := uint32(encodingVersion), 16
+	if f.ranges != nil {
+		version, size = rangesEncodingVersion, 32
+	}
+	b := make([]byte, encodedHeaderSize, encodedHeaderSize+size*n)
+	binary.BigEndian.PutUint32(b, version)
+	binary.BigEndian.PutUint64(b[4:], math.Float64bits(f.min))
+	binary.BigEndian.PutUint64(b[12:], math.Float64bits(f.max))
+	binary.BigEndian.PutUint64(b[20:], math.Float64bits(f.compression))
+	binary.BigEndian.PutUint32(b[28:], uint32(n))
+	for i, c := range f.processed {
+		b = appendFloat64(b, c.Weight)
+		b = appendFloat64(b, c.Mean)
+		if f.ranges != nil {
+			b = appendFloat64(b, f.ranges[i].Min)
+			b = appendFloat64(b, f.ranges[i].Max)
+		}
+	}
+	return b, nil
+}
+
+func appendFloat64(b []byte, x float64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], math.Float64bits(x))
+	return append(b, buf[:]...)
+}
+
+// FromBytes returns the digest encoded in data by MarshalBinary, created with
+// the given options. It returns an error wrapping ErrInvalidEncoding if data
+// is truncated or has trailing bytes or an unknown version, or if the decoded
+// digest fails CheckInvariants, and one wrapping ErrInvalidCompression or
+// ErrInvalidCentroid if it holds an invalid compression or centroid. A digest
+// encoded with centroid ranges is returned WithCentroidRanges, and one that
+// is given WithCentroidRanges for data without them counts each centroid as
+// spanning the encoded minimum to maximum.
+func FromBytes(data []byte, opts ...Option) (*TDigest, error) {
+	h, body, err := decodeBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	if h.ranges {
+		opts = append(opts[:len(opts):len(opts)], WithCentroidRanges())
+	}
+	t := NewWithCompression(h.compression, opts...)
+	if err := t.checkEncoded(h, body, 0); err != nil {
+		return nil, err
+	}
+	t.addEncoded(h, body)
+	t.addEncodedBounds(h)
+	if err := checkDecoded(t, ErrInvalidEncoding); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// MergeBytes adds the data of the digest encoded in data by MarshalBinary to
+// t, as Merge would after FromBytes, but reads the centroids straight from
+// data into t without building a digest or a list of centroids. It returns
+// the errors of FromBytes, and adds nothing if data is invalid.
+//
+// Unlike Merge, it has no exact count to take from the encoded digest, so it
+// counts the weights of the centroids for ExactCount as AddCentroid does.
+func (t *TDigest) MergeBytes(data []byte) error {
+	h, body, err := decodeBytes(data)
+	if err != nil {
+		return err
+	}
+	if err := t.checkEncoded(h, body, 0); err != nil {
+		return err
+	}
+	t.addEncoded(h, body)
+	t.addEncodedBounds(h)
+	return nil
+}
+
+// mergeFromChunk is the number of centroids MergeFrom reads at a time.
+const mergeFromChunk = 256
+
+// MergeFrom is like MergeBytes but reads the encoded digest from r, a chunk of
+// centroids at a time. It reads no further than the end of the digest. Each
+// chunk is validated before it is added, so if r fails, or holds an invalid
+// centroid, part way through, the centroids before that chunk have already
+// been added; the error then gives the index of the centroid. A digest that
+// ends early gives an error wrapping ErrInvalidEncoding.
+func (t *TDigest) MergeFrom(r io.Reader) error {
+	var hb [encodedHeaderSize]byte
+	if _, err := io.ReadFull(r, hb[:]); err != nil {
+		return encodedReadError("header", err)
+	}
+	h, err := decodeHeader(hb[:])
+	if err != nil {
+		return err
+	}
+	size := h.centroidSize()
+	buf := make([]byte, mergeFromChunk*size)
+	for i := 0; i < int(h.n); i += mergeFromChunk {
+		n := int(h.n) - i
+		if n > mergeFromChunk {
+			n = mergeFromChunk
+		}
+		chunk := buf[:n*size]
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return encodedReadError(fmt.Sprintf("centroid %d", i), err)
+		}
+		if err := t.checkEncoded(h, chunk, i); err != nil {
+			return err
+		}
+		t.addEncoded(h, chunk)
+	}
+	t.addEncodedBounds(h)
+	return nil
+}
+
+// encodedReadError returns the error of MergeFrom for err from reading what.
+// An encoded digest that ends early is invalid.
+func encodedReadError(what string, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("reading %s: %v: %w", what, err, ErrInvalidEncoding)
+	}
+	return fmt.Errorf("reading %s: %w", what, err)
+}
+
+// encodedHeader holds the fields of an encoded digest before its centroids.
+type encodedHeader struct {
+	ranges      bool // each centroid is followed by its range
+	min, max    float64
+	compression float64
+	n           uint32
+}
+
+// centroidSize returns the size of each encoded centroid.
+func (h encodedHeader) centroidSize() int {
+	if h.ranges {
+		return 32
+	}
+	return 16
+}
+
+// decodeBytes decodes the header of data and returns it with the encoded
+// centroids that follow, checking that there are exactly as many as it
+// says.
+func decodeBytes(data []byte) (encodedHeader, []byte, error) {
+	if len(data) < encodedHeaderSize {
+		return encodedHeader{}, nil, fmt.Errorf("%d bytes: %w", len(data), ErrInvalidEncoding)
+	}
+	h, err := decodeHeader(data)
+	if err != nil {
+		return encodedHeader{}, nil, err
+	}
+	body := data[encodedHeaderSize:]
+	if uint64(len(body)) != uint64(h.centroidSize())*uint64(h.n) {
+		return encodedHeader{}, nil, fmt.Errorf("%d bytes for %d centroids: %w", len(body), h.n, ErrInvalidEncoding)
+	}
+	return h, body, nil
+}
+
+// decodeHeader decodes the first encodedHeaderSize bytes of b, checking its
+// version and compression.
+func decodeHeader(b []byte) (encodedHeader, error) {
+	var h encodedHeader
+	switch v := binary.BigEndian.Uint32(b); v {
+	case encodingVersion:
+	case rangesEncodingVersion:
+		h.ranges = true
+	default:
+		return h, fmt.Errorf("version %d: %w", v, ErrInvalidEncoding)
+	}
+	h.min = math.Float64frombits(binary.BigEndian.Uint64(b[4:]))
+	h.max = math.Float64frombits(binary.BigEndian.Uint64(b[12:]))
+	h.compression = math.Float64frombits(binary.BigEndian.Uint64(b[20:]))
+	h.n = binary.BigEndian.Uint32(b[28:])
+	if !(h.compression >= MinCompression && h.compression <= MaxCompression) {
+		return h, fmt.Errorf("compression %v: %w", h.compression, ErrInvalidCompression)
+	}
+	return h, nil
+}
+
+// decodeCentroid returns the centroid encoded at the start of b, and its
+// range if the header says there is one.
+func (h encodedHeader) decodeCentroid(b []byte) (Centroid, CentroidRange) {
+	c := Centroid{
+		Weight: math.Float64frombits(binary.BigEndian.Uint64(b)),
+		Mean:   math.Float64frombits(binary.BigEndian.Uint64(b[8:])),
+	}
+	r := CentroidRange{h.min, h.max}
+	if h.ranges {
+		r.Min = math.Float64frombits(binary.BigEndian.Uint64(b[16:]))
+		r.Max = math.Float64frombits(binary.BigEndian.Uint64(b[24:]))
+	}
+	return c, r
+}
+
+// checkEncoded returns an error for the first centroid encoded in b that t
+// would drop or whose range does not contain its mean. The centroids are
+// numbered from first.
+func (t *TDigest) checkEncoded(h encodedHeader, b []byte, first int) error {
+	size := h.centroidSize()
+	for i := 0; len(b) >= size; i++ {
+		c, r := h.decodeCentroid(b)
+		b = b[size:]
+		if _, ok := t.admit(c.Mean); !ok || !validWeight(c.Weight) {
+			return fmt.Errorf("centroid %d {Mean: %g, Weight: %g}: %w", first+i, c.Mean, c.Weight, ErrInvalidCentroid)
+		}
+		if h.ranges && !(r.Min <= c.Mean && c.Mean <= r.Max) {
+			return fmt.Errorf("centroid %d has mean %g outside its range [%g, %g]: %w", first+i, c.Mean, r.Min, r.Max, ErrInvalidEncoding)
+		}
+	}
+	return nil
+}
+
+// addEncoded adds the centroids encoded in b, checked by checkEncoded, in
+// chunks as AddCentroidList does. A digest that keeps ranges gives centroids
+// encoded without them the range of the whole encoded digest.
+func (t *TDigest) addEncoded(h encodedHeader, b []byte) {
+	size := h.centroidSize()
+	for len(b) > 0 {
+		n := t.maxUnprocessed + 1 - t.unprocessed.Len()
+		if n > len(b)/size || n <= 0 {
+			n = len(b) / size
+		}
+		for i := 0; i < n; i++ {
+			c, r := h.decodeCentroid(b[i*size:])
+			var ok bool
+			if t.ranges {
+				ok = t.appendCentroidRange(c, r)
+			} else {
+				ok = t.appendCentroid(c)
+			}
+			if ok {
+				t.countWeights(c.Weight, 1)
+			}
+		}
+		b = b[n*size:]
+
+		if t.shouldProcess() {
+			t.process()
+		}
+	}
+}
+
+// addEncodedBounds records the minimum and maximum of an encoded digest
+// that holds any centroids.
+func (t *TDigest) addEncodedBounds(h encodedHeader) {
+	if h.n == 0 {
+		return
+	}
+	for _, x := range [...]float64{h.min, h.max} {
+		if x, ok := t.admit(x); ok {
+			t.updateBounds(x)
+		}
+	}
+}
+
+// SaveFile processes pending data and saves the digest to the named file as
+// FrozenDigest.SaveFile does.
+func (t *TDigest) SaveFile(name string) error {
+	t.Flush()
+	f := t.frozen()
+	return f.SaveFile(name)
+}
+
+// SaveFile writes the snapshot, encoded by MarshalBinary, to the named file.
+// It writes to a temporary file in the same directory and renames it into
+// place, so that readers see either the old file or the whole new one.
+func (f *FrozenDigest) SaveFile(name string) error {
+	b, err := f.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// LoadFile returns the digest saved in the named file by SaveFile, created
+// with the given options. Errors from decoding are as for FromBytes, with the
+// name of the file.
+func LoadFile(name string, opts ...Option) (*TDigest, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	t, err := FromBytes(b, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return t, nil
+}
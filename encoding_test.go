@@ -0,0 +1,213 @@
+package tdigest_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestTdigest_MarshalBinary(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	td.AddValues(NormalData[:10000])
+	b, err := td.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := tdigest.FromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Compression() != 100 || back.Count() != td.Count() || back.Min() != td.Min() || back.Max() != td.Max() {
+		t.Errorf("unexpected digest %v", back)
+	}
+	if !reflect.DeepEqual(back.Centroids(), td.Centroids()) {
+		t.Error("centroids differ after a round trip")
+	}
+
+	empty, err := tdigest.FromBytes(mustMarshal(t, tdigest.NewWithCompression(50)))
+	if err != nil || empty.Count() != 0 || empty.Compression() != 50 {
+		t.Errorf("unexpected empty digest %v, %v", empty, err)
+	}
+}
+
+func mustMarshal(t *testing.T, td *tdigest.TDigest) []byte {
+	b, err := td.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestFromBytes_Invalid(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	td.AddValues([]float64{1, 2, 3})
+	b := mustMarshal(t, td)
+
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"truncated": b[:len(b)-1],
+		"trailing":  append(append([]byte(nil), b...), 0),
+		"version":   append([]byte{0, 0, 0, 3}, b[4:]...),
+	} {
+		if _, err := tdigest.FromBytes(data); !errors.Is(err, tdigest.ErrInvalidEncoding) {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+	}
+
+	compression := append([]byte(nil), b...)
+	copy(compression[20:28], []byte{0, 0, 0, 0, 0, 0, 0, 0})
+	if _, err := tdigest.FromBytes(compression); !errors.Is(err, tdigest.ErrInvalidCompression) {
+		t.Errorf("unexpected error for zero compression %v", err)
+	}
+	weight := append([]byte(nil), b...)
+	copy(weight[32:40], []byte{0, 0, 0, 0, 0, 0, 0, 0})
+	if _, err := tdigest.FromBytes(weight); !errors.Is(err, tdigest.ErrInvalidCentroid) {
+		t.Errorf("unexpected error for zero weight %v", err)
+	}
+}
+
+func TestTdigest_SaveFile(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	td.AddValues(NormalData[:10000])
+	name := filepath.Join(t.TempDir(), "normal.td")
+	if err := td.SaveFile(name); err != nil {
+		t.Fatal(err)
+	}
+	back, err := tdigest.LoadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(mustMarshal(t, back), mustMarshal(t, td)) {
+		t.Error("digest differs after saving and loading")
+	}
+	if b, _ := td.Snapshot().MarshalBinary(); !reflect.DeepEqual(b, mustMarshal(t, td)) {
+		t.Error("snapshot encodes differently from its digest")
+	}
+	entries, err := ioutil.ReadDir(filepath.Dir(name))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v, %v", entries, err)
+	}
+
+	if err := ioutil.WriteFile(name, []byte("junk"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tdigest.LoadFile(name); !errors.Is(err, tdigest.ErrInvalidEncoding) {
+		t.Errorf("got %v, want ErrInvalidEncoding", err)
+	}
+}
+
+func TestTdigest_MergeBytes(t *testing.T) {
+	a := tdigest.NewWithCompression(100)
+	a.AddValues(NormalData[:10000])
+	b := tdigest.NewWithCompression(100)
+	b.AddValues(UniformData[:10000])
+	data := mustMarshal(t, b)
+
+	want := tdigest.NewWithCompression(100)
+	want.Merge(a)
+	decoded, err := tdigest.FromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.Merge(decoded)
+
+	for name, merge := range map[string]func(*tdigest.TDigest) error{
+		"MergeBytes": func(td *tdigest.TDigest) error { return td.MergeBytes(data) },
+		"MergeFrom":  func(td *tdigest.TDigest) error { return td.MergeFrom(bytes.NewReader(data)) },
+	} {
+		got := tdigest.NewWithCompression(100)
+		got.Merge(a)
+		if err := merge(got); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got.Centroids(), want.Centroids()) || got.Min() != want.Min() || got.Max() != want.Max() {
+			t.Errorf("%s: digest %v, want %v", name, got, want)
+		}
+	}
+
+	// MergeFrom stops at the end of the digest.
+	r := bytes.NewReader(append(append([]byte(nil), data...), 1, 2, 3))
+	if err := tdigest.NewWithCompression(100).MergeFrom(r); err != nil || r.Len() != 3 {
+		t.Errorf("MergeFrom: %v, %d bytes left, want 3", err, r.Len())
+	}
+}
+
+func TestTdigest_MergeBytesInvalid(t *testing.T) {
+	src := tdigest.NewWithCompression(1000)
+	src.AddValues(UniformData[:1000])
+	data := mustMarshal(t, src)
+	n := len(src.Centroids())
+	if n <= 256 {
+		t.Fatalf("%d centroids fit in one chunk of MergeFrom", n)
+	}
+	bad := append([]byte(nil), data...)
+	// The weight of the last centroid is 0.
+	copy(bad[len(bad)-16:], make([]byte, 8))
+
+	for name, tc := range map[string]struct {
+		data []byte
+		err  error
+	}{
+		"truncated": {data[:len(data)-1], tdigest.ErrInvalidEncoding},
+		"header":    {data[:10], tdigest.ErrInvalidEncoding},
+		"centroid":  {bad, tdigest.ErrInvalidCentroid},
+	} {
+		td := tdigest.NewWithCompression(100)
+		if err := td.MergeBytes(tc.data); !errors.Is(err, tc.err) || td.Count() != 0 {
+			t.Errorf("MergeBytes %s: %v, count %g", name, err, td.Count())
+		}
+		td = tdigest.NewWithCompression(100)
+		if err := td.MergeFrom(bytes.NewReader(tc.data)); !errors.Is(err, tc.err) {
+			t.Errorf("MergeFrom %s: %v", name, err)
+		}
+	}
+
+	// MergeFrom has added the chunks before the invalid centroid.
+	td := tdigest.NewWithCompression(100)
+	err := td.MergeFrom(bytes.NewReader(bad))
+	if want := fmt.Sprintf("centroid %d ", n-1); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("error %v does not name %q", err, want)
+	}
+	if td.Count() == 0 {
+		t.Error("no chunk merged before the invalid one")
+	}
+	readErr := errors.New("read failed")
+	if err := tdigest.NewWithCompression(100).MergeFrom(iotest.ErrReader(readErr)); !errors.Is(err, readErr) {
+		t.Errorf("MergeFrom of a failing reader: %v", err)
+	}
+}
+
+func BenchmarkTDigest_MergeBytes(b *testing.B) {
+	src := tdigest.NewWithCompression(850)
+	src.AddValues(NormalData)
+	data, _ := src.MarshalBinary()
+	b.Logf("%d centroids", len(src.Centroids()))
+	b.Run("FromBytes+Merge", func(b *testing.B) {
+		td := tdigest.NewWithCompression(850)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			o, err := tdigest.FromBytes(data)
+			if err != nil {
+				b.Fatal(err)
+			}
+			td.Merge(o)
+		}
+	})
+	b.Run("MergeBytes", func(b *testing.B) {
+		td := tdigest.NewWithCompression(850)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := td.MergeBytes(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
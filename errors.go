@@ -0,0 +1,124 @@
+package tdigest
+
+import (
+	"fmt"
+	"math"
+)
+
+// ErrEmptyDigest is returned by QuantileE and CDFE for a digest that holds
+// no data.
+const ErrEmptyDigest = Error("digest is empty")
+
+// ErrInvalidQuantile is returned by QuantileE for a quantile that is NaN or
+// outside [0, 1].
+const ErrInvalidQuantile = Error("quantile must be between 0 and 1")
+
+// ErrInvalidWeight is returned by AddE and MergeE for a weight that is not
+// positive and finite.
+const ErrInvalidWeight = Error("weight must be positive and finite")
+
+// ErrInvalidValue is returned by AddE and MergeE for a value the digest
+// drops, and by CDFE for NaN.
+const ErrInvalidValue = Error("value must not be NaN or infinite")
+
+// QuantileE is like Quantile but returns an error wrapping ErrInvalidQuantile
+// or ErrEmptyDigest where Quantile returns NaN.
+func (t *TDigest) QuantileE(q float64) (float64, error) {
+	t.Flush()
+	f := t.frozen()
+	if t.cache != nil {
+		return t.cache.quantile(&f, q)
+	}
+	return f.QuantileE(q)
+}
+
+// CDFE is like CDF but returns an error wrapping ErrInvalidValue for a NaN x
+// and ErrEmptyDigest for an empty digest, along with the value CDF returns.
+func (t *TDigest) CDFE(x float64) (float64, error) {
+	t.Flush()
+	f := t.frozen()
+	if t.cache != nil {
+		return t.cache.cdf(&f, x)
+	}
+	return f.CDFE(x)
+}
+
+// QuantileE is like Quantile but returns an error wrapping ErrInvalidQuantile
+// or ErrEmptyDigest where Quantile returns NaN.
+func (f *FrozenDigest) QuantileE(q float64) (float64, error) {
+	if !(q >= 0 && q <= 1) {
+		return math.NaN(), fmt.Errorf("quantile %v: %w", q, ErrInvalidQuantile)
+	}
+	if f.processed.Len() == 0 {
+		return math.NaN(), fmt.Errorf("quantile %v: %w", q, ErrEmptyDigest)
+	}
+	switch q {
+	case 0:
+		return f.min, nil
+	case 1:
+		return f.max, nil
+	}
+	return math.Max(f.min, math.Min(f.quantile(q), f.max)), nil
+}
+
+// CDFE is like CDF but returns an error wrapping ErrInvalidValue for a NaN x
+// and ErrEmptyDigest for an empty digest, along with the value CDF returns.
+func (f *FrozenDigest) CDFE(x float64) (float64, error) {
+	if math.IsNaN(x) {
+		return math.NaN(), fmt.Errorf("CDF of %v: %w", x, ErrInvalidValue)
+	}
+	if f.processed.Len() == 0 {
+		return 0, fmt.Errorf("CDF of %v: %w", x, ErrEmptyDigest)
+	}
+	return f.cdf(x), nil
+}
+
+// AddE is like Add but returns an error wrapping ErrInvalidValue or
+// ErrInvalidWeight if the value is dropped. A dropped value is still counted
+// in Dropped and reported to the drop hook.
+func (t *TDigest) AddE(x, w float64) error {
+	switch err := t.add(Centroid{Mean: x, Weight: w}); err {
+	case ErrInvalidValue:
+		return fmt.Errorf("value %v: %w", x, err)
+	case ErrInvalidWeight:
+		return fmt.Errorf("weight %v: %w", w, err)
+	default:
+		return err
+	}
+}
+
+// MergeE is like Merge but returns an error wrapping ErrInvalidValue or
+// ErrInvalidWeight that describes the first centroid of o that was dropped.
+// The other centroids of o are merged as by Merge.
+func (t *TDigest) MergeE(o *TDigest) error {
+	var err error
+	for i, l := range [...]CentroidList{o.processed, o.unprocessed} {
+		for j, c := range l {
+			if err = t.check(c.Mean, c.Weight); err != nil {
+				kind := "processed"
+				if i == 1 {
+					kind = "unprocessed"
+				}
+				err = fmt.Errorf("merging %s centroid %d: %w", kind, j, err)
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	t.merge(o)
+	return err
+}
+
+// check returns the error MergeE reports for a centroid of mean x and weight
+// w, or nil if t would add it.
+func (t *TDigest) check(x, w float64) error {
+	if _, ok := t.admit(x); !ok {
+		return fmt.Errorf("value %v: %w", x, ErrInvalidValue)
+	}
+	if !validWeight(w) {
+		return fmt.Errorf("weight %v: %w", w, ErrInvalidWeight)
+	}
+	return nil
+}
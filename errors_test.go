@@ -0,0 +1,103 @@
+package tdigest_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestTdigest_QuantileE(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	if _, err := td.QuantileE(0.5); !errors.Is(err, tdigest.ErrEmptyDigest) {
+		t.Errorf("empty digest: got error %v", err)
+	}
+	td.AddValues([]float64{1, 2, 3})
+	for _, q := range []float64{-0.1, 1.1, math.NaN()} {
+		v, err := td.QuantileE(q)
+		if !errors.Is(err, tdigest.ErrInvalidQuantile) || !math.IsNaN(v) {
+			t.Errorf("q=%v: got %v, %v", q, v, err)
+		}
+	}
+	for _, q := range []float64{0, 0.3, 0.5, 1} {
+		v, err := td.QuantileE(q)
+		if err != nil || v != td.Quantile(q) {
+			t.Errorf("q=%v: got %v, %v, want %v", q, v, err, td.Quantile(q))
+		}
+	}
+}
+
+func TestTdigest_CDFE(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	if v, err := td.CDFE(1); !errors.Is(err, tdigest.ErrEmptyDigest) || v != 0 {
+		t.Errorf("empty digest: got %v, %v", v, err)
+	}
+	td.AddValues([]float64{1, 2, 3})
+	if v, err := td.CDFE(math.NaN()); !errors.Is(err, tdigest.ErrInvalidValue) || !math.IsNaN(v) {
+		t.Errorf("NaN: got %v, %v", v, err)
+	}
+	for _, x := range []float64{0, 1.5, 2, 4} {
+		v, err := td.CDFE(x)
+		if err != nil || v != td.CDF(x) {
+			t.Errorf("x=%v: got %v, %v, want %v", x, v, err, td.CDF(x))
+		}
+	}
+}
+
+func TestTdigest_AddE(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	tests := []struct {
+		x, w float64
+		want error
+	}{
+		{1, 1, nil},
+		{math.NaN(), 1, tdigest.ErrInvalidValue},
+		{math.Inf(1), 1, tdigest.ErrInvalidValue},
+		{2, 0, tdigest.ErrInvalidWeight},
+		{2, math.Inf(1), tdigest.ErrInvalidWeight},
+	}
+	for _, tt := range tests {
+		if err := td.AddE(tt.x, tt.w); !errors.Is(err, tt.want) || (err == nil) != (tt.want == nil) {
+			t.Errorf("AddE(%v, %v): got %v, want %v", tt.x, tt.w, err, tt.want)
+		}
+	}
+	if td.Count() != 1 || td.Dropped() != 4 {
+		t.Errorf("got count %v and %d dropped, want 1 and 4", td.Count(), td.Dropped())
+	}
+
+	clamped := tdigest.NewWithCompression(100, tdigest.WithClamp(0, 10))
+	if err := clamped.AddE(math.Inf(1), 1); err != nil {
+		t.Errorf("clamped infinity: got %v", err)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		td.Add(math.NaN(), 1)
+		td.Add(2, 0)
+	})
+	if allocs != 0 {
+		t.Errorf("unexpected allocations adding invalid values: got %f", allocs)
+	}
+}
+
+func TestTdigest_MergeE(t *testing.T) {
+	a := tdigest.NewWithCompression(100)
+	a.AddValues(NormalData[:1000])
+	b := tdigest.NewWithCompression(100)
+	if err := b.MergeE(a); err != nil {
+		t.Fatal(err)
+	}
+	if b.Count() != a.Count() {
+		t.Errorf("merged count %v, want %v", b.Count(), a.Count())
+	}
+
+	// Weights that overflow when they are combined leave a centroid that
+	// cannot be merged.
+	c := tdigest.NewWithCompression(100)
+	c.Add(1, math.MaxFloat64)
+	c.Add(1, math.MaxFloat64)
+	c.Add(2, 1)
+	if err := b.MergeE(c); !errors.Is(err, tdigest.ErrInvalidWeight) {
+		t.Errorf("got %v, want ErrInvalidWeight", err)
+	}
+}
@@ -0,0 +1,94 @@
+package tdigest
+
+import "time"
+
+func init() {
+	debugInvariants = true
+}
+
+// Process exposes process to the tests in package tdigest_test.
+func (t *TDigest) Process() {
+	t.process()
+}
+
+var SortCentroids = sortCentroids
+
+// CheckDecoded is the check the decoders run on the digests they return.
+var CheckDecoded = checkDecoded
+
+// Cumulative returns the cumulative weights of the processed centroids.
+func (t *TDigest) Cumulative() []float64 {
+	return t.cumulative
+}
+
+// Bounds returns the minimum and maximum tracked by the digest.
+func (t *TDigest) Bounds() (min, max float64) {
+	return t.min, t.max
+}
+
+// ProcessedWeight returns the total weight of the processed centroids as
+// tracked by the digest.
+func (t *TDigest) ProcessedWeight() float64 {
+	return t.processedWeight.value()
+}
+
+// Burst appends l to the unprocessed centroids without processing them, as a
+// bulk ingestion path would.
+func (t *TDigest) Burst(l CentroidList) {
+	for _, c := range l {
+		t.unprocessed = append(t.unprocessed, c)
+		t.unprocessedWeight.add(c.Weight)
+	}
+	t.unsorted = true
+	t.dirty = true
+}
+
+// QLimit returns the quantile limit process uses for a centroid starting at q.
+func QLimit(compression, q float64) float64 {
+	return newK1Scale(compression, ScaleK1).qLimit(q)
+}
+
+// WithCount returns a copy of f whose total weight is count, to simulate
+// rounding between the total and the cumulative weights.
+func (f *FrozenDigest) WithCount(count float64) *FrozenDigest {
+	g := *f
+	g.count = count
+	return &g
+}
+
+// SetBounds sets the minimum and maximum tracked by the digest, to build
+// states that adding values does not readily produce, such as a single
+// centroid spanning a range.
+func (t *TDigest) SetBounds(min, max float64) {
+	t.min, t.max = min, max
+}
+
+var PortableCosSin = portableCosSin
+
+var NativeBuckets = nativeBuckets
+
+// SetClock makes the vec take the time from now.
+func (v *DigestVec) SetClock(now func() time.Time) {
+	v.now = now
+}
+
+// SetClock makes the writer take the time from now.
+func (w *SnapshotWriter) SetClock(now func() time.Time) {
+	w.now = now
+}
+
+// Shard and ShardState expose the padded and unpadded shard layouts to the
+// false sharing benchmark.
+type (
+	Shard      = shard
+	ShardState = shardState
+)
+
+const CacheLineSize = cacheLineSize
+
+// Touch locks the shard and marks it dirty, as AddToShard does.
+func (s *shardState) Touch() {
+	s.mu.Lock()
+	s.dirty = true
+	s.mu.Unlock()
+}
@@ -0,0 +1,35 @@
+// Package expvardigest publishes the Summary of a digest as an expvar
+// variable, shown under /debug/vars.
+//
+// It is kept apart from package tdigest because importing expvar registers
+// the /debug/vars handler on http.DefaultServeMux, which programs using a
+// digest should not get unasked.
+package expvardigest
+
+import (
+	"expvar"
+
+	"github.com/influxdata/tdigest"
+)
+
+// Publish publishes an expvar.Func under name whose value is the Summary,
+// with the quantiles qs, of the snapshot last published by t.Publish. It is
+// computed when the variable is read, without locking t, so reading
+// /debug/vars never delays the goroutine writing t; data added since the last
+// Publish is not shown. Like expvar.Publish, it panics if name is already in
+// use.
+func Publish(name string, t *tdigest.TDigest, qs []float64) {
+	qs = append([]float64(nil), qs...)
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return t.Published().Summary(qs...)
+	}))
+}
+
+// PublishConcurrent is like Publish, reading each value from a Snapshot of
+// c, which holds its lock only while copying the centroids.
+func PublishConcurrent(name string, c *tdigest.ConcurrentTDigest, qs []float64) {
+	qs = append([]float64(nil), qs...)
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return c.Snapshot().Summary(qs...)
+	}))
+}
@@ -0,0 +1,69 @@
+package expvardigest_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+	"github.com/influxdata/tdigest/expvardigest"
+)
+
+func read(t *testing.T, name string) map[string]interface{} {
+	t.Helper()
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &v); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return v
+}
+
+func TestPublish(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	expvardigest.Publish("test_published_digest", td, []float64{0.5})
+	if v := read(t, "test_published_digest"); v["count"] != 0.0 || v["min"] != nil {
+		t.Errorf("unexpected value before Publish %v", v)
+	}
+	for i := 0; i < 1000; i++ {
+		td.Add(float64(i), 1)
+	}
+	td.Publish()
+	if v := read(t, "test_published_digest"); v["count"] != 1000.0 {
+		t.Errorf("unexpected value after Publish %v", v)
+	}
+}
+
+func TestPublishConcurrent(t *testing.T) {
+	const mu = 10
+	r := rand.New(rand.NewSource(1))
+	data := make([]float64, 100000)
+	for i := range data {
+		data[i] = r.NormFloat64()*3 + mu
+	}
+
+	c := tdigest.NewConcurrent(100)
+	expvardigest.PublishConcurrent("test_concurrent_digest", c, []float64{0.5, 0.99})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			c.AddValues(data[i*1000 : (i+1)*1000])
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		v := read(t, "test_concurrent_digest")
+		n := v["count"].(float64)
+		if n != math.Trunc(n/1000)*1000 {
+			t.Errorf("read a partly added batch: count %g", n)
+		}
+	}
+	wg.Wait()
+	v := read(t, "test_concurrent_digest")
+	if q := v["quantiles"].(map[string]interface{}); math.Abs(q["0.5"].(float64)-mu) > 0.1 {
+		t.Errorf("unexpected median in %v", v)
+	}
+}
@@ -0,0 +1,97 @@
+package tdigest
+
+import (
+	"fmt"
+	"math"
+)
+
+// ErrNoFit is returned by FitNormal and FitLogNormal for a digest whose
+// values the distribution cannot describe.
+const ErrNoFit = Error("distribution cannot be fitted to the digest")
+
+// ksPoints is the number of quantiles at which the fits compare the digest
+// with the fitted distribution. The distance they find is within about
+// 1/ksPoints of the largest one.
+const ksPoints = 1000
+
+// z90 is the quantile 0.9 of the standard normal distribution.
+var z90 = math.Sqrt2 * math.Erfinv(0.8)
+
+// FitNormal processes pending data and fits a normal distribution to t, as
+// FrozenDigest.FitNormal does.
+func (t *TDigest) FitNormal() (mu, sigma, ks float64, err error) {
+	t.Flush()
+	f := t.frozen()
+	return f.FitNormal()
+}
+
+// FitLogNormal processes pending data and fits a log-normal distribution to
+// t, as FrozenDigest.FitLogNormal does.
+func (t *TDigest) FitLogNormal() (mu, sigma, ks float64, err error) {
+	t.Flush()
+	f := t.frozen()
+	return f.FitLogNormal()
+}
+
+// FitNormal returns the mean mu and standard deviation sigma of the normal
+// distribution with the Mean and StdDev of the snapshot, for simulations that
+// want a parametric stand-in for the data. ks is the Kolmogorov-Smirnov
+// distance between the snapshot and the fit, the largest difference between
+// their CDFs, from 0 for a perfect fit to 1; callers can reject fits above a
+// threshold of their choosing. It returns ErrEmptyDigest for an empty
+// snapshot, and an error wrapping ErrNoFit if all of its values are equal.
+func (f *FrozenDigest) FitNormal() (mu, sigma, ks float64, err error) {
+	if f.processed.Len() == 0 {
+		return math.NaN(), math.NaN(), math.NaN(), ErrEmptyDigest
+	}
+	mu, sigma = f.Mean(), f.StdDev()
+	if !(sigma > 0) {
+		return math.NaN(), math.NaN(), math.NaN(), fmt.Errorf("normal distribution of constant values: %w", ErrNoFit)
+	}
+	return mu, sigma, f.ksDistance(func(x float64) float64 { return normalCDF(x, mu, sigma) }), nil
+}
+
+// FitLogNormal returns the parameters mu and sigma of the log-normal
+// distribution, whose logarithm is normal with mean mu and standard
+// deviation sigma, that matches the quantiles 0.1, 0.5 and 0.9 of the
+// snapshot: mu is the logarithm of the median, and sigma the spread of the
+// logarithms of the other two. The digest does not keep the moments of the
+// logarithms of its values, which the logarithms of its centroid means only
+// approximate, so the fit uses quantiles, which are known as accurately as
+// the data is. ks is the Kolmogorov-Smirnov distance as for FitNormal.
+//
+// It returns ErrEmptyDigest for an empty snapshot, and an error wrapping
+// ErrNoFit if its minimum is not positive or the two quantiles are equal.
+func (f *FrozenDigest) FitLogNormal() (mu, sigma, ks float64, err error) {
+	if f.processed.Len() == 0 {
+		return math.NaN(), math.NaN(), math.NaN(), ErrEmptyDigest
+	}
+	if !(f.min > 0) {
+		return math.NaN(), math.NaN(), math.NaN(), fmt.Errorf("log-normal distribution of values from %v: %w", f.min, ErrNoFit)
+	}
+	lo, hi := math.Log(f.quantile(0.1)), math.Log(f.quantile(0.9))
+	mu, sigma = math.Log(f.quantile(0.5)), (hi-lo)/(2*z90)
+	if !(sigma > 0) {
+		return math.NaN(), math.NaN(), math.NaN(), fmt.Errorf("log-normal distribution of values with equal deciles: %w", ErrNoFit)
+	}
+	return mu, sigma, f.ksDistance(func(x float64) float64 { return normalCDF(math.Log(x), mu, sigma) }), nil
+}
+
+// ksDistance returns the largest difference between cdf and the CDF of the
+// snapshot, comparing each of ksPoints+1 evenly spaced quantiles q with cdf
+// at Quantile(q). Across a point mass, Quantile holds still while q runs up
+// the step of the CDF, so both sides of the step are compared.
+func (f *FrozenDigest) ksDistance(cdf func(x float64) float64) float64 {
+	var d float64
+	for i := 0; i <= ksPoints; i++ {
+		q := float64(i) / ksPoints
+		d = math.Max(d, math.Abs(cdf(f.quantile(q))-q))
+	}
+	return d
+}
+
+// normalCDF returns the CDF at x of the normal distribution with mean mu and
+// standard deviation sigma.
+func normalCDF(x, mu, sigma float64) float64 {
+	return math.Erfc((mu-x)/(sigma*math.Sqrt2)) / 2
+}
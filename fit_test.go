@@ -0,0 +1,83 @@
+package tdigest_test
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestTdigest_FitNormal(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	for _, x := range NormalData {
+		td.Add(x, 1)
+	}
+	mu, sigma, ks, err := td.FitNormal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(mu-Mu) > 0.01*Mu || math.Abs(sigma-Sigma) > 0.01*Sigma || ks > 0.005 {
+		t.Errorf("FitNormal() = %g, %g, KS %g, want %g, %g", mu, sigma, ks, float64(Mu), float64(Sigma))
+	}
+
+	// Uniform data is recognizably not normal.
+	uniform := tdigest.NewWithCompression(100)
+	for _, x := range UniformData {
+		uniform.Add(x, 1)
+	}
+	if _, _, ks, err := uniform.FitNormal(); err != nil || ks < 0.03 {
+		t.Errorf("normal fit to uniform data: KS %g, %v", ks, err)
+	}
+}
+
+func TestTdigest_FitLogNormal(t *testing.T) {
+	const wantMu, wantSigma = 1.0, 0.5
+	r := rand.New(rand.NewSource(1))
+	td := tdigest.NewWithCompression(100)
+	for i := 0; i < 100000; i++ {
+		td.Add(math.Exp(wantMu+wantSigma*r.NormFloat64()), 1)
+	}
+	mu, sigma, ks, err := td.FitLogNormal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(mu-wantMu) > 0.02 || math.Abs(sigma-wantSigma) > 0.02 || ks > 0.01 {
+		t.Errorf("FitLogNormal() = %g, %g, KS %g, want %g, %g", mu, sigma, ks, wantMu, wantSigma)
+	}
+	// The normal fit to the same data is worse.
+	if _, _, normalKS, err := td.FitNormal(); err != nil || normalKS < 2*ks {
+		t.Errorf("normal fit to log-normal data: KS %g, %v, log-normal KS %g", normalKS, err, ks)
+	}
+}
+
+func TestTdigest_FitErrors(t *testing.T) {
+	empty := tdigest.New()
+	if _, _, _, err := empty.FitNormal(); !errors.Is(err, tdigest.ErrEmptyDigest) {
+		t.Errorf("FitNormal of an empty digest: got %v", err)
+	}
+	if _, _, _, err := empty.FitLogNormal(); !errors.Is(err, tdigest.ErrEmptyDigest) {
+		t.Errorf("FitLogNormal of an empty digest: got %v", err)
+	}
+
+	constant := tdigest.New()
+	constant.Add(2, 10)
+	if _, _, _, err := constant.FitNormal(); !errors.Is(err, tdigest.ErrNoFit) {
+		t.Errorf("FitNormal of constant values: got %v", err)
+	}
+	if _, _, _, err := constant.FitLogNormal(); !errors.Is(err, tdigest.ErrNoFit) {
+		t.Errorf("FitLogNormal of constant values: got %v", err)
+	}
+
+	negative := tdigest.New()
+	for _, x := range []float64{-1, 1, 2, 3} {
+		negative.Add(x, 1)
+	}
+	if _, _, _, err := negative.FitLogNormal(); !errors.Is(err, tdigest.ErrNoFit) {
+		t.Errorf("FitLogNormal of negative values: got %v", err)
+	}
+	if mu, sigma, ks, err := negative.FitNormal(); err != nil || math.IsNaN(mu+sigma+ks) {
+		t.Errorf("FitNormal of negative values: %g, %g, %g, %v", mu, sigma, ks, err)
+	}
+}
@@ -0,0 +1,231 @@
+package tdigest
+
+import "math"
+
+// FrozenDigest is an immutable snapshot of the compressed state of a digest.
+// Its methods only read it, so it may be shared between goroutines without
+// locking.
+type FrozenDigest struct {
+	processed   CentroidList
+	cumulative  []float64
+	count       float64
+	min         float64
+	max         float64
+	compression float64
+	ranges      []CentroidRange // nil unless the digest keeps ranges
+}
+
+// emptyFrozenDigest is what Published returns before anything is published.
+// It has the compression of New, so that it can be encoded.
+var emptyFrozenDigest = &FrozenDigest{min: math.MaxFloat64, max: -math.MaxFloat64, compression: DefaultCompression}
+
+// Snapshot processes pending data and returns an immutable copy of the state
+// of t, which later changes to t do not affect.
+func (t *TDigest) Snapshot() *FrozenDigest {
+	t.Flush()
+	f := t.frozen()
+	f.processed = f.processed.Clone()
+	f.cumulative = append([]float64(nil), f.cumulative...)
+	if f.ranges != nil {
+		f.ranges = append([]CentroidRange{}, f.ranges...)
+	}
+	return &f
+}
+
+// SnapshotAndReset returns a Snapshot of t and then resets t, keeping its
+// buffers for reuse.
+func (t *TDigest) SnapshotAndReset() *FrozenDigest {
+	f := t.Snapshot()
+	t.Reset()
+	return f
+}
+
+// Publish stores a Snapshot of t, which Published then returns. Publish must
+// be called by the goroutine that modifies t, or with t otherwise locked, but
+// Published may be called from any goroutine at any time.
+//
+// Readers see the state of t as of the last Publish: data added since is
+// invisible to them until the writer publishes again.
+func (t *TDigest) Publish() {
+	t.published.Store(t.Snapshot())
+}
+
+// Published returns the snapshot last published with Publish, or an empty
+// digest if there is none. It takes no locks.
+func (t *TDigest) Published() *FrozenDigest {
+	if f, ok := t.published.Load().(*FrozenDigest); ok {
+		return f
+	}
+	return emptyFrozenDigest
+}
+
+// Compression returns the compression of the digest the snapshot was taken
+// of.
+func (f *FrozenDigest) Compression() float64 {
+	return f.compression
+}
+
+// Count returns the total weight of the snapshot.
+func (f *FrozenDigest) Count() float64 {
+	return f.count
+}
+
+// Sum returns the sum of the values in the snapshot, each times its weight.
+// Centroids keep the weighted sum of their values, so it is exact up to
+// rounding.
+func (f *FrozenDigest) Sum() float64 {
+	var sum kahanSum
+	for _, c := range f.processed {
+		sum.add(c.Mean * c.Weight)
+	}
+	return sum.value()
+}
+
+// Centroids returns the centroids of the snapshot. They must not be modified.
+func (f *FrozenDigest) Centroids() CentroidList {
+	return f.processed
+}
+
+// Quantile returns the value at quantile q. Quantile(0) and Quantile(1) are
+// the minimum and maximum, and every other result lies between them. It
+// returns NaN for an empty digest and for a q that is NaN or outside [0, 1].
+func (f *FrozenDigest) Quantile(q float64) float64 {
+	v, _ := f.QuantileE(q)
+	return v
+}
+
+// quantile interpolates linearly between the points (0, min), each centroid
+// mean at its cumulative weight, and (count, max). Each formula below covers
+// one of those segments and meets its neighbours at their shared point, so
+// the result is continuous in q. With centroid ranges, a segment between
+// centroids whose ranges do not overlap instead runs through the ends of the
+// ranges, jumping across the gap between them.
+func (f *FrozenDigest) quantile(q float64) float64 {
+	index := q * f.count
+	if index <= f.cumulative[0] {
+		// index is positive here, so the weight is too.
+		return f.min + index/f.cumulative[0]*(f.processed[0].Mean-f.min)
+	}
+
+	// index > cumulative[0] and index <= count, the last cumulative weight,
+	// so lower lies in [1, len(cumulative)). The clamps keep any rounding
+	// between count and the cumulative weights from indexing out of range.
+	lower := CumulativeIndex(index, f.cumulative)
+	if lower == 0 {
+		lower = 1
+	}
+	if lower == len(f.cumulative) {
+		lower--
+	}
+
+	if lower+1 != len(f.cumulative) {
+		if f.ranges != nil {
+			if v, ok := f.rangedQuantile(lower, index); ok {
+				return v
+			}
+		}
+		// As in CDF, centroids whose mean is the minimum or maximum hold
+		// only that value, so all of their weight sits at their mean.
+		left, right := f.cumulative[lower-1], f.cumulative[lower]
+		if f.processed[lower-1].Mean == f.min {
+			left = f.weightBefore(lower)
+		}
+		if f.processed[lower].Mean == f.max {
+			right = f.weightBefore(lower)
+		}
+		switch {
+		case index <= left:
+			return f.processed[lower-1].Mean
+		case index >= right:
+			return f.processed[lower].Mean
+		}
+		z1 := index - left
+		z2 := right - index
+		return weightedAverage(f.processed[lower-1].Mean, z2, f.processed[lower].Mean, z1)
+	}
+
+	// index lies between the mean of the last centroid, half way through its
+	// weight, and the maximum. Measuring from the same cumulative weight as
+	// the interior segments keeps rounding from opening a gap at the seam.
+	z1 := index - f.cumulative[lower-1]
+	z2 := f.count - index
+	return weightedAverage(f.processed[lower-1].Mean, z2, f.max, z1)
+}
+
+// CDF returns the estimated fraction of the weight of the digest below x. It
+// returns NaN if x is NaN.
+func (f *FrozenDigest) CDF(x float64) float64 {
+	v, _ := f.CDFE(x)
+	return v
+}
+
+// cdf computes CDF for an x that is not NaN in a digest that is not empty.
+func (f *FrozenDigest) cdf(x float64) float64 {
+	if f.min == f.max {
+		// A single value: a step at that value, which like any centroid
+		// mean sits half way through its weight.
+		switch {
+		case x < f.min:
+			return 0.0
+		case x > f.max:
+			return 1.0
+		}
+		return 0.5
+	}
+	if x <= f.min {
+		return 0.0
+	}
+	if x >= f.max {
+		return 1.0
+	}
+	// CDF inverts the piecewise linear interpolation of quantile, through
+	// the same points, so that CDF(Quantile(q)) is close to q.
+	m0 := f.processed[0].Mean
+	// Left Tail
+	if x <= m0 {
+		if m0-f.min > 0 {
+			return (x - f.min) / (m0 - f.min) * f.cumulative[0] / f.count
+		}
+		return 0.0
+	}
+	// Right Tail
+	n := f.processed.Len()
+	mn := f.processed[n-1].Mean
+	if x >= mn {
+		if f.max-mn > 0.0 {
+			return 1.0 - (f.max-x)/(f.max-mn)*(f.count-f.cumulative[n-1])/f.count
+		}
+		return 1.0
+	}
+
+	// x lies strictly between the means of the first and last centroids.
+	// Where several centroids share the mean x, upper is the first after
+	// them, and the result is the cumulative weight of the last of them.
+	upper := f.processed.FloorIndexByMean(x) + 1
+	if f.ranges != nil {
+		if v, ok := f.rangedCDF(upper, x); ok {
+			return v
+		}
+	}
+	// A centroid whose mean is the minimum or maximum holds nothing but that
+	// value, so none of its weight is spread out towards x.
+	left, right := f.cumulative[upper-1], f.cumulative[upper]
+	if f.processed[upper-1].Mean == f.min {
+		left = f.weightBefore(upper)
+	}
+	if f.processed[upper].Mean == f.max {
+		right = f.weightBefore(upper)
+	}
+	z1 := x - f.processed[upper-1].Mean
+	z2 := f.processed[upper].Mean - x
+	return weightedAverage(left, z2, right, z1) / f.count
+}
+
+// weightBefore returns the total weight of the processed centroids before
+// index i.
+func (f *FrozenDigest) weightBefore(i int) float64 {
+	if i == f.processed.Len() {
+		return f.cumulative[i]
+	}
+	return f.cumulative[i] - f.processed[i].Weight/2
+}
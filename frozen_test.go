@@ -0,0 +1,151 @@
+package tdigest_test
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestTdigest_Publish(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	if got := td.Published().Quantile(0.5); !math.IsNaN(got) {
+		t.Errorf("unexpected quantile before publishing: %g", got)
+	}
+
+	const readers = 32
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				f := td.Published()
+				if f.Count() == 0 {
+					continue
+				}
+				// Every published snapshot holds whole batches of 1000.
+				if n := f.Count(); n != math.Trunc(n/1000)*1000 {
+					t.Errorf("unexpected count %g", n)
+				}
+				q := f.Quantile(0.5)
+				if p := f.CDF(q); math.Abs(p-0.5) > 0.05 {
+					t.Errorf("unexpected CDF(Quantile(0.5)) = %g", p)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		td.AddValues(NormalData[i*1000 : (i+1)*1000])
+		td.Publish()
+	}
+	close(done)
+	wg.Wait()
+
+	want := td.Quantile(0.9)
+	td.AddValues(UniformData[:1000])
+	if got := td.Published().Quantile(0.9); got != want {
+		t.Errorf("published snapshot changed after adding, got %g want %g", got, want)
+	}
+	if got := len(td.Published().Centroids()); got == 0 {
+		t.Errorf("published snapshot has no centroids")
+	}
+}
+
+func TestConcurrentTDigest_Snapshot(t *testing.T) {
+	td := tdigest.NewConcurrent(100)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			td.Add(NormalData[i%len(NormalData)], 1)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		time.Sleep(time.Millisecond)
+		f := td.Snapshot()
+		sum := 0.0
+		for _, c := range f.Centroids() {
+			sum += c.Weight
+		}
+		if sum != f.Count() {
+			t.Errorf("centroid weights %g do not add up to count %g", sum, f.Count())
+		}
+		if f.Count() < 100 {
+			continue
+		}
+		for _, q := range []float64{0.1, 0.5, 0.9} {
+			if p := f.CDF(f.Quantile(q)); math.Abs(p-q) > 0.01 {
+				t.Errorf("unexpected CDF(Quantile(%g)) = %g", q, p)
+			}
+		}
+		count := f.Count()
+		td.AddValues(UniformData[:100])
+		if f.Count() != count {
+			t.Errorf("snapshot changed after adding")
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestConcurrentTDigest_SnapshotAndReset(t *testing.T) {
+	const writers, values, scrapes = 4, 20000, 1000
+	td := tdigest.NewConcurrent(100)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for _, x := range NormalData[w*values : (w+1)*values] {
+				td.Add(x, 1)
+			}
+		}(w)
+	}
+
+	var total float64
+	for i := 0; i < scrapes; i++ {
+		total += td.SnapshotAndReset().Count()
+	}
+	wg.Wait()
+	total += td.SnapshotAndReset().Count()
+	if want := float64(writers * values); total != want {
+		t.Errorf("unexpected total count across snapshots, got %g want %g", total, want)
+	}
+	if got := td.Count(); got != 0 {
+		t.Errorf("unexpected count after final reset: %g", got)
+	}
+}
+
+func TestFrozenDigest_Sum(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	if got := td.Snapshot().Sum(); got != 0 {
+		t.Errorf("unexpected sum of an empty digest %g", got)
+	}
+	want := 0.0
+	for i, x := range NormalData[:100000] {
+		w := float64(1 + i%3)
+		td.Add(x, w)
+		want += x * w
+	}
+	if got := td.Snapshot().Sum(); math.Abs(got-want) > 1e-9*math.Abs(want) {
+		t.Errorf("unexpected sum, got %g want %g", got, want)
+	}
+}
@@ -1,7 +1,10 @@
 module github.com/influxdata/tdigest
 
+go 1.16
+
 require (
 	github.com/google/go-cmp v0.2.0
+	go.uber.org/goleak v1.1.12
 	golang.org/x/exp v0.0.0-20180321215751-8460e604b9de
 	gonum.org/v1/gonum v0.0.0-20181121035319-3f7ecaa7e8ca
 	gonum.org/v1/netlib v0.0.0-20181029234149-ec6d1f5cefe6 // indirect
@@ -0,0 +1,12 @@
+module github.com/influxdata/tdigest/grpcdigest
+
+go 1.16
+
+require (
+	github.com/influxdata/tdigest v0.0.0
+	golang.org/x/sys v0.0.0-20210603081109-ebe580a85c40 // indirect
+	google.golang.org/grpc v1.41.0
+	google.golang.org/protobuf v1.26.0-rc.1 // indirect
+)
+
+replace github.com/influxdata/tdigest => ../
@@ -0,0 +1,81 @@
+// Package grpcdigest records the latency and message sizes of gRPC methods
+// in t-digests through a stats.Handler, which serves for both clients and
+// servers:
+//
+//	h := grpcdigest.NewHandler()
+//	srv := grpc.NewServer(grpc.StatsHandler(h))
+//	conn, err := grpc.Dial(target, grpc.WithStatsHandler(h))
+package grpcdigest
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+// Handler is a stats.Handler that records every RPC into the digests of its
+// method in a Registry. It finds those digests once per RPC, when the RPC
+// starts, and takes no locks of its own.
+type Handler struct {
+	registry *Registry
+}
+
+// Option configures a Handler.
+type Option func(h *Handler)
+
+// WithRegistry records into r instead of DefaultRegistry.
+func WithRegistry(r *Registry) Option {
+	return func(h *Handler) {
+		h.registry = r
+	}
+}
+
+// NewHandler returns a Handler with the given options.
+func NewHandler(opts ...Option) *Handler {
+	h := &Handler{registry: DefaultRegistry}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+type infoKey struct{}
+
+// TagRPC attaches info, which holds the method of the RPC, to its context.
+// grpc has already allocated it, so unlike the method name it is stored
+// without allocating.
+func (h *Handler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, infoKey{}, info)
+}
+
+// HandleRPC records the sizes of messages as they are sent and received and
+// the latency of the RPC once it ends.
+func (h *Handler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	info, ok := ctx.Value(infoKey{}).(*stats.RPCTagInfo)
+	if !ok {
+		return
+	}
+	r, method := h.registry, info.FullMethodName
+	switch s := s.(type) {
+	case *stats.InPayload:
+		r.received.Add(float64(s.Length), 1, method)
+	case *stats.OutPayload:
+		r.sent.Add(float64(s.Length), 1, method)
+	case *stats.End:
+		v := r.err
+		if status.Code(s.Error) == codes.OK {
+			v = r.ok
+		}
+		v.Add(s.EndTime.Sub(s.BeginTime).Seconds(), 1, method)
+	}
+}
+
+// TagConn returns ctx unchanged.
+func (h *Handler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+// HandleConn does nothing.
+func (h *Handler) HandleConn(context.Context, stats.ConnStats) {}
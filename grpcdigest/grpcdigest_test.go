@@ -0,0 +1,116 @@
+package grpcdigest_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/tdigest/grpcdigest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const checkMethod = "/grpc.health.v1.Health/Check"
+
+// serve starts a health server recording into reg and returns a client
+// connection to it recording into clientReg.
+func serve(t *testing.T, reg, clientReg *grpcdigest.Registry) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.StatsHandler(grpcdigest.NewHandler(grpcdigest.WithRegistry(reg))))
+	hs := health.NewServer()
+	hs.SetServingStatus("known", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+		grpc.WithStatsHandler(grpcdigest.NewHandler(grpcdigest.WithRegistry(clientReg))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestHandler(t *testing.T) {
+	reg, clientReg := grpcdigest.NewRegistry(100), grpcdigest.NewRegistry(100)
+	client := healthpb.NewHealthClient(serve(t, reg, clientReg))
+
+	const workers, calls = 8, 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < calls; i++ {
+				service := "known"
+				if i%10 == 0 {
+					// Unknown services fail with NotFound.
+					service = "unknown"
+				}
+				client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for name, r := range map[string]*grpcdigest.Registry{"server": reg, "client": clientReg} {
+		if methods := r.Methods(); len(methods) != 1 || methods[0] != checkMethod {
+			t.Fatalf("%s: unexpected methods %v", name, methods)
+		}
+		s := r.Summaries(0.5, 0.99)[0]
+		if s.OK.Count != workers*calls*9/10 || s.Error.Count != workers*calls/10 {
+			t.Errorf("%s: unexpected counts %v OK and %v failed", name, s.OK.Count, s.Error.Count)
+		}
+		if p50, p99 := s.OK.Quantiles[0].Value, s.OK.Quantiles[1].Value; !(p50 > 0 && p50 <= p99 && p99 < 1) {
+			t.Errorf("%s: implausible latencies p50 %v, p99 %v", name, p50, p99)
+		}
+	}
+	// Every call sends a request, and those that succeed get a response.
+	if n := clientReg.SentSize(checkMethod).Count(); n != workers*calls {
+		t.Errorf("client sent %v messages", n)
+	}
+	if n := reg.ReceivedSize(checkMethod).Count(); n != workers*calls {
+		t.Errorf("server received %v messages", n)
+	}
+	if n := reg.SentSize(checkMethod).Count(); n != workers*calls*9/10 {
+		t.Errorf("server sent %v messages", n)
+	}
+	if n := clientReg.ReceivedSize(checkMethod).Count(); n != workers*calls*9/10 {
+		t.Errorf("client received %v messages", n)
+	}
+	// Requests name a service and responses for known services hold a
+	// status, so neither is empty.
+	if s := clientReg.Summaries()[0]; !(s.Sent.Min > 0) || reg.Summaries()[0].Received.Max != s.Sent.Max {
+		t.Errorf("unexpected request sizes %+v", s.Sent)
+	}
+}
+
+func TestHandler_Allocs(t *testing.T) {
+	h := grpcdigest.NewHandler(grpcdigest.WithRegistry(grpcdigest.NewRegistry(100)))
+	ctx := h.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: checkMethod})
+	in := &stats.InPayload{Length: 10}
+	end := &stats.End{BeginTime: time.Unix(0, 0), EndTime: time.Unix(1, 0)}
+	allocs := testing.AllocsPerRun(1000, func() {
+		h.HandleRPC(ctx, in)
+		h.HandleRPC(ctx, end)
+	})
+	if allocs != 0 {
+		t.Errorf("recording allocates %v times", allocs)
+	}
+	// grpc allocates the tag info before calling TagRPC.
+	info := &stats.RPCTagInfo{FullMethodName: checkMethod}
+	allocs = testing.AllocsPerRun(1000, func() {
+		h.TagRPC(context.Background(), info)
+	})
+	if allocs > 1 {
+		t.Errorf("tagging allocates %v times", allocs)
+	}
+}
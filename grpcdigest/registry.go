@@ -0,0 +1,109 @@
+package grpcdigest
+
+import (
+	"sort"
+
+	"github.com/influxdata/tdigest"
+)
+
+// Registry holds the digests of a set of RPC methods, creating them on first
+// use. It is safe for concurrent use.
+type Registry struct {
+	ok, err        *tdigest.DigestVec
+	sent, received *tdigest.DigestVec
+}
+
+// DefaultRegistry is the Registry that a Handler records into unless given
+// another.
+var DefaultRegistry = NewRegistry(0)
+
+// NewRegistry returns an empty Registry whose digests have the given
+// compression. Zero means tdigest.DefaultCompression.
+func NewRegistry(compression float64) *Registry {
+	if compression == 0 {
+		compression = tdigest.DefaultCompression
+	}
+	return &Registry{
+		ok:       tdigest.NewDigestVec(compression),
+		err:      tdigest.NewDigestVec(compression),
+		sent:     tdigest.NewDigestVec(compression),
+		received: tdigest.NewDigestVec(compression),
+	}
+}
+
+// Latency returns the digest of the latencies, in seconds, of the RPCs of
+// method that ended with the status OK if ok is true, and with an error
+// otherwise. method is the full method name, such as
+// "/grpc.health.v1.Health/Check".
+func (r *Registry) Latency(method string, ok bool) *tdigest.ConcurrentTDigest {
+	if ok {
+		return r.ok.GetOrCreate(method)
+	}
+	return r.err.GetOrCreate(method)
+}
+
+// SentSize returns the digest of the sizes, in bytes, of the messages sent
+// by method, before compression.
+func (r *Registry) SentSize(method string) *tdigest.ConcurrentTDigest {
+	return r.sent.GetOrCreate(method)
+}
+
+// ReceivedSize returns the digest of the sizes, in bytes, of the messages
+// received by method, before compression.
+func (r *Registry) ReceivedSize(method string) *tdigest.ConcurrentTDigest {
+	return r.received.GetOrCreate(method)
+}
+
+// Methods returns the sorted names of the methods of the registry.
+func (r *Registry) Methods() []string {
+	seen := make(map[string]bool)
+	var methods []string
+	for _, v := range []*tdigest.DigestVec{r.ok, r.err, r.sent, r.received} {
+		v.Range(func(labels []string, _ *tdigest.ConcurrentTDigest) bool {
+			if !seen[labels[0]] {
+				seen[labels[0]] = true
+				methods = append(methods, labels[0])
+			}
+			return true
+		})
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// MethodSummary holds the summaries of the digests of a method.
+type MethodSummary struct {
+	Method   string
+	OK       tdigest.Summary
+	Error    tdigest.Summary
+	Sent     tdigest.Summary
+	Received tdigest.Summary
+}
+
+// Summaries returns the summaries with quantiles qs of the digests of every
+// method, in the order of Methods. Each digest is snapshotted separately, so
+// RPCs recorded meanwhile may be counted in one and not another.
+func (r *Registry) Summaries(qs ...float64) []MethodSummary {
+	methods := r.Methods()
+	summaries := make([]MethodSummary, len(methods))
+	for i, m := range methods {
+		summaries[i] = MethodSummary{
+			Method:   m,
+			OK:       summary(r.ok, qs, m),
+			Error:    summary(r.err, qs, m),
+			Sent:     summary(r.sent, qs, m),
+			Received: summary(r.received, qs, m),
+		}
+	}
+	return summaries
+}
+
+// summary returns the summary of the digest of method in v, which is empty
+// if v has none.
+func summary(v *tdigest.DigestVec, qs []float64, method string) tdigest.Summary {
+	d, ok := v.Get(method)
+	if !ok {
+		return new(tdigest.FrozenDigest).Summary(qs...)
+	}
+	return d.Snapshot().Summary(qs...)
+}
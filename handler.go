@@ -0,0 +1,101 @@
+package tdigest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultHandlerQuantiles are the quantiles Handler reports unless a request
+// asks for others.
+var DefaultHandlerQuantiles = []float64{0.5, 0.9, 0.99}
+
+// Handler returns an http.Handler that serves the digests of r as JSON, an
+// object mapping each name to an object holding the summary of the digest.
+// It takes the query parameters
+//
+//	q     quantiles to report, separated by commas, instead of
+//	      DefaultHandlerQuantiles
+//	name  the name of a digest to report instead of all of them; it may be
+//	      repeated
+//	full  if 1 or true, also the centroids of each digest
+//
+// The summary and centroids of a digest come from a single snapshot. It
+// responds with 404 Not Found if a name is not registered, 400 Bad Request
+// for malformed parameters and 405 Method Not Allowed for methods other than
+// GET and HEAD.
+func Handler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		query := req.URL.Query()
+		qs, err := parseQuantiles(query["q"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		full := false
+		if v := query.Get("full"); v != "" {
+			if full, err = strconv.ParseBool(v); err != nil {
+				http.Error(w, fmt.Sprintf("invalid full %q", v), http.StatusBadRequest)
+				return
+			}
+		}
+		names := query["name"]
+		if len(names) == 0 {
+			names = r.Names()
+		}
+
+		out := make(map[string]handlerDigestJSON, len(names))
+		for _, name := range names {
+			s, ok := r.Get(name)
+			if !ok {
+				http.Error(w, fmt.Sprintf("no digest %q", name), http.StatusNotFound)
+				return
+			}
+			f := s.Snapshot()
+			d := handlerDigestJSON{Summary: f.Summary(qs...)}
+			if full {
+				d.Centroids = f.Centroids()
+			}
+			out[name] = d
+		}
+		b, err := json.Marshal(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(append(b, '\n'))
+	})
+}
+
+// handlerDigestJSON is the JSON encoding of a digest served by Handler.
+type handlerDigestJSON struct {
+	Summary   Summary      `json:"summary"`
+	Centroids CentroidList `json:"centroids,omitempty"`
+}
+
+// parseQuantiles parses the values of the q parameter, each a list of
+// quantiles separated by commas.
+func parseQuantiles(values []string) ([]float64, error) {
+	if len(values) == 0 {
+		return DefaultHandlerQuantiles, nil
+	}
+	var qs []float64
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			q, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil || !(q >= 0 && q <= 1) {
+				return nil, fmt.Errorf("invalid quantile %q", s)
+			}
+			qs = append(qs, q)
+		}
+	}
+	return qs, nil
+}
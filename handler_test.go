@@ -0,0 +1,108 @@
+package tdigest_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func newTestRegistry(t *testing.T) *tdigest.Registry {
+	r := tdigest.NewRegistry()
+	a := tdigest.NewConcurrent(100)
+	a.AddValues([]float64{1, 2, 3, 4})
+	if err := r.Register("a", a); err != nil {
+		t.Fatal(err)
+	}
+	b := tdigest.NewWithCompression(100)
+	b.AddValues([]float64{10, 20})
+	b.Publish()
+	if err := r.RegisterPublished("b", b); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register("a", a); err == nil {
+		t.Error("registered a name twice")
+	}
+	return r
+}
+
+// get serves a request for target and decodes the response if it succeeds.
+func get(t *testing.T, h http.Handler, target string) (int, map[string]map[string]interface{}) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	if w.Code != http.StatusOK {
+		return w.Code, nil
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s: unexpected content type %q", target, ct)
+	}
+	var v map[string]map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("%s: %v", target, err)
+	}
+	return w.Code, v
+}
+
+func TestHandler(t *testing.T) {
+	h := tdigest.Handler(newTestRegistry(t))
+
+	_, all := get(t, h, "/")
+	if len(all) != 2 {
+		t.Fatalf("unexpected digests %v", all)
+	}
+	summary := all["b"]["summary"].(map[string]interface{})
+	want := map[string]interface{}{
+		"count": 2.0, "min": 10.0, "max": 20.0, "mean": 15.0,
+		"quantiles": map[string]interface{}{"0.5": 10.0, "0.9": 20.0, "0.99": 20.0},
+	}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("unexpected summary %v, want %v", summary, want)
+	}
+	if _, ok := all["a"]["centroids"]; ok {
+		t.Error("centroids reported without full")
+	}
+
+	_, v := get(t, h, "/?q=0,1&q=0.25")
+	quantiles := v["a"]["summary"].(map[string]interface{})["quantiles"]
+	if want := map[string]interface{}{"0": 1.0, "1": 4.0, "0.25": 1.0}; !reflect.DeepEqual(quantiles, want) {
+		t.Errorf("unexpected quantiles %v, want %v", quantiles, want)
+	}
+
+	_, v = get(t, h, "/?name=a&full=1")
+	if len(v) != 1 {
+		t.Errorf("unexpected digests %v", v)
+	}
+	centroids := v["a"]["centroids"]
+	wantCentroids := []interface{}{
+		map[string]interface{}{"mean": 1.0, "weight": 1.0},
+		map[string]interface{}{"mean": 2.0, "weight": 1.0},
+		map[string]interface{}{"mean": 3.0, "weight": 1.0},
+		map[string]interface{}{"mean": 4.0, "weight": 1.0},
+	}
+	if !reflect.DeepEqual(centroids, wantCentroids) {
+		t.Errorf("unexpected centroids %v", centroids)
+	}
+}
+
+func TestHandler_Errors(t *testing.T) {
+	h := tdigest.Handler(newTestRegistry(t))
+	for target, want := range map[string]int{
+		"/?name=c":        http.StatusNotFound,
+		"/?name=a&name=c": http.StatusNotFound,
+		"/?q=2":           http.StatusBadRequest,
+		"/?q=0.5,x":       http.StatusBadRequest,
+		"/?full=maybe":    http.StatusBadRequest,
+	} {
+		if code, _ := get(t, h, target); code != want {
+			t.Errorf("%s: unexpected status %d, want %d", target, code, want)
+		}
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") == "" {
+		t.Errorf("unexpected response to POST %d %v", w.Code, w.Header())
+	}
+}
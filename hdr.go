@@ -0,0 +1,177 @@
+package tdigest
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// ErrInvalidHDR is returned for HdrHistogram data that does not match its
+// layout or that a digest cannot be converted into.
+const ErrInvalidHDR = Error("invalid HdrHistogram data")
+
+// HDRSnapshot holds the counts of an HdrHistogram along with the parameters
+// that determine their layout. It has the fields of the Snapshot exported by
+// github.com/HdrHistogram/hdrhistogram-go, so one converts to the other.
+type HDRSnapshot struct {
+	LowestTrackableValue  int64
+	HighestTrackableValue int64
+	SignificantFigures    int64
+	Counts                []int64
+}
+
+// FromHDR returns a digest with the given compression holding the data of
+// s. HdrHistogram records integers; unit is the value of one of them in the
+// digest, such as 1e-6 for a histogram of microseconds holding seconds.
+//
+// The count of each HDR bucket becomes a centroid at the middle of the
+// integers it covers. The last bucket may reach beyond
+// HighestTrackableValue, which no recorded value can, so it is cut off
+// there. It returns an error wrapping ErrInvalidHDR if the counts do not
+// match the layout, a count is negative, or unit is not positive and finite.
+func FromHDR(s HDRSnapshot, unit, compression float64) (*TDigest, error) {
+	l, err := newHDRLayout(s.LowestTrackableValue, s.HighestTrackableValue, s.SignificantFigures)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Counts) != l.len {
+		return nil, fmt.Errorf("%d counts, want %d: %w", len(s.Counts), l.len, ErrInvalidHDR)
+	}
+	if !(unit > 0) || math.IsInf(unit, 0) {
+		return nil, fmt.Errorf("unit %v: %w", unit, ErrInvalidHDR)
+	}
+	t := NewWithCompression(compression)
+	for i, c := range s.Counts {
+		if c < 0 {
+			return nil, fmt.Errorf("count %d at index %d: %w", c, i, ErrInvalidHDR)
+		}
+		if c == 0 {
+			continue
+		}
+		lo, hi := l.bounds(i)
+		t.AddCentroid(Centroid{Mean: float64(lo+hi) / 2 * unit, Weight: float64(c)})
+		t.updateBounds(float64(lo) * unit)
+		t.updateBounds(float64(hi) * unit)
+	}
+	return t, nil
+}
+
+// ToHDR processes pending data and returns t as HdrHistogram counts, as
+// FrozenDigest.ToHDR does.
+func (t *TDigest) ToHDR(lowest, highest, sigfigs int64, unit float64) (HDRSnapshot, error) {
+	t.Flush()
+	f := t.frozen()
+	return f.ToHDR(lowest, highest, sigfigs, unit)
+}
+
+// ToHDR returns the snapshot as the counts of an HdrHistogram with the given
+// lowest and highest trackable values and significant figures, each integer
+// of which stands for unit in the digest. A value x is counted in the bucket
+// of the integer floor(x/unit), as HdrHistogram would record it, and values
+// beyond the highest trackable value are counted in its bucket. The counts
+// are estimated from CDF at the bucket boundaries and rounded so that they
+// add up to the rounded weight of the digest.
+//
+// It returns an error wrapping ErrInvalidHDR if the digest holds negative
+// values, which HdrHistogram cannot record, or unit is not positive and
+// finite.
+func (f *FrozenDigest) ToHDR(lowest, highest, sigfigs int64, unit float64) (HDRSnapshot, error) {
+	if !(unit > 0) || math.IsInf(unit, 0) {
+		return HDRSnapshot{}, fmt.Errorf("unit %v: %w", unit, ErrInvalidHDR)
+	}
+	l, err := newHDRLayout(lowest, highest, sigfigs)
+	if err != nil {
+		return HDRSnapshot{}, err
+	}
+	s := HDRSnapshot{
+		LowestTrackableValue:  l.lowest,
+		HighestTrackableValue: l.highest,
+		SignificantFigures:    l.sigfigs,
+		Counts:                make([]int64, l.len),
+	}
+	if f.processed.Len() == 0 {
+		return s, nil
+	}
+	if f.min < 0 {
+		return HDRSnapshot{}, fmt.Errorf("minimum %v: %w", f.min, ErrInvalidHDR)
+	}
+	total := math.Round(f.count)
+	first, last := l.index(int64(math.Min(f.min/unit, float64(l.highest)))), l.index(l.highest)
+	prev := 0.0
+	for i := first; i <= last; i++ {
+		next := total
+		if _, hi := l.bounds(i); i < last {
+			next = math.Round(math.Max(prev, math.Min(f.count*f.CDF(float64(hi+1)*unit), total)))
+		}
+		s.Counts[i] = int64(next - prev)
+		prev = next
+	}
+	return s, nil
+}
+
+// hdrLayout is the arrangement of the counts of an HdrHistogram. Its buckets
+// are split into sub-buckets of equal width, the first bucket at unit width
+// and each one after it at twice the width of the one before. All but the
+// first bucket are stored from their midpoint, since their lower half is
+// covered by the bucket before.
+type hdrLayout struct {
+	lowest, highest, sigfigs int64
+	unitMagnitude            uint
+	halfCountMagnitude       uint
+	len                      int
+}
+
+// newHDRLayout returns the layout of the counts of an HdrHistogram. It
+// returns an error wrapping ErrInvalidHDR for parameters HdrHistogram does not
+// accept: a lowest trackable value below 1, a highest one below twice the
+// lowest, significant figures outside [1, 5], or a lowest value so large that
+// its sub-buckets at that precision overflow int64.
+func newHDRLayout(lowest, highest, sigfigs int64) (hdrLayout, error) {
+	if lowest < 1 || highest/2 < lowest || sigfigs < 1 || sigfigs > 5 {
+		return hdrLayout{}, fmt.Errorf("lowest %d, highest %d, significant figures %d: %w", lowest, highest, sigfigs, ErrInvalidHDR)
+	}
+	l := hdrLayout{lowest: lowest, highest: highest, sigfigs: sigfigs}
+	// Sub-buckets have unit width up to 2*10^sigfigs.
+	countMagnitude := uint(math.Ceil(math.Log2(2 * math.Pow10(int(sigfigs)))))
+	l.halfCountMagnitude = countMagnitude - 1
+	l.unitMagnitude = uint(bits.Len64(uint64(lowest)) - 1)
+	if l.unitMagnitude+l.halfCountMagnitude > 61 {
+		return hdrLayout{}, fmt.Errorf("lowest %d at %d significant figures: %w", lowest, sigfigs, ErrInvalidHDR)
+	}
+
+	buckets := 1
+	for untrackable := int64(1) << (countMagnitude + l.unitMagnitude); untrackable < highest; untrackable <<= 1 {
+		buckets++
+		if untrackable > math.MaxInt64/2 {
+			break
+		}
+	}
+	l.len = (buckets + 1) << l.halfCountMagnitude
+	return l, nil
+}
+
+// bounds returns the smallest and largest integers counted at index i, the
+// latter no larger than the highest trackable value unless the former is.
+func (l hdrLayout) bounds(i int) (int64, int64) {
+	half := 1 << l.halfCountMagnitude
+	bucket := i>>l.halfCountMagnitude - 1
+	sub := i&(half-1) + half
+	if bucket < 0 {
+		bucket, sub = 0, sub-half
+	}
+	shift := uint(bucket) + l.unitMagnitude
+	lo := int64(sub) << shift
+	hi := lo + 1<<shift - 1
+	if hi > l.highest && lo <= l.highest {
+		hi = l.highest
+	}
+	return lo, hi
+}
+
+// index returns the index at which the integer v is counted.
+func (l hdrLayout) index(v int64) int {
+	mask := int64(1)<<(l.halfCountMagnitude+1) - 1
+	bucket := bits.Len64(uint64(v|mask<<l.unitMagnitude)) - int(l.unitMagnitude) - int(l.halfCountMagnitude+1)
+	sub := int(v >> (uint(bucket) + l.unitMagnitude))
+	return (bucket+1)<<l.halfCountMagnitude + sub - 1<<l.halfCountMagnitude
+}
@@ -0,0 +1,94 @@
+package tdigest_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestTdigest_HDRRoundTrip(t *testing.T) {
+	td := tdigest.NewWithCompression(1000)
+	for _, x := range NormalData[:100000] {
+		td.Add(math.Abs(x), 1)
+	}
+	const sigfigs = 3
+	s, err := td.ToHDR(1, 1e8, sigfigs, 1e-3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := tdigest.FromHDR(s, 1e-3, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Count() != 100000 {
+		t.Errorf("unexpected count %v", back.Count())
+	}
+	// Quantiles agree within the precision of the HDR histogram.
+	precision := math.Pow10(-sigfigs)
+	for q := 0.01; q < 1; q += 0.01 {
+		want, got := td.Quantile(q), back.Quantile(q)
+		if math.Abs(got-want) > 2*precision*want+1e-3 {
+			t.Errorf("q=%v: got %v, want %v", q, got, want)
+		}
+	}
+
+	// Values beyond the highest trackable value are counted in its bucket.
+	td = tdigest.NewWithCompression(100)
+	td.AddValues([]float64{1, 2, 5000})
+	s, err = td.ToHDR(1, 1000, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err = tdigest.FromHDR(s, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Count() != 3 || back.Max() != 1000 {
+		t.Errorf("unexpected count %v and maximum %v", back.Count(), back.Max())
+	}
+}
+
+func TestHDR_Invalid(t *testing.T) {
+	s, err := tdigest.NewWithCompression(100).ToHDR(1, 1000, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tdigest.FromHDR(s, 0, 100); !errors.Is(err, tdigest.ErrInvalidHDR) {
+		t.Errorf("unexpected error for zero unit %v", err)
+	}
+	short := s
+	short.Counts = short.Counts[1:]
+	if _, err := tdigest.FromHDR(short, 1, 100); !errors.Is(err, tdigest.ErrInvalidHDR) {
+		t.Errorf("unexpected error for short counts %v", err)
+	}
+	s.Counts[3] = -1
+	if _, err := tdigest.FromHDR(s, 1, 100); !errors.Is(err, tdigest.ErrInvalidHDR) {
+		t.Errorf("unexpected error for negative count %v", err)
+	}
+
+	td := tdigest.NewWithCompression(100)
+	td.AddValues([]float64{-1, 1})
+	if _, err := td.ToHDR(1, 1000, 2, 1); !errors.Is(err, tdigest.ErrInvalidHDR) {
+		t.Errorf("unexpected error for negative values %v", err)
+	}
+
+	// Layouts HdrHistogram does not accept are rejected, not indexed.
+	for _, l := range []struct{ lowest, highest, sigfigs int64 }{
+		{1, -1, 3},
+		{0, 1000, 3},
+		{10, 19, 3},
+		{1, 1000, 0},
+		{1, 1000, 6},
+		{1 << 50, math.MaxInt64, 5},
+	} {
+		if _, err := td.ToHDR(l.lowest, l.highest, l.sigfigs, 1); !errors.Is(err, tdigest.ErrInvalidHDR) {
+			t.Errorf("ToHDR(%d, %d, %d): unexpected error %v", l.lowest, l.highest, l.sigfigs, err)
+		}
+		malformed := tdigest.HDRSnapshot{LowestTrackableValue: l.lowest, HighestTrackableValue: l.highest, SignificantFigures: l.sigfigs, Counts: []int64{1, 2}}
+		if _, err := tdigest.FromHDR(malformed, 1, 100); !errors.Is(err, tdigest.ErrInvalidHDR) {
+			t.Errorf("FromHDR(%d, %d, %d): unexpected error %v", l.lowest, l.highest, l.sigfigs, err)
+		}
+	}
+}
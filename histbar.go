@@ -0,0 +1,86 @@
+package tdigest
+
+import (
+	"fmt"
+	"math"
+)
+
+// ErrInvalidBar is returned by AddHistogramBar for a bar whose lower bound is
+// above its upper bound.
+const ErrInvalidBar = Error("histogram bar lower bound must not be above its upper bound")
+
+// BarOption configures a call of AddHistogramBar.
+type BarOption func(*barConfig)
+
+type barConfig struct {
+	centroids int
+}
+
+// WithBarCentroids spreads the count of a bar over k centroids. A k of zero
+// or less keeps the default.
+func WithBarCentroids(k int) BarOption {
+	return func(c *barConfig) {
+		if k > 0 {
+			c.centroids = k
+		}
+	}
+}
+
+// AddHistogramBar adds count values spread evenly over [lo, hi], as for a
+// bucket of a histogram, rather than all at one point. The count is split
+// between k centroids at the middles of k equal parts of the interval, so
+// that quantiles inside the bar interpolate across it. By default k is the
+// compression rounded up, but no more than the count, so that no centroid
+// weighs less than 1; WithBarCentroids sets it. For a digest created
+// WithCentroidRanges each centroid also spans its part of the interval. A bar
+// with lo equal to hi adds a single centroid there.
+//
+// The bounds are clamped or rejected as values are by Add. AddHistogramBar
+// returns an error wrapping ErrInvalidValue for a bound the digest does not
+// accept, ErrInvalidWeight for a count that is not positive and finite, and
+// ErrInvalidBar if lo is above hi, and adds nothing in each case. For
+// ExactCount, the count of the bar is counted as a whole.
+func (t *TDigest) AddHistogramBar(lo, hi, count float64, opts ...BarOption) error {
+	var ok bool
+	if lo, ok = t.admit(lo); !ok {
+		return fmt.Errorf("histogram bar bound %v: %w", lo, ErrInvalidValue)
+	}
+	if hi, ok = t.admit(hi); !ok {
+		return fmt.Errorf("histogram bar bound %v: %w", hi, ErrInvalidValue)
+	}
+	if !validWeight(count) {
+		return fmt.Errorf("histogram bar count %v: %w", count, ErrInvalidWeight)
+	}
+	if lo > hi {
+		return fmt.Errorf("histogram bar [%v, %v]: %w", lo, hi, ErrInvalidBar)
+	}
+
+	c := barConfig{centroids: int(math.Min(math.Ceil(t.compression), math.Max(1, math.Floor(count))))}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	k := c.centroids
+	if lo == hi {
+		k = 1
+	}
+	t.countWeights(count, 1)
+	t.updateBounds(lo)
+	t.updateBounds(hi)
+	// Points of the bar are weighted averages of its bounds, which cannot
+	// overflow as hi-lo can, and which end at hi exactly.
+	at := func(f float64) float64 { return lo*(1-f) + hi*f }
+	w := count / float64(k)
+	for i := 0; i < k; i++ {
+		from, to := at(float64(i)/float64(k)), at(float64(i+1)/float64(k))
+		mean := math.Max(from, math.Min(at((float64(i)+0.5)/float64(k)), to))
+		if t.ranges {
+			t.appendCentroidRange(Centroid{Mean: mean, Weight: w}, CentroidRange{from, to})
+		} else {
+			t.appendCentroid(Centroid{Mean: mean, Weight: w})
+		}
+		if t.shouldProcess() {
+			t.process()
+		}
+	}
+	return nil
+}
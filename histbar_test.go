@@ -0,0 +1,112 @@
+package tdigest_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestTdigest_AddHistogramBar(t *testing.T) {
+	// Buckets of uniform data, imported as bars, give back the uniform
+	// quantiles inside each bucket, where a centroid at the middle of each
+	// bucket gives steps.
+	bars := tdigest.NewWithCompression(100)
+	mids := tdigest.NewWithCompression(100)
+	for lo := 0.0; lo < 10; lo++ {
+		if err := bars.AddHistogramBar(lo, lo+1, 1000); err != nil {
+			t.Fatal(err)
+		}
+		mids.Add(lo+0.5, 1000)
+	}
+	if bars.Count() != 10000 || bars.Min() != 0 || bars.Max() != 10 {
+		t.Fatalf("unexpected digest %v", bars)
+	}
+	var barErr, midErr float64
+	for q := 0.001; q < 1; q += 0.001 {
+		barErr = math.Max(barErr, math.Abs(bars.Quantile(q)-10*q))
+		midErr = math.Max(midErr, math.Abs(mids.Quantile(q)-10*q))
+		if got := bars.CDF(10 * q); math.Abs(got-q) > 0.005 {
+			t.Errorf("CDF(%g) = %g, want %g", 10*q, got, q)
+		}
+	}
+	if barErr > 0.05 {
+		t.Errorf("quantile error %g inside bars", barErr)
+	}
+	if midErr < 0.2 {
+		t.Errorf("quantile error %g at bucket midpoints, expected steps", midErr)
+	}
+
+	// Quantiles rise steadily through a single bar.
+	one := tdigest.NewWithCompression(100)
+	if err := one.AddHistogramBar(5, 7, 300, tdigest.WithBarCentroids(30)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(one.Centroids()); n != 30 {
+		t.Errorf("%d centroids, want 30", n)
+	}
+	prev := one.Quantile(0)
+	for q := 0.01; q <= 1; q += 0.01 {
+		v := one.Quantile(q)
+		if !(v > prev) {
+			t.Errorf("Quantile(%g) = %g, not above %g", q, v, prev)
+		}
+		prev = v
+	}
+
+	point := tdigest.NewWithCompression(100)
+	if err := point.AddHistogramBar(3, 3, 10); err != nil {
+		t.Fatal(err)
+	}
+	if cs := point.Centroids(); len(cs) != 1 || cs[0] != (tdigest.Centroid{Mean: 3, Weight: 10}) {
+		t.Errorf("point bar gave %v", cs)
+	}
+}
+
+func TestTdigest_AddHistogramBarRanges(t *testing.T) {
+	td := tdigest.NewWithCompression(100, tdigest.WithCentroidRanges())
+	if err := td.AddHistogramBar(0, 1, 4, tdigest.WithBarCentroids(4)); err != nil {
+		t.Fatal(err)
+	}
+	want := []tdigest.CentroidRange{{0, 0.25}, {0.25, 0.5}, {0.5, 0.75}, {0.75, 1}}
+	rs := td.CentroidRanges()
+	if len(rs) != len(want) {
+		t.Fatalf("ranges %v, want %v", rs, want)
+	}
+	for i := range want {
+		if rs[i] != want[i] {
+			t.Errorf("range %d is %v, want %v", i, rs[i], want[i])
+		}
+	}
+}
+
+func TestTdigest_AddHistogramBarInvalid(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	for _, tc := range []struct {
+		lo, hi, count float64
+		err           error
+	}{
+		{math.NaN(), 1, 1, tdigest.ErrInvalidValue},
+		{0, math.Inf(1), 1, tdigest.ErrInvalidValue},
+		{0, 1, 0, tdigest.ErrInvalidWeight},
+		{0, 1, math.NaN(), tdigest.ErrInvalidWeight},
+		{2, 1, 1, tdigest.ErrInvalidBar},
+	} {
+		if err := td.AddHistogramBar(tc.lo, tc.hi, tc.count); !errors.Is(err, tc.err) {
+			t.Errorf("AddHistogramBar(%g, %g, %g) = %v, want %v", tc.lo, tc.hi, tc.count, err, tc.err)
+		}
+	}
+	if td.Count() != 0 || td.Dropped() != 0 {
+		t.Errorf("invalid bars added to %v", td)
+	}
+
+	clamped := tdigest.NewWithCompression(100, tdigest.WithClamp(0, 10))
+	if err := clamped.AddHistogramBar(5, math.Inf(1), 10); err != nil || clamped.Max() != 10 {
+		t.Errorf("clamped bar: %v, max %g", err, clamped.Max())
+	}
+	huge := tdigest.NewWithCompression(100)
+	if err := huge.AddHistogramBar(-math.MaxFloat64, math.MaxFloat64, 10); err != nil || huge.Count() != 10 || huge.Dropped() != 0 {
+		t.Errorf("bar over all floats: %v, %v", err, huge)
+	}
+}
@@ -0,0 +1,135 @@
+package tdigest
+
+import (
+	"math"
+	"time"
+)
+
+// ProcessStats describes one processing of pending data, as passed to the
+// hook of WithProcessHook.
+type ProcessStats struct {
+	// Before is the number of processed and pending centroids before, and
+	// After the number of processed centroids after.
+	Before int
+	After  int
+
+	// MergedWeight is the weight of the pending centroids merged in.
+	MergedWeight float64
+
+	Duration time.Duration
+}
+
+// DropReason is the reason a value was dropped, as passed to the hook of
+// WithDropHook.
+type DropReason int
+
+const (
+	// DropNaN is a value that is NaN.
+	DropNaN DropReason = iota
+	// DropInfinite is an infinite value added to a digest that does not
+	// clamp.
+	DropInfinite
+	// DropInvalidWeight is a value whose weight is not positive and finite.
+	DropInvalidWeight
+)
+
+func (r DropReason) String() string {
+	switch r {
+	case DropNaN:
+		return "NaN"
+	case DropInfinite:
+		return "infinite"
+	case DropInvalidWeight:
+		return "invalid weight"
+	}
+	return "unknown"
+}
+
+// WithProcessHook calls fn each time the digest processes pending data.
+//
+// The hooks of a ConcurrentTDigest, ShardedTDigest or RotatingTDigest run
+// after the call that triggered them has released its locks, so they may
+// call methods of the digest; hooks triggered by different goroutines may
+// then run concurrently and out of order. The merged digest a ShardedTDigest
+// keeps for queries runs no hooks.
+func WithProcessHook(fn func(ProcessStats)) Option {
+	return func(t *TDigest) {
+		t.onProcess = fn
+	}
+}
+
+// WithDropHook calls fn for each value the digest drops, with the value and
+// its weight. Hooks run as described for WithProcessHook.
+func WithDropHook(fn func(reason DropReason, x, w float64)) Option {
+	return func(t *TDigest) {
+		t.onDrop = fn
+	}
+}
+
+// hookEvent is a call of a hook queued to run once t is unlocked.
+type hookEvent struct {
+	process bool
+	stats   ProcessStats
+	reason  DropReason
+	x, w    float64
+}
+
+// drop counts the dropped value x of weight w and reports it to the hook.
+func (t *TDigest) drop(x, w float64) {
+	t.dropped++
+	if t.onDrop == nil {
+		return
+	}
+	reason := DropInvalidWeight
+	switch {
+	case math.IsNaN(x):
+		reason = DropNaN
+	case math.IsInf(x, 0) && !t.clamp:
+		reason = DropInfinite
+	}
+	t.fire(hookEvent{reason: reason, x: x, w: w})
+}
+
+// reportProcess reports a processing that started at start to the hook.
+func (t *TDigest) reportProcess(start time.Time, before int, weight float64) {
+	t.fire(hookEvent{process: true, stats: ProcessStats{
+		Before:       before,
+		After:        t.processed.Len(),
+		MergedWeight: weight,
+		Duration:     time.Since(start),
+	}})
+}
+
+// fire runs the hook of e, or queues it if a wrapper holds a lock on t.
+func (t *TDigest) fire(e hookEvent) {
+	if t.queueHooks {
+		t.hookEvents = append(t.hookEvents, e)
+		return
+	}
+	t.runHook(e)
+}
+
+func (t *TDigest) runHook(e hookEvent) {
+	if e.process {
+		t.onProcess(e.stats)
+	} else {
+		t.onDrop(e.reason, e.x, e.w)
+	}
+}
+
+// takeHookEvents returns the queued hook events and clears the queue. The
+// caller runs them with runHooks once it has released its locks.
+func (t *TDigest) takeHookEvents() []hookEvent {
+	if len(t.hookEvents) == 0 {
+		return nil
+	}
+	events := t.hookEvents
+	t.hookEvents = nil
+	return events
+}
+
+func (t *TDigest) runHooks(events []hookEvent) {
+	for _, e := range events {
+		t.runHook(e)
+	}
+}
@@ -0,0 +1,143 @@
+package tdigest_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestTdigest_ProcessHook(t *testing.T) {
+	var calls []tdigest.ProcessStats
+	td := tdigest.NewWithCompression(100, tdigest.WithProcessHook(func(s tdigest.ProcessStats) {
+		calls = append(calls, s)
+	}))
+	td.AddValues(NormalData[:10000])
+	td.Flush()
+	if uint64(len(calls)) != td.Stats().Processes {
+		t.Errorf("hook called %d times for %d processes", len(calls), td.Stats().Processes)
+	}
+	var merged float64
+	for _, s := range calls {
+		if s.After > s.Before || s.After == 0 || s.Duration < 0 {
+			t.Errorf("unexpected stats %+v", s)
+		}
+		merged += s.MergedWeight
+	}
+	if merged != 10000 {
+		t.Errorf("merged weight %v, want 10000", merged)
+	}
+}
+
+func TestTdigest_DropHook(t *testing.T) {
+	type drop struct {
+		reason tdigest.DropReason
+		x, w   float64
+	}
+	var drops []drop
+	hook := tdigest.WithDropHook(func(reason tdigest.DropReason, x, w float64) {
+		drops = append(drops, drop{reason, x, w})
+	})
+	td := tdigest.NewWithCompression(100, hook)
+	td.Add(math.Inf(1), 1)
+	td.Add(1, 0)
+	td.AddValues([]float64{2, math.Inf(-1)})
+	td.AddValuesWeighted([]float64{3, 4}, -1)
+	td.AddCentroid(tdigest.Centroid{Mean: 5, Weight: math.Inf(1)})
+	want := []drop{
+		{tdigest.DropInfinite, math.Inf(1), 1},
+		{tdigest.DropInvalidWeight, 1, 0},
+		{tdigest.DropInfinite, math.Inf(-1), 1},
+		{tdigest.DropInvalidWeight, 3, -1},
+		{tdigest.DropInvalidWeight, 4, -1},
+		{tdigest.DropInvalidWeight, 5, math.Inf(1)},
+	}
+	if len(drops) != len(want) {
+		t.Fatalf("got drops %v, want %v", drops, want)
+	}
+	for i := range want {
+		if drops[i] != want[i] {
+			t.Errorf("drop %d: got %v, want %v", i, drops[i], want[i])
+		}
+	}
+	if td.Dropped() != uint64(len(want)) {
+		t.Errorf("Dropped() = %d", td.Dropped())
+	}
+
+	drops = nil
+	td.Add(math.NaN(), 1)
+	if len(drops) != 1 || drops[0].reason != tdigest.DropNaN || drops[0].reason.String() != "NaN" {
+		t.Errorf("unexpected drops %v", drops)
+	}
+
+	// A clamping digest only drops NaN.
+	drops = nil
+	clamped := tdigest.NewWithCompression(100, hook, tdigest.WithClamp(0, 1))
+	clamped.AddValues([]float64{math.Inf(1), math.NaN()})
+	if len(drops) != 1 || drops[0].reason != tdigest.DropNaN {
+		t.Errorf("unexpected drops %v", drops)
+	}
+}
+
+// TestHooks_Reentrant checks that hooks of the locking digests may call
+// methods of the digest that triggered them.
+func TestHooks_Reentrant(t *testing.T) {
+	var c *tdigest.ConcurrentTDigest
+	var calls int
+	c = tdigest.NewConcurrent(100,
+		tdigest.WithProcessHook(func(tdigest.ProcessStats) {
+			// The process triggered by Quantile calls the hook again.
+			if calls++; calls == 1 {
+				c.Add(1, 1)
+				c.Quantile(0.5)
+			}
+		}),
+		tdigest.WithDropHook(func(tdigest.DropReason, float64, float64) {
+			c.Count()
+		}))
+	c.Add(math.NaN(), 1)
+	c.AddValues(NormalData[:5000])
+	c.Quantile(0.5)
+	c.Snapshot()
+	if calls < 2 {
+		t.Errorf("process hook was called %d times", calls)
+	}
+
+	var s *tdigest.ShardedTDigest
+	s = tdigest.NewSharded(2, 100, tdigest.WithDropHook(func(tdigest.DropReason, float64, float64) {
+		s.Add(1, 1)
+	}))
+	s.Add(math.NaN(), 1)
+	if s.Count() != 1 {
+		t.Errorf("unexpected count %v", s.Count())
+	}
+
+	var r *tdigest.RotatingTDigest
+	calls = 0
+	r = tdigest.NewRotating(100, tdigest.WithProcessHook(func(tdigest.ProcessStats) {
+		if calls++; calls == 1 {
+			r.Add(1, 1)
+		}
+	}))
+	r.Add(2, 1)
+	if f := r.Rotate(); f.Count() != 1 || calls != 1 {
+		t.Errorf("unexpected count %v after %d calls", f.Count(), calls)
+	}
+	if f := r.Rotate(); f.Count() != 1 {
+		t.Errorf("the value added by the hook is missing: %v", f.Count())
+	}
+}
+
+func BenchmarkTDigest_AddHooks(b *testing.B) {
+	var processes int
+	opts := []tdigest.Option{
+		tdigest.WithProcessHook(func(tdigest.ProcessStats) { processes++ }),
+		tdigest.WithDropHook(func(tdigest.DropReason, float64, float64) {}),
+	}
+	for n := 0; n < b.N; n++ {
+		td := tdigest.NewWithCompression(1000, opts...)
+		for _, x := range NormalData {
+			td.Add(x, 1)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Package httpdigest records the latency of HTTP handlers in t-digests, one
+// for each route and method.
+//
+// The route of a request is found by a RouteFunc, which is called after the
+// handler returns so that routers that record the matched pattern in the
+// request context while routing can be used. For chi, for example:
+//
+//	httpdigest.WithRoute(func(r *http.Request) string {
+//		return chi.RouteContext(r.Context()).RoutePattern()
+//	})
+//
+// Routers that pass the matched route only to the handler, as gorilla/mux
+// does with mux.CurrentRoute, need the middleware installed inside them, such
+// as with Router.Use.
+package httpdigest
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RouteFunc returns the route of a request. Routes should be patterns rather
+// than paths, since every route gets its own digests.
+type RouteFunc func(r *http.Request) string
+
+// PathRoute uses the path of the request as its route. It suits only
+// handlers that serve a small, fixed set of paths.
+func PathRoute(r *http.Request) string {
+	return r.URL.Path
+}
+
+// ServeMuxRoute returns a RouteFunc that uses the pattern of mux that matches
+// the request, or the empty string if none does.
+func ServeMuxRoute(mux *http.ServeMux) RouteFunc {
+	return func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		return pattern
+	}
+}
+
+// Option configures the middleware returned by Wrap.
+type Option func(c *config)
+
+type config struct {
+	registry *Registry
+	route    RouteFunc
+	size     bool
+	status   bool
+}
+
+// WithRegistry records into r instead of DefaultRegistry.
+func WithRegistry(r *Registry) Option {
+	return func(c *config) {
+		c.registry = r
+	}
+}
+
+// WithRoute finds the route of requests with f. By default Wrap uses
+// ServeMuxRoute if the handler is an *http.ServeMux and PathRoute otherwise.
+func WithRoute(f RouteFunc) Option {
+	return func(c *config) {
+		c.route = f
+	}
+}
+
+// WithResponseSize also records the number of bytes written to the response
+// body.
+func WithResponseSize() Option {
+	return func(c *config) {
+		c.size = true
+	}
+}
+
+// WithStatusClass keeps separate digests for each class of response status,
+// such as "2xx" and "5xx".
+func WithStatusClass() Option {
+	return func(c *config) {
+		c.status = true
+	}
+}
+
+// Wrap returns a handler that calls next and records the duration of each
+// request, from the call until next returns, in the digests of its key.
+func Wrap(next http.Handler, opts ...Option) http.Handler {
+	c := config{registry: DefaultRegistry, route: PathRoute}
+	if mux, ok := next.(*http.ServeMux); ok {
+		c.route = ServeMuxRoute(mux)
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+		d := time.Since(start)
+
+		k := Key{Route: c.route(r), Method: r.Method}
+		if c.status {
+			k.Status = statusClass(rw.status)
+		}
+		c.registry.durations.Add(d.Seconds(), 1, k.Route, k.Method, k.Status)
+		if c.size {
+			c.registry.sizes.Add(float64(rw.written), 1, k.Route, k.Method, k.Status)
+		}
+	})
+}
+
+// statusClass returns the class of the status code, such as "2xx". A handler
+// that writes no header responds with 200.
+func statusClass(code int) string {
+	switch {
+	case code == 0:
+		return "2xx"
+	case code >= 100 && code < 600:
+		return string(rune('0'+code/100)) + "xx"
+	}
+	return "unknown"
+}
+
+// responseWriter records the status and body size of a response.
+//
+// It passes http.Flusher, http.Hijacker, http.Pusher and io.ReaderFrom on to
+// the underlying writer, so handlers wrapped by Wrap can still use them. It
+// has them whether or not the underlying writer does: Hijack and Push then
+// return http.ErrNotSupported, and ReadFrom copies through Write. Other
+// interfaces are reached with http.ResponseController, which calls Unwrap.
+type responseWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+// WriteHeader records the first final status; informational ones may come
+// before it.
+func (w *responseWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// Flush flushes the underlying writer if it can be flushed.
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hijacks the underlying connection if the underlying writer can.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+// Push pushes target if the underlying writer supports HTTP/2 server push.
+func (w *responseWriter) Push(target string, opts *http.PushOptions) error {
+	p, ok := w.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return p.Push(target, opts)
+}
+
+// ReadFrom copies r to the response, with the underlying writer's ReadFrom
+// if it has one, such as the sendfile path of *http.response.
+func (w *responseWriter) ReadFrom(r io.Reader) (int64, error) {
+	rf, ok := w.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		// Hide ReadFrom so that io.Copy writes through Write.
+		return io.Copy(struct{ io.Writer }{w}, r)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := rf.ReadFrom(r)
+	w.written += n
+	return n, err
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,154 @@
+package httpdigest_test
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/tdigest/httpdigest"
+)
+
+func TestWrap(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fast", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 100))
+	})
+	mux.HandleFunc("/slow/", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Millisecond)
+		if strings.HasSuffix(r.URL.Path, "/missing") {
+			http.NotFound(w, r)
+		}
+	})
+	reg := httpdigest.NewRegistry(100)
+	srv := httptest.NewServer(httpdigest.Wrap(mux,
+		httpdigest.WithRegistry(reg), httpdigest.WithResponseSize(), httpdigest.WithStatusClass()))
+	defer srv.Close()
+
+	get := func(path string) {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	for i := 0; i < 300; i++ {
+		get("/fast")
+		if i%3 == 0 {
+			get(fmt.Sprintf("/slow/%d", i))
+		}
+		if i%30 == 0 {
+			get("/slow/missing")
+		}
+	}
+
+	want := []httpdigest.Key{
+		{Route: "/fast", Method: "GET", Status: "2xx"},
+		{Route: "/slow/", Method: "GET", Status: "2xx"},
+		{Route: "/slow/", Method: "GET", Status: "4xx"},
+	}
+	summaries := reg.Summaries(0.5, 0.99)
+	if len(summaries) != len(want) {
+		t.Fatalf("unexpected summaries %+v", summaries)
+	}
+	for i, s := range summaries {
+		if s.Key != want[i] {
+			t.Errorf("unexpected key %+v, want %+v", s.Key, want[i])
+		}
+	}
+	fast, slow, missing := summaries[0], summaries[1], summaries[2]
+	if fast.Duration.Count != 300 || slow.Duration.Count != 100 || missing.Duration.Count != 10 {
+		t.Errorf("unexpected counts %v, %v, %v", fast.Duration.Count, slow.Duration.Count, missing.Duration.Count)
+	}
+	if p50 := slow.Duration.Quantiles[0].Value; p50 < 0.002 {
+		t.Errorf("median of slow requests %v is less than their sleep", p50)
+	}
+	if p99 := slow.Duration.Quantiles[1].Value; p99 < slow.Duration.Quantiles[0].Value || p99 > 1 {
+		t.Errorf("implausible p99 of slow requests %v", p99)
+	}
+	if fast.Duration.Quantiles[0].Value >= slow.Duration.Quantiles[0].Value {
+		t.Errorf("median of fast requests %v is not less than that of slow ones %v",
+			fast.Duration.Quantiles[0].Value, slow.Duration.Quantiles[0].Value)
+	}
+	if fast.Size.Count != 300 || fast.Size.Quantiles[0].Value != 100 || fast.Size.Quantiles[1].Value != 100 {
+		t.Errorf("unexpected sizes %+v", fast.Size)
+	}
+}
+
+func TestWrap_Route(t *testing.T) {
+	reg := httpdigest.NewRegistry(100)
+	handler := httpdigest.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}), httpdigest.WithRegistry(reg), httpdigest.WithRoute(func(r *http.Request) string {
+		return strings.SplitN(r.URL.Path, "/", 3)[1]
+	}))
+	for _, path := range []string{"/users/1", "/users/2", "/items/1"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+	}
+	keys := reg.Keys()
+	want := []httpdigest.Key{{Route: "items", Method: "POST"}, {Route: "users", Method: "POST"}}
+	if len(keys) != 2 || keys[0] != want[0] || keys[1] != want[1] {
+		t.Errorf("unexpected keys %+v, want %+v", keys, want)
+	}
+	if n := reg.Duration(want[1]).Count(); n != 2 {
+		t.Errorf("unexpected count %v", n)
+	}
+	if n := reg.Size(want[1]).Count(); n != 0 {
+		t.Errorf("sizes were recorded without WithResponseSize: %v", n)
+	}
+}
+
+func TestWrap_Interfaces(t *testing.T) {
+	reg := httpdigest.NewRegistry(100)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/copy", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(io.ReaderFrom); !ok {
+			t.Error("the writer is not an io.ReaderFrom")
+		}
+		io.Copy(w, strings.NewReader(strings.Repeat("x", 1000)))
+	})
+	mux.HandleFunc("/hijack", func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 204 No Content\r\nConnection: close\r\n\r\n")
+		buf.Flush()
+	})
+	srv := httptest.NewServer(httpdigest.Wrap(mux, httpdigest.WithRegistry(reg), httpdigest.WithResponseSize()))
+	defer srv.Close()
+	for _, path := range []string{"/copy", "/hijack"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if s := reg.Size(httpdigest.Key{Route: "/copy", Method: "GET"}).Snapshot(); s.Count() != 1 || s.Quantile(1) != 1000 {
+		t.Errorf("unexpected sizes: count %v, max %v", s.Count(), s.Quantile(1))
+	}
+	if n := reg.Duration(httpdigest.Key{Route: "/hijack", Method: "GET"}).Count(); n != 1 {
+		t.Errorf("unexpected count %v", n)
+	}
+
+	// A writer without the interfaces reports that it does not support them.
+	httpdigest.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := w.(http.Hijacker).Hijack(); err != http.ErrNotSupported {
+			t.Errorf("unexpected Hijack error %v", err)
+		}
+		if err := w.(http.Pusher).Push("/style.css", nil); err != http.ErrNotSupported {
+			t.Errorf("unexpected Push error %v", err)
+		}
+		if n, err := io.Copy(w, strings.NewReader("abc")); n != 3 || err != nil {
+			t.Errorf("unexpected copy %d, %v", n, err)
+		}
+	}), httpdigest.WithRegistry(reg)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
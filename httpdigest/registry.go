@@ -0,0 +1,113 @@
+package httpdigest
+
+import (
+	"sort"
+
+	"github.com/influxdata/tdigest"
+)
+
+// Key identifies the requests whose digests a Registry holds together.
+type Key struct {
+	Route  string
+	Method string
+	// Status is the class of the response status, such as "2xx", if the
+	// middleware tracks status classes, and empty otherwise.
+	Status string
+}
+
+// Registry holds the digests of a set of keys, creating them on first use.
+// It is safe for concurrent use.
+type Registry struct {
+	durations *tdigest.DigestVec
+	// sizes only gets a digest for a key once a size is recorded or asked
+	// for, so that middleware not recording sizes does not pay for them.
+	sizes *tdigest.DigestVec
+}
+
+// DefaultRegistry is the Registry that Wrap records into unless given
+// another.
+var DefaultRegistry = NewRegistry(0)
+
+// NewRegistry returns an empty Registry whose digests have the given
+// compression. Zero means tdigest.DefaultCompression.
+func NewRegistry(compression float64) *Registry {
+	if compression == 0 {
+		compression = tdigest.DefaultCompression
+	}
+	return &Registry{
+		durations: tdigest.NewDigestVec(compression),
+		sizes:     tdigest.NewDigestVec(compression),
+	}
+}
+
+// Duration returns the digest of the durations of the requests of k, in
+// seconds.
+func (r *Registry) Duration(k Key) *tdigest.ConcurrentTDigest {
+	return r.durations.GetOrCreate(k.Route, k.Method, k.Status)
+}
+
+// Size returns the digest of the sizes of the response bodies of k, in
+// bytes. It is empty unless the middleware records sizes.
+func (r *Registry) Size(k Key) *tdigest.ConcurrentTDigest {
+	return r.sizes.GetOrCreate(k.Route, k.Method, k.Status)
+}
+
+// Keys returns the keys of the registry, sorted by route, method and status.
+func (r *Registry) Keys() []Key {
+	seen := make(map[Key]bool)
+	var keys []Key
+	add := func(labels []string, _ *tdigest.ConcurrentTDigest) bool {
+		k := Key{Route: labels[0], Method: labels[1], Status: labels[2]}
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+		return true
+	}
+	r.durations.Range(add)
+	r.sizes.Range(add)
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.Route != b.Route {
+			return a.Route < b.Route
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.Status < b.Status
+	})
+	return keys
+}
+
+// KeySummary holds the summaries of the digests of a key.
+type KeySummary struct {
+	Key
+	Duration tdigest.Summary
+	Size     tdigest.Summary
+}
+
+// Summaries returns the summaries with quantiles qs of the digests of every
+// key, in the order of Keys. Each digest is snapshotted separately, so
+// requests recorded meanwhile may be counted in one and not another.
+func (r *Registry) Summaries(qs ...float64) []KeySummary {
+	keys := r.Keys()
+	summaries := make([]KeySummary, len(keys))
+	for i, k := range keys {
+		summaries[i] = KeySummary{
+			Key:      k,
+			Duration: summary(r.durations, qs, k),
+			Size:     summary(r.sizes, qs, k),
+		}
+	}
+	return summaries
+}
+
+// summary returns the summary of the digest of k in v, which is empty if v
+// has none.
+func summary(v *tdigest.DigestVec, qs []float64, k Key) tdigest.Summary {
+	d, ok := v.Get(k.Route, k.Method, k.Status)
+	if !ok {
+		return new(tdigest.FrozenDigest).Summary(qs...)
+	}
+	return d.Snapshot().Summary(qs...)
+}
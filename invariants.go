@@ -0,0 +1,95 @@
+package tdigest
+
+import (
+	"fmt"
+	"math"
+)
+
+// debugInvariants makes process panic if it leaves t in a state that fails
+// CheckInvariants. The tests enable it.
+var debugInvariants = false
+
+// checkDecoded returns the error of CheckInvariants for t, just decoded from
+// data of the format whose errors wrap invalid, as an error wrapping invalid.
+func checkDecoded(t *TDigest, invalid error) error {
+	if err := t.CheckInvariants(); err != nil {
+		return fmt.Errorf("decoded digest: %v: %w", err, invalid)
+	}
+	return nil
+}
+
+// CheckInvariants verifies the internal consistency of t and returns an error
+// describing the first violation found, or nil. It checks that the compression
+// is valid, that every centroid has a positive and finite weight, that the
+// processed centroids are sorted by mean and lie within the minimum and
+// maximum, and that the cumulative weights are non-decreasing and end at the
+// processed weight. For a digest that keeps centroid ranges it also checks
+// that there is one range for every centroid, containing its mean, and that
+// each processed range lies within the minimum and maximum. It does not
+// process pending data or modify t.
+func (t *TDigest) CheckInvariants() error {
+	if !(t.compression >= MinCompression && t.compression <= MaxCompression) {
+		return fmt.Errorf("invalid compression %g", t.compression)
+	}
+	for i, c := range t.processed {
+		if !validWeight(c.Weight) {
+			return fmt.Errorf("processed centroid %d has invalid weight %g", i, c.Weight)
+		}
+		if math.IsNaN(c.Mean) {
+			return fmt.Errorf("processed centroid %d has a NaN mean", i)
+		}
+		if i > 0 && c.Mean < t.processed[i-1].Mean {
+			return fmt.Errorf("processed centroid %d has mean %g below the previous mean %g", i, c.Mean, t.processed[i-1].Mean)
+		}
+	}
+	for i, c := range t.unprocessed {
+		if !validWeight(c.Weight) {
+			return fmt.Errorf("unprocessed centroid %d has invalid weight %g", i, c.Weight)
+		}
+	}
+	if n := t.processed.Len(); n > 0 {
+		if first := t.processed[0].Mean; t.min > first {
+			return fmt.Errorf("min %g is above the first mean %g", t.min, first)
+		}
+		if last := t.processed[n-1].Mean; t.max < last {
+			return fmt.Errorf("max %g is below the last mean %g", t.max, last)
+		}
+		if len(t.cumulative) != n+1 {
+			return fmt.Errorf("%d cumulative weights for %d processed centroids", len(t.cumulative), n)
+		}
+		for i := 1; i < len(t.cumulative); i++ {
+			if !(t.cumulative[i] >= t.cumulative[i-1]) {
+				return fmt.Errorf("cumulative weight %d is %g, below the previous %g", i, t.cumulative[i], t.cumulative[i-1])
+			}
+		}
+		if last, total := t.cumulative[n], t.processedWeight.value(); last != total {
+			return fmt.Errorf("last cumulative weight %g does not match the processed weight %g", last, total)
+		}
+	}
+	if t.ranges {
+		return t.checkRanges()
+	}
+	return nil
+}
+
+// checkRanges checks the centroid ranges for CheckInvariants.
+func (t *TDigest) checkRanges() error {
+	if len(t.processedRanges) != t.processed.Len() || len(t.unprocessedRanges) != t.unprocessed.Len() {
+		return fmt.Errorf("%d and %d ranges for %d processed and %d unprocessed centroids",
+			len(t.processedRanges), len(t.unprocessedRanges), t.processed.Len(), t.unprocessed.Len())
+	}
+	for i, r := range t.processedRanges {
+		if m := t.processed[i].Mean; !(r.Min <= m && m <= r.Max) {
+			return fmt.Errorf("processed centroid %d has mean %g outside its range [%g, %g]", i, m, r.Min, r.Max)
+		}
+		if r.Min < t.min || r.Max > t.max {
+			return fmt.Errorf("processed centroid %d has range [%g, %g] outside [%g, %g]", i, r.Min, r.Max, t.min, t.max)
+		}
+	}
+	for i, r := range t.unprocessedRanges {
+		if m := t.unprocessed[i].Mean; !(r.Min <= m && m <= r.Max) {
+			return fmt.Errorf("unprocessed centroid %d has mean %g outside its range [%g, %g]", i, m, r.Min, r.Max)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package tdigest_test
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestTdigest_CheckInvariants(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(cl tdigest.CentroidList)
+		want    string
+	}{
+		{
+			name:    "valid",
+			corrupt: func(tdigest.CentroidList) {},
+		},
+		{
+			name:    "unsorted",
+			corrupt: func(cl tdigest.CentroidList) { cl[3].Mean, cl[4].Mean = cl[4].Mean, cl[3].Mean },
+			want:    "processed centroid 4 has mean",
+		},
+		{
+			name:    "zero weight",
+			corrupt: func(cl tdigest.CentroidList) { cl[2].Weight = 0 },
+			want:    "processed centroid 2 has invalid weight 0",
+		},
+		{
+			name:    "NaN weight",
+			corrupt: func(cl tdigest.CentroidList) { cl[5].Weight = math.NaN() },
+			want:    "processed centroid 5 has invalid weight NaN",
+		},
+		{
+			name:    "NaN mean",
+			corrupt: func(cl tdigest.CentroidList) { cl[1].Mean = math.NaN() },
+			want:    "processed centroid 1 has a NaN mean",
+		},
+		{
+			name:    "below min",
+			corrupt: func(cl tdigest.CentroidList) { cl[0].Mean -= 100 },
+			want:    "min",
+		},
+		{
+			name:    "above max",
+			corrupt: func(cl tdigest.CentroidList) { cl[len(cl)-1].Mean += 100 },
+			want:    "max",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := tdigest.NewWithCompression(100)
+			for _, x := range NormalData[:10000] {
+				td.Add(x, 1)
+			}
+			// Corrupt the centroids through the read-only view.
+			tt.corrupt(td.Centroids())
+			err := td.CheckInvariants()
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("unexpected error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+
+	if err := tdigest.New().CheckInvariants(); err != nil {
+		t.Errorf("unexpected error for an empty digest: %v", err)
+	}
+}
+
+func TestCheckDecoded(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	td.AddValues(NormalData[:10000])
+	b := mustMarshal(t, td)
+	decoded, err := tdigest.FromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tdigest.CheckDecoded(decoded, tdigest.ErrInvalidEncoding); err != nil {
+		t.Errorf("unexpected error for a valid digest: %v", err)
+	}
+	decoded.Centroids()[0].Weight = -1
+	err = tdigest.CheckDecoded(decoded, tdigest.ErrInvalidEncoding)
+	if !errors.Is(err, tdigest.ErrInvalidEncoding) || !strings.Contains(err.Error(), "processed centroid 0 has invalid weight -1") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package tdigest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// ErrInvalidJSON is returned by DecodeJSONStream for JSON that is not a
+// digest encoded by MarshalJSON.
+const ErrInvalidJSON = Error("invalid JSON digest")
+
+// jsonBatch is the number of centroids DecodeJSONStream decodes before adding
+// them to the digest.
+const jsonBatch = 4096
+
+// digestJSON is the JSON encoding of a digest.
+type digestJSON struct {
+	Compression float64      `json:"compression"`
+	Min         *float64     `json:"min,omitempty"`
+	Max         *float64     `json:"max,omitempty"`
+	Centroids   CentroidList `json:"centroids"`
+}
+
+// UnmarshalJSON decodes either an object with the fields mean and weight, as
+// a Centroid is encoded, or an array of the mean and weight, as in a
+// CompactCentroidList. The centroid is not validated.
+func (c *Centroid) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimLeft(b, " \t\r\n")
+	if len(b) > 0 && b[0] == '[' {
+		var pair []float64
+		if err := json.Unmarshal(b, &pair); err != nil {
+			return err
+		}
+		if len(pair) != 2 {
+			return fmt.Errorf("centroid of %d numbers: %w", len(pair), ErrInvalidJSON)
+		}
+		c.Mean, c.Weight = pair[0], pair[1]
+		return nil
+	}
+	// The conversion drops this method, so that the fields are decoded as
+	// usual.
+	type centroid Centroid
+	return json.Unmarshal(b, (*centroid)(c))
+}
+
+// MarshalJSON encodes l as an array of centroids, each an object with the
+// fields mean and weight. A nil list is encoded as an empty array.
+func (l CentroidList) MarshalJSON() ([]byte, error) {
+	if l == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]Centroid(l))
+}
+
+// UnmarshalJSON decodes an array of centroids, each as an object or a pair as
+// Centroid.UnmarshalJSON decodes them, and validates the list with Validate.
+func (l *CentroidList) UnmarshalJSON(b []byte) error {
+	var cs []Centroid
+	if err := json.Unmarshal(b, &cs); err != nil {
+		return err
+	}
+	if err := CentroidList(cs).Validate(); err != nil {
+		return err
+	}
+	*l = cs
+	return nil
+}
+
+// CompactCentroidList is a CentroidList that is encoded in JSON as an array of
+// [mean, weight] pairs, which takes about half the space of the objects of a
+// CentroidList. It is decoded as a CentroidList is, so either form is read.
+type CompactCentroidList CentroidList
+
+// MarshalJSON encodes l as an array of [mean, weight] pairs.
+func (l CompactCentroidList) MarshalJSON() ([]byte, error) {
+	pairs := make([][2]float64, len(l))
+	for i, c := range l {
+		pairs[i] = [2]float64{c.Mean, c.Weight}
+	}
+	return json.Marshal(pairs)
+}
+
+// UnmarshalJSON decodes l as CentroidList.UnmarshalJSON does.
+func (l *CompactCentroidList) UnmarshalJSON(b []byte) error {
+	return (*CentroidList)(l).UnmarshalJSON(b)
+}
+
+// MarshalJSON processes pending data and encodes the digest as
+// FrozenDigest.MarshalJSON does.
+func (t *TDigest) MarshalJSON() ([]byte, error) {
+	t.Flush()
+	f := t.frozen()
+	return f.MarshalJSON()
+}
+
+// MarshalJSON encodes the snapshot as an object with the fields compression,
+// min, max and centroids, the last encoded as a CentroidList. Min and max are
+// omitted for an empty digest.
+func (f *FrozenDigest) MarshalJSON() ([]byte, error) {
+	d := digestJSON{Compression: f.compression, Centroids: f.processed}
+	if len(f.processed) > 0 {
+		d.Min, d.Max = &f.min, &f.max
+	}
+	return json.Marshal(d)
+}
+
+// DecodeJSONStream reads a digest encoded by MarshalJSON from dec and returns
+// it, created with the given options. It decodes the centroids one at a time
+// and adds them in batches, so that however many there are, it needs memory
+// only for the digest; that holds if compression comes before centroids, as
+// MarshalJSON writes them, since otherwise the centroids must be kept until
+// the compression is known. Unknown fields are skipped.
+//
+// It returns an error wrapping ErrInvalidJSON if the value is not an object
+// or lacks a compression or if the decoded digest fails CheckInvariants, one
+// wrapping ErrInvalidCompression or ErrInvalidCentroid for an invalid
+// compression or centroid, and the errors of dec for malformed JSON.
+func DecodeJSONStream(dec *json.Decoder, opts ...Option) (*TDigest, error) {
+	var (
+		t        *TDigest
+		pending  CentroidList
+		index    int
+		min, max *float64
+	)
+	add := func(l CentroidList) error {
+		if t == nil {
+			pending = append(pending, l...)
+			return nil
+		}
+		for i, c := range l {
+			if _, ok := t.admit(c.Mean); !ok || !validWeight(c.Weight) {
+				return fmt.Errorf("centroid %d {Mean: %g, Weight: %g}: %w", index+i, c.Mean, c.Weight, ErrInvalidCentroid)
+			}
+		}
+		t.AddCentroidList(l)
+		index += len(l)
+		return nil
+	}
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch key := tok.(string); key {
+		case "compression":
+			var c float64
+			if err := dec.Decode(&c); err != nil {
+				return nil, err
+			}
+			if t != nil {
+				return nil, fmt.Errorf("repeated compression: %w", ErrInvalidJSON)
+			}
+			if t, err = NewChecked(c, opts...); err != nil {
+				return nil, fmt.Errorf("compression %v: %w", c, err)
+			}
+			l := pending
+			pending = nil
+			if err := add(l); err != nil {
+				return nil, err
+			}
+		case "min", "max":
+			x := new(float64)
+			if err := dec.Decode(x); err != nil {
+				return nil, err
+			}
+			if key == "min" {
+				min = x
+			} else {
+				max = x
+			}
+		case "centroids":
+			if err := expectDelim(dec, '['); err != nil {
+				return nil, err
+			}
+			batch := make(CentroidList, 0, jsonBatch)
+			for dec.More() {
+				var c Centroid
+				if err := dec.Decode(&c); err != nil {
+					return nil, err
+				}
+				if batch = append(batch, c); len(batch) == cap(batch) {
+					if err := add(batch); err != nil {
+						return nil, err
+					}
+					batch = batch[:0]
+				}
+			}
+			if err := add(batch); err != nil {
+				return nil, err
+			}
+			if err := expectDelim(dec, ']'); err != nil {
+				return nil, err
+			}
+		default:
+			if err := skipJSONValue(dec); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, fmt.Errorf("no compression: %w", ErrInvalidJSON)
+	}
+	if t.processed.Len()+t.unprocessed.Len() > 0 {
+		for _, x := range [...]*float64{min, max} {
+			if x == nil {
+				continue
+			}
+			if x, ok := t.admit(*x); ok {
+				t.updateBounds(x)
+			}
+		}
+	}
+	if err := checkDecoded(t, ErrInvalidJSON); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// expectDelim reads the next token of dec, which must be delim.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("got %v, want %v: %w", tok, delim, ErrInvalidJSON)
+	}
+	return nil
+}
+
+// skipJSONValue reads the next value of dec a token at a time, so that even
+// a large value is not held in memory.
+func skipJSONValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
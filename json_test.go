@@ -0,0 +1,206 @@
+package tdigest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestTdigest_MarshalJSON(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	td.AddValues(NormalData[:10000])
+	b, err := json.Marshal(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := tdigest.DecodeJSONStream(json.NewDecoder(bytes.NewReader(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(mustMarshal(t, back), mustMarshal(t, td)) {
+		t.Error("digest differs after a round trip")
+	}
+
+	b, err = json.Marshal(tdigest.NewWithCompression(50).Snapshot())
+	if want := `{"compression":50,"centroids":[]}`; err != nil || string(b) != want {
+		t.Errorf("got %s, %v, want %s", b, err, want)
+	}
+}
+
+func TestDecodeJSONStream(t *testing.T) {
+	// Unknown fields are skipped, and the compression may follow the
+	// centroids.
+	input := `{"version": {"major": [1, {"x": null}]}, "max": 9,
+		"centroids": [{"mean": 1, "weight": 2, "extra": "x"}, {"weight": 1, "mean": 3}],
+		"compression": 100, "min": 0.5}
+		{"compression": 50, "centroids": []}`
+	dec := json.NewDecoder(strings.NewReader(input))
+	td, err := tdigest.DecodeJSONStream(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if td.Compression() != 100 || td.Count() != 3 || td.Min() != 0.5 || td.Max() != 9 {
+		t.Errorf("unexpected digest %v", td)
+	}
+	if td, err := tdigest.DecodeJSONStream(dec); err != nil || td.Compression() != 50 || td.Count() != 0 {
+		t.Errorf("second digest %v, %v", td, err)
+	}
+	if _, err := tdigest.DecodeJSONStream(dec); err != io.EOF {
+		t.Errorf("got %v at the end of the stream, want EOF", err)
+	}
+}
+
+func TestDecodeJSONStream_Invalid(t *testing.T) {
+	tests := []struct {
+		input string
+		want  error
+	}{
+		{`[]`, tdigest.ErrInvalidJSON},
+		{`{"centroids": []}`, tdigest.ErrInvalidJSON},
+		{`{"compression": 100, "compression": 100}`, tdigest.ErrInvalidJSON},
+		{`{"compression": 100, "centroids": {}}`, tdigest.ErrInvalidJSON},
+		{`{"compression": 1}`, tdigest.ErrInvalidCompression},
+		{`{"compression": 100, "centroids": [{"mean": 1, "weight": 1}, {"mean": 1}]}`, tdigest.ErrInvalidCentroid},
+		{`{"centroids": [{"mean": 1, "weight": -1}], "compression": 100}`, tdigest.ErrInvalidCentroid},
+	}
+	for _, tt := range tests {
+		_, err := tdigest.DecodeJSONStream(json.NewDecoder(strings.NewReader(tt.input)))
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.input, err, tt.want)
+		}
+	}
+
+	_, err := tdigest.DecodeJSONStream(json.NewDecoder(strings.NewReader(`{"compression": 100, "centroids": [{"mean": 1, "weight": 1}, {"mean": 2, "weight": 0}]}`)))
+	if err == nil || !strings.Contains(err.Error(), "centroid 1 ") {
+		t.Errorf("error %v does not give the index of the centroid", err)
+	}
+	if _, err := tdigest.DecodeJSONStream(json.NewDecoder(strings.NewReader(`{"compression": 100, "centroids": [`))); err == nil {
+		t.Error("truncated JSON accepted")
+	}
+}
+
+func TestCentroidList_JSON(t *testing.T) {
+	l := tdigest.CentroidList{{Mean: 1.5, Weight: 2}, {Mean: 3, Weight: 0.25}}
+	b, err := json.Marshal(l)
+	if want := `[{"mean":1.5,"weight":2},{"mean":3,"weight":0.25}]`; err != nil || string(b) != want {
+		t.Errorf("got %s, %v, want %s", b, err, want)
+	}
+	compact, err := json.Marshal(tdigest.CompactCentroidList(l))
+	if want := `[[1.5,2],[3,0.25]]`; err != nil || string(compact) != want {
+		t.Errorf("got %s, %v, want %s", compact, err, want)
+	}
+	if b, err := json.Marshal(tdigest.CentroidList(nil)); err != nil || string(b) != "[]" {
+		t.Errorf("nil list encoded as %s, %v", b, err)
+	}
+
+	for _, input := range []string{string(b), string(compact), `[{"weight":2,"mean":1.5}, [3, 0.25]]`} {
+		var got tdigest.CentroidList
+		if err := json.Unmarshal([]byte(input), &got); err != nil || !reflect.DeepEqual(got, l) {
+			t.Errorf("%s: decoded %v, %v", input, got, err)
+		}
+		var gotCompact tdigest.CompactCentroidList
+		if err := json.Unmarshal([]byte(input), &gotCompact); err != nil || !reflect.DeepEqual(tdigest.CentroidList(gotCompact), l) {
+			t.Errorf("%s: decoded %v, %v as compact", input, gotCompact, err)
+		}
+	}
+
+	invalid := []struct {
+		input string
+		want  error
+	}{
+		{`[[1, 1], [0, 1]]`, tdigest.ErrUnsortedCentroids},
+		{`[{"mean": 1}]`, tdigest.ErrInvalidCentroid},
+		{`[[1, -1]]`, tdigest.ErrInvalidCentroid},
+		{`[[1, 1, 1]]`, tdigest.ErrInvalidJSON},
+		{`[[1]]`, tdigest.ErrInvalidJSON},
+	}
+	for _, tt := range invalid {
+		var got tdigest.CentroidList
+		if err := json.Unmarshal([]byte(tt.input), &got); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.input, err, tt.want)
+		}
+	}
+	var got tdigest.CentroidList
+	if err := json.Unmarshal([]byte(`[["1", 1]]`), &got); err == nil {
+		t.Error("string mean accepted")
+	}
+}
+
+func TestDecodeJSONStream_Compact(t *testing.T) {
+	input := `{"compression": 100, "centroids": [[1, 2], {"mean": 3, "weight": 1}]}`
+	td, err := tdigest.DecodeJSONStream(json.NewDecoder(strings.NewReader(input)))
+	if err != nil || td.Count() != 3 || td.Min() != 1 || td.Max() != 3 {
+		t.Errorf("decoded %v, %v", td, err)
+	}
+}
+
+// centroidStream generates the JSON of a digest with n centroids as it is
+// read, so that the payload is never held in memory. Every chunk bytes, it
+// collects garbage and records the largest live heap seen.
+type centroidStream struct {
+	n, i     int
+	buf      bytes.Buffer
+	read     int
+	chunk    int
+	peakHeap uint64
+}
+
+func (s *centroidStream) Read(p []byte) (int, error) {
+	for s.buf.Len() < len(p) && s.i <= s.n {
+		switch {
+		case s.i == 0:
+			s.buf.WriteString(`{"compression": 100, "centroids": [`)
+		case s.i < s.n:
+			fmt.Fprintf(&s.buf, `{"mean": %d, "weight": 1.5},`, s.i)
+		default:
+			fmt.Fprintf(&s.buf, `{"mean": %d, "weight": 1.5}]}`, s.i)
+		}
+		s.i++
+	}
+	if s.read/s.chunk != (s.read+len(p))/s.chunk {
+		runtime.GC()
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		if m.HeapAlloc > s.peakHeap {
+			s.peakHeap = m.HeapAlloc
+		}
+	}
+	n, err := s.buf.Read(p)
+	s.read += n
+	return n, err
+}
+
+func TestDecodeJSONStream_Memory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("decodes a large payload")
+	}
+	const n = 1000000
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	s := &centroidStream{n: n, chunk: 1 << 20}
+	td, err := tdigest.DecodeJSONStream(json.NewDecoder(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if td.Count() != 1.5*n {
+		t.Errorf("count %v, want %v", td.Count(), 1.5*n)
+	}
+	// The payload is about 30MB and the centroids alone would take 16MB,
+	// while the digest and the decoder need well under 2MB.
+	if s.read < 25<<20 {
+		t.Errorf("payload of only %d bytes", s.read)
+	}
+	if growth := int64(s.peakHeap) - int64(before.HeapAlloc); growth > 4<<20 {
+		t.Errorf("live heap grew by %d bytes", growth)
+	}
+}
@@ -0,0 +1,135 @@
+package tdigest
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// Merge adds all of the data of o to t. It does not modify o. Centroids of o
+// with a NaN or infinite mean, or a weight that is not positive and finite,
+// are dropped and counted in Dropped as by AddCentroid.
+func (t *TDigest) Merge(o *TDigest) {
+	_ = t.MergeE(o)
+}
+
+// merge implements Merge.
+func (t *TDigest) merge(o *TDigest) {
+	processed, unprocessed := o.processed, o.unprocessed
+	processedRanges, unprocessedRanges := o.processedRanges, o.unprocessedRanges
+	// The centroid weights of o may be rounded sums of its counts, so they
+	// are not counted and its exact count is taken instead.
+	t.inexact = t.inexact || o.inexact
+	t.countN(o.exactCount)
+	if o == t {
+		processed, unprocessed = processed.Clone(), unprocessed.Clone()
+		processedRanges = append([]CentroidRange(nil), processedRanges...)
+		unprocessedRanges = append([]CentroidRange(nil), unprocessedRanges...)
+	}
+	if o.min <= o.max {
+		for _, x := range [...]float64{o.min, o.max} {
+			if x, ok := t.admit(x); ok {
+				t.updateBounds(x)
+			}
+		}
+	}
+	if t.ranges {
+		// Without ranges from o, each centroid could hold any of its values.
+		whole := CentroidRange{o.min, o.max}
+		if !o.ranges {
+			processedRanges, unprocessedRanges = nil, nil
+		}
+		t.addCentroidListRanges(processed, processedRanges, whole, false)
+		t.addCentroidListRanges(unprocessed, unprocessedRanges, whole, false)
+		return
+	}
+	t.addCentroidList(processed, false)
+	t.addCentroidList(unprocessed, false)
+}
+
+// MergeAll returns a new digest containing the data of all of the digests,
+// merged one after another. It has the compression of the first digest. The
+// digests are not modified.
+func MergeAll(digests ...*TDigest) *TDigest {
+	if len(digests) == 0 {
+		return New()
+	}
+	t := NewWithCompression(digests[0].Compression())
+	for _, d := range digests {
+		t.Merge(d)
+	}
+	return t
+}
+
+// MergeParallel is like MergeAll but merges the digests pairwise in a binary
+// tree, using up to parallelism goroutines. A parallelism of zero or less
+// uses GOMAXPROCS. The result is equivalent to that of MergeAll within the
+// accuracy of the digest, but not identical, since data is compressed in a
+// different order.
+//
+// If ctx is done before the merge completes, including before it starts,
+// MergeParallel returns nil and the error of ctx.
+func MergeParallel(ctx context.Context, parallelism int, digests ...*TDigest) (*TDigest, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(digests) <= 1 {
+		return MergeAll(digests...), nil
+	}
+	if parallelism <= 0 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+	compression := digests[0].Compression()
+
+	type pair struct {
+		i    int
+		a, b *TDigest
+	}
+	level := digests
+	owned := false // whether the digests of level were created here
+	for len(level) > 1 {
+		next := make([]*TDigest, (len(level)+1)/2)
+		pairs := make(chan pair)
+		var wg sync.WaitGroup
+		for w := 0; w < parallelism && w < len(next); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for p := range pairs {
+					t := p.a
+					if !owned {
+						t = NewWithCompression(compression)
+						t.Merge(p.a)
+					}
+					if p.b != nil {
+						t.Merge(p.b)
+					}
+					t.Flush()
+					next[p.i] = t
+				}
+			}()
+		}
+
+		var err error
+	send:
+		for i := range next {
+			p := pair{i: i, a: level[2*i]}
+			if 2*i+1 < len(level) {
+				p.b = level[2*i+1]
+			}
+			select {
+			case pairs <- p:
+			case <-ctx.Done():
+				err = ctx.Err()
+				break send
+			}
+		}
+		close(pairs)
+		wg.Wait()
+		if err != nil {
+			return nil, err
+		}
+		level, owned = next, true
+	}
+	return level[0], nil
+}
@@ -0,0 +1,152 @@
+package tdigest_test
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+// splitDigests returns n digests over consecutive parts of data.
+func splitDigests(data []float64, n int, compression float64) []*tdigest.TDigest {
+	digests := make([]*tdigest.TDigest, n)
+	size := len(data) / n
+	for i := range digests {
+		digests[i] = tdigest.NewWithCompression(compression)
+		digests[i].AddValues(data[i*size : (i+1)*size])
+	}
+	return digests
+}
+
+func TestTdigest_Merge(t *testing.T) {
+	digests := splitDigests(NormalData, 100, 100)
+	stats := make([]tdigest.Stats, len(digests))
+	for i, d := range digests {
+		stats[i] = d.Stats()
+	}
+
+	td := tdigest.NewWithCompression(100)
+	for _, d := range digests {
+		td.Merge(d)
+	}
+	for i, d := range digests {
+		if d.Stats() != stats[i] {
+			t.Fatalf("merge modified digest %d", i)
+		}
+	}
+	if got, want := td.Count(), float64(len(NormalData)); got != want {
+		t.Errorf("unexpected count, got %g want %g", got, want)
+	}
+
+	sorted := append([]float64(nil), NormalData...)
+	sort.Float64s(sorted)
+	for _, q := range []float64{0.01, 0.5, 0.99} {
+		want := sorted[int(q*float64(len(sorted)-1))]
+		if got := td.Quantile(q); math.Abs(got-want) > 0.02 {
+			t.Errorf("unexpected quantile %g, got %g want %g", q, got, want)
+		}
+	}
+
+	// Merging a digest into itself doubles it.
+	want := td.Quantile(0.9)
+	td.Merge(td)
+	if got := td.Count(); got != 2*float64(len(NormalData)) {
+		t.Errorf("unexpected count after merging with itself: %g", got)
+	}
+	if got := td.Quantile(0.9); math.Abs(got-want) > 0.01 {
+		t.Errorf("unexpected quantile after merging with itself, got %g want %g", got, want)
+	}
+}
+
+func TestTdigest_MergePoisoned(t *testing.T) {
+	src := tdigest.NewWithCompression(100)
+	src.AddValues(NormalData[:10000])
+	// Corrupt the source through its read-only view, as a digest decoded
+	// from a damaged upstream might be.
+	cl := src.Centroids()
+	var poisoned float64
+	for _, i := range []int{3, 10, 20, 30, 40} {
+		poisoned += cl[i].Weight
+	}
+	cl[3].Mean = math.NaN()
+	cl[10].Mean = math.Inf(1)
+	cl[20].Mean = math.Inf(-1)
+	cl[30].Weight = math.NaN()
+	cl[40].Weight = -1
+
+	td := tdigest.NewWithCompression(100)
+	td.Merge(src)
+	td.AddCentroidList(tdigest.CentroidList{{Mean: math.NaN(), Weight: 1}, {Mean: 5, Weight: 1}})
+	if got, want := td.Dropped(), uint64(6); got != want {
+		t.Errorf("unexpected dropped count, got %d want %d", got, want)
+	}
+	if got, want := td.Count(), src.Count()-poisoned+1; got != want {
+		t.Errorf("unexpected count, got %g want %g", got, want)
+	}
+	td.Flush()
+	if err := td.CheckInvariants(); err != nil {
+		t.Errorf("merged digest is inconsistent: %v", err)
+	}
+	prev := math.Inf(-1)
+	for i := 0; i <= 100; i++ {
+		q := float64(i) / 100
+		got := td.Quantile(q)
+		if math.IsNaN(got) || math.IsInf(got, 0) || got < prev {
+			t.Fatalf("unexpected Quantile(%g) = %g after %g", q, got, prev)
+		}
+		prev = got
+	}
+}
+
+func TestMergeParallel(t *testing.T) {
+	digests := splitDigests(NormalData, 37, 100)
+	want := tdigest.MergeAll(digests...)
+	for _, parallelism := range []int{0, 1, 4} {
+		got, err := tdigest.MergeParallel(context.Background(), parallelism, digests...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Count() != want.Count() {
+			t.Errorf("unexpected count, got %g want %g", got.Count(), want.Count())
+		}
+		for _, q := range []float64{0.01, 0.1, 0.5, 0.9, 0.99} {
+			if got, want := got.Quantile(q), want.Quantile(q); math.Abs(got-want) > 0.05 {
+				t.Errorf("parallelism %d: unexpected quantile %g, got %g want %g", parallelism, q, got, want)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, n := range []int{0, 1, len(digests)} {
+		if got, err := tdigest.MergeParallel(ctx, 2, digests[:n]...); got != nil || err != context.Canceled {
+			t.Errorf("merging %d digests with a cancelled context: got %v, %v", n, got, err)
+		}
+	}
+}
+
+func BenchmarkMergeParallel(b *testing.B) {
+	data := make([]float64, 1000*1000)
+	for i := range data {
+		data[i] = NormalData[i%len(NormalData)] + float64(i/len(NormalData))
+	}
+	digests := splitDigests(data, 1000, 500)
+	b.ResetTimer()
+	b.Run("MergeAll", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			tdigest.MergeAll(digests...).Flush()
+		}
+	})
+	for _, parallelism := range []int{1, 2, 4, 8} {
+		b.Run("parallelism="+strconv.Itoa(parallelism), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				if _, err := tdigest.MergeParallel(context.Background(), parallelism, digests...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
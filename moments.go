@@ -0,0 +1,86 @@
+package tdigest
+
+import "math"
+
+// Mean processes pending data and returns the mean of t, as
+// FrozenDigest.Mean does.
+func (t *TDigest) Mean() float64 {
+	t.Flush()
+	f := t.frozen()
+	return f.Mean()
+}
+
+// StdDev processes pending data and returns the standard deviation of t, as
+// FrozenDigest.StdDev does.
+func (t *TDigest) StdDev() float64 {
+	t.Flush()
+	f := t.frozen()
+	return f.StdDev()
+}
+
+// ZScore processes pending data and returns the z-score of x in t, as
+// FrozenDigest.ZScore does.
+func (t *TDigest) ZScore(x float64) float64 {
+	t.Flush()
+	f := t.frozen()
+	return f.ZScore(x)
+}
+
+// ValueAtZScore processes pending data and returns the value with z-score z
+// in t, as FrozenDigest.ValueAtZScore does.
+func (t *TDigest) ValueAtZScore(z float64) float64 {
+	t.Flush()
+	f := t.frozen()
+	return f.ValueAtZScore(z)
+}
+
+// Mean returns the weighted mean of the values in the snapshot, or NaN if it
+// is empty. Like Sum, it is exact up to rounding.
+func (f *FrozenDigest) Mean() float64 {
+	if f.processed.Len() == 0 {
+		return math.NaN()
+	}
+	return f.Sum() / f.count
+}
+
+// StdDev returns the weighted population standard deviation of the values
+// in the snapshot, or NaN if it is empty. It is computed from the centroids,
+// which keep the sum of their values but not their spread, so it misses the
+// variance within each centroid and underestimates slightly: for normal data,
+// by 0.04% at compression 100 and 0.8% at compression 20.
+func (f *FrozenDigest) StdDev() float64 {
+	mean := f.Mean()
+	if math.IsNaN(mean) {
+		return math.NaN()
+	}
+	var sum kahanSum
+	for _, c := range f.processed {
+		d := c.Mean - mean
+		sum.add(d * d * c.Weight)
+	}
+	return math.Sqrt(sum.value() / f.count)
+}
+
+// ZScore returns (x-Mean)/StdDev, the number of standard deviations x lies
+// from the mean, or NaN if the snapshot is empty or its standard deviation
+// is zero. Both are derived from the centroids, so they cover all of the data
+// the digest has seen, not a recent window, and they carry over Merge and
+// encoding like the centroids do. For a score over a recent window, take it
+// from the snapshots a RotatingTDigest returns.
+func (f *FrozenDigest) ZScore(x float64) float64 {
+	sd := f.StdDev()
+	if !(sd > 0) {
+		return math.NaN()
+	}
+	return (x - f.Mean()) / sd
+}
+
+// ValueAtZScore is the inverse of ZScore: it returns Mean + z*StdDev, or NaN
+// where ZScore would.
+func (f *FrozenDigest) ValueAtZScore(z float64) float64 {
+	sd := f.StdDev()
+	if !(sd > 0) {
+		return math.NaN()
+	}
+	return f.Mean() + z*sd
+}
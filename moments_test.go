@@ -0,0 +1,83 @@
+package tdigest_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestTdigest_Moments(t *testing.T) {
+	var sum, squares float64
+	for _, x := range NormalData {
+		sum += x
+	}
+	mean := sum / float64(len(NormalData))
+	for _, x := range NormalData {
+		squares += (x - mean) * (x - mean)
+	}
+	sd := math.Sqrt(squares / float64(len(NormalData)))
+
+	td := tdigest.NewWithCompression(100)
+	for _, x := range NormalData {
+		td.Add(x, 1)
+	}
+	if got := td.Mean(); math.Abs(got-mean) > 1e-9*math.Abs(mean) {
+		t.Errorf("Mean() = %g, want %g", got, mean)
+	}
+	if got := td.StdDev(); got > sd || got < sd*0.999 {
+		t.Errorf("StdDev() = %g, want just under %g", got, sd)
+	}
+	for _, z := range []float64{-4, -1, 0, 0.5, 2, 4} {
+		x := td.ValueAtZScore(z)
+		if got := td.ZScore(x); math.Abs(got-z) > 1e-12 {
+			t.Errorf("ZScore(ValueAtZScore(%g)) = %g", z, got)
+		}
+		if want := mean + z*sd; math.Abs(x-want) > 0.001*sd*math.Max(1, math.Abs(z)) {
+			t.Errorf("ValueAtZScore(%g) = %g, want about %g", z, x, want)
+		}
+	}
+
+	// Halves merged give the moments of the whole, and encoding keeps them
+	// exactly.
+	a, b := tdigest.NewWithCompression(100), tdigest.NewWithCompression(100)
+	for i, x := range NormalData {
+		if i%2 == 0 {
+			a.Add(x, 1)
+		} else {
+			b.Add(x, 1)
+		}
+	}
+	a.Merge(b)
+	if math.Abs(a.Mean()-td.Mean()) > 1e-9*math.Abs(mean) || math.Abs(a.StdDev()-td.StdDev()) > 0.001*sd {
+		t.Errorf("merged halves have mean %g and standard deviation %g, want %g and %g", a.Mean(), a.StdDev(), td.Mean(), td.StdDev())
+	}
+	decoded, err := tdigest.FromBytes(mustMarshal(t, td))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Mean() != td.Mean() || decoded.StdDev() != td.StdDev() || decoded.ZScore(20) != td.ZScore(20) {
+		t.Errorf("decoded digest has mean %g and standard deviation %g, want %g and %g", decoded.Mean(), decoded.StdDev(), td.Mean(), td.StdDev())
+	}
+}
+
+func TestTdigest_ZScoreUndefined(t *testing.T) {
+	empty := tdigest.New()
+	constant := tdigest.New()
+	constant.Add(5, 1)
+	constant.Add(5, 3)
+	for name, td := range map[string]*tdigest.TDigest{"empty": empty, "constant": constant} {
+		if got := td.ZScore(5); !math.IsNaN(got) {
+			t.Errorf("%s: ZScore(5) = %g, want NaN", name, got)
+		}
+		if got := td.ValueAtZScore(1); !math.IsNaN(got) {
+			t.Errorf("%s: ValueAtZScore(1) = %g, want NaN", name, got)
+		}
+	}
+	if !math.IsNaN(empty.Mean()) || !math.IsNaN(empty.StdDev()) {
+		t.Errorf("empty digest has mean %g and standard deviation %g, want NaN", empty.Mean(), empty.StdDev())
+	}
+	if constant.Mean() != 5 || constant.StdDev() != 0 {
+		t.Errorf("constant digest has mean %g and standard deviation %g, want 5 and 0", constant.Mean(), constant.StdDev())
+	}
+}
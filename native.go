@@ -0,0 +1,99 @@
+package tdigest
+
+// The limits of the schema of a Prometheus native histogram.
+const (
+	MinNativeSchema = -4
+	MaxNativeSchema = 8
+)
+
+// NativeHistogram holds the data of a Prometheus native histogram in its
+// sparse encoding. The bucket of index i covers the magnitudes
+// (base^(i-1), base^i] where base = 2^(2^-Schema), and values whose magnitude
+// is at most ZeroThreshold are counted in the zero bucket.
+type NativeHistogram struct {
+	Schema        int32
+	ZeroThreshold float64
+	ZeroCount     uint64
+	Count         uint64
+	Sum           float64
+	// The spans of populated buckets and their counts, each given as the
+	// difference from the count of the bucket before.
+	PositiveSpans  []BucketSpan
+	PositiveDeltas []int64
+	NegativeSpans  []BucketSpan
+	NegativeDeltas []int64
+}
+
+// BucketSpan is a run of Length consecutive buckets. The Offset of the first
+// span is the index of its first bucket; that of each later span is the
+// number of empty buckets between it and the span before.
+type BucketSpan struct {
+	Offset int32
+	Length uint32
+}
+
+// ToNativeHistogram processes pending data and returns t as a native
+// histogram, as FrozenDigest.ToNativeHistogram does.
+func (t *TDigest) ToNativeHistogram(schema int32, zeroThreshold float64) NativeHistogram {
+	t.Flush()
+	f := t.frozen()
+	return f.ToNativeHistogram(schema, zeroThreshold)
+}
+
+// ToNativeHistogram returns the snapshot as a native histogram of the given
+// schema, clamped into [MinNativeSchema, MaxNativeSchema] and lowered as far
+// as needed for each side of zero to fit in MaxExponentialBuckets buckets.
+// Centroids whose means are within zeroThreshold of zero make up the zero
+// bucket; the bucket counts are estimated as by ToExponentialHistogram.
+func (f *FrozenDigest) ToNativeHistogram(schema int32, zeroThreshold float64) NativeHistogram {
+	if !(zeroThreshold > 0) {
+		zeroThreshold = 0
+	}
+	e := f.exponentialHistogram(schema, MinNativeSchema, MaxNativeSchema, zeroThreshold)
+	h := NativeHistogram{
+		Schema:        e.Scale,
+		ZeroThreshold: zeroThreshold,
+		ZeroCount:     e.ZeroCount,
+		Count:         e.Count,
+		Sum:           e.Sum,
+	}
+	// Native histograms number their buckets by the upper bound rather
+	// than the lower one.
+	h.PositiveSpans, h.PositiveDeltas = nativeBuckets(e.Positive.Offset+1, e.Positive.BucketCounts)
+	h.NegativeSpans, h.NegativeDeltas = nativeBuckets(e.Negative.Offset+1, e.Negative.BucketCounts)
+	return h
+}
+
+// nativeBuckets returns the sparse encoding of counts, the first of which is
+// that of the bucket of index offset. Runs of up to two empty buckets are
+// kept inside a span, as the Prometheus client does, since a new span costs
+// more than the deltas.
+func nativeBuckets(offset int32, counts []uint64) ([]BucketSpan, []int64) {
+	var spans []BucketSpan
+	var deltas []int64
+	var prev int64
+	next := offset // The index after the last bucket encoded.
+	for i, c := range counts {
+		if c == 0 {
+			continue
+		}
+		index := offset + int32(i)
+		switch gap := index - next; {
+		case spans == nil:
+			spans = append(spans, BucketSpan{Offset: index})
+		case gap > 2:
+			spans = append(spans, BucketSpan{Offset: gap})
+		default:
+			for ; gap > 0; gap-- {
+				deltas = append(deltas, -prev)
+				prev = 0
+				spans[len(spans)-1].Length++
+			}
+		}
+		deltas = append(deltas, int64(c)-prev)
+		prev = int64(c)
+		spans[len(spans)-1].Length++
+		next = index + 1
+	}
+	return spans, deltas
+}
@@ -0,0 +1,134 @@
+package tdigest_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+// nativeBucket is a decoded bucket of a native histogram.
+type nativeBucket struct {
+	lo, hi float64
+	count  float64
+}
+
+// decodeNative returns the buckets of h in increasing order of their values.
+func decodeNative(t *testing.T, h tdigest.NativeHistogram) []nativeBucket {
+	base := math.Exp2(math.Exp2(-float64(h.Schema)))
+	decode := func(spans []tdigest.BucketSpan, deltas []int64, sign float64) []nativeBucket {
+		var buckets []nativeBucket
+		var index int32
+		var count int64
+		k := 0
+		for i, s := range spans {
+			if i == 0 {
+				index = s.Offset
+			} else {
+				index += s.Offset
+			}
+			for j := uint32(0); j < s.Length; j++ {
+				count += deltas[k]
+				k++
+				if count < 0 {
+					t.Fatalf("negative count in bucket %d", index)
+				}
+				lo, hi := math.Pow(base, float64(index-1)), math.Pow(base, float64(index))
+				if sign < 0 {
+					lo, hi = -hi, -lo
+				}
+				buckets = append(buckets, nativeBucket{lo, hi, float64(count)})
+				index++
+			}
+		}
+		if k != len(deltas) {
+			t.Fatalf("spans cover %d buckets, have %d deltas", k, len(deltas))
+		}
+		return buckets
+	}
+	neg := decode(h.NegativeSpans, h.NegativeDeltas, -1)
+	for i, j := 0, len(neg)-1; i < j; i, j = i+1, j-1 {
+		neg[i], neg[j] = neg[j], neg[i]
+	}
+	buckets := append(neg, nativeBucket{-h.ZeroThreshold, h.ZeroThreshold, float64(h.ZeroCount)})
+	return append(buckets, decode(h.PositiveSpans, h.PositiveDeltas, 1)...)
+}
+
+// nativeQuantile estimates the q quantile of buckets as histogram_quantile
+// does, interpolating linearly within the bucket holding the rank.
+func nativeQuantile(buckets []nativeBucket, count, q float64) float64 {
+	rank := q * count
+	for _, b := range buckets {
+		if rank <= b.count && b.count > 0 {
+			return b.lo + (b.hi-b.lo)*rank/b.count
+		}
+		rank -= b.count
+	}
+	return math.NaN()
+}
+
+func TestTdigest_ToNativeHistogram(t *testing.T) {
+	td := tdigest.NewWithCompression(1000)
+	for _, x := range NormalData[:100000] {
+		td.Add(x-Mu, 1)
+	}
+	const schema, zeroThreshold = 3, 0.01
+	h := td.ToNativeHistogram(schema, zeroThreshold)
+	if h.Schema != schema || h.ZeroThreshold != zeroThreshold || h.Count != 100000 {
+		t.Fatalf("unexpected histogram %+v", h)
+	}
+	buckets := decodeNative(t, h)
+	var total float64
+	for _, b := range buckets {
+		total += b.count
+	}
+	if total != float64(h.Count) {
+		t.Errorf("buckets hold %v values, count is %d", total, h.Count)
+	}
+	if h.ZeroCount == 0 || h.ZeroCount > 1000 {
+		t.Errorf("unexpected zero count %d", h.ZeroCount)
+	}
+	if math.Abs(h.Sum-td.Snapshot().Sum()) > 1e-9 {
+		t.Errorf("unexpected sum %v", h.Sum)
+	}
+
+	width := math.Exp2(math.Exp2(-schema)) - 1
+	for q := 0.01; q < 1; q += 0.01 {
+		want, got := td.Quantile(q), nativeQuantile(buckets, total, q)
+		if math.Abs(got-want) > 2*width*math.Abs(want)+zeroThreshold {
+			t.Errorf("q=%v: got %v, want %v", q, got, want)
+		}
+	}
+}
+
+func TestTdigest_ToNativeHistogramSchema(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	td.AddValues([]float64{-1e10, 1e-10, 1, 1e10})
+	h := td.ToNativeHistogram(tdigest.MaxNativeSchema+10, 0)
+	if h.Schema != 1 {
+		t.Errorf("unexpected schema %d", h.Schema)
+	}
+	for _, b := range decodeNative(t, h) {
+		if b.lo > b.hi {
+			t.Errorf("unexpected bucket %+v", b)
+		}
+	}
+	if h := td.ToNativeHistogram(tdigest.MinNativeSchema-10, 0); h.Schema != tdigest.MinNativeSchema {
+		t.Errorf("unexpected schema %d", h.Schema)
+	}
+
+	empty := tdigest.NewWithCompression(100).ToNativeHistogram(0, 0)
+	if empty.Count != 0 || empty.PositiveSpans != nil || empty.NegativeSpans != nil {
+		t.Errorf("unexpected empty histogram %+v", empty)
+	}
+}
+
+func TestNativeBuckets(t *testing.T) {
+	spans, deltas := tdigest.NativeBuckets(5, []uint64{0, 1, 0, 0, 2, 0, 0, 0, 3, 0})
+	wantSpans := []tdigest.BucketSpan{{Offset: 6, Length: 4}, {Offset: 3, Length: 1}}
+	wantDeltas := []int64{1, -1, 0, 2, 1}
+	if !reflect.DeepEqual(spans, wantSpans) || !reflect.DeepEqual(deltas, wantDeltas) {
+		t.Errorf("got %v %v, want %v %v", spans, deltas, wantSpans, wantDeltas)
+	}
+}
@@ -0,0 +1,58 @@
+package tdigest
+
+import (
+	"fmt"
+	"math"
+)
+
+// ErrDegenerateRange is returned by Normalized for a digest whose values
+// span no range, or one too wide to divide by.
+const ErrDegenerateRange = Error("digest range must be positive and finite")
+
+// Normalized processes pending data and returns a new digest of the values
+// of t rescaled to [0, 1], each x mapped to (x-min)/(max-min), for comparing
+// the shapes of distributions measured in different units. The centroids
+// keep their weights and order, so that Quantile(q) of the result is
+// (Quantile(q)-min)/(max-min) of t, up to rounding. The result has the
+// compression, scale function and centroid ranges of t, and t is not
+// otherwise modified.
+//
+// It returns ErrEmptyDigest for an empty digest, and an error wrapping
+// ErrDegenerateRange if max equals min or max-min overflows.
+func (t *TDigest) Normalized() (*TDigest, error) {
+	if t.Count() == 0 {
+		return nil, ErrEmptyDigest
+	}
+	span := t.max - t.min
+	if !(span > 0 && span <= math.MaxFloat64) {
+		return nil, fmt.Errorf("normalizing [%v, %v]: %w", t.min, t.max, ErrDegenerateRange)
+	}
+	return t.affine(t.min, span), nil
+}
+
+// affine processes pending data and returns a new digest of the values of t
+// mapped through x -> (x-offset)/scale, for a positive scale. Dividing rather
+// than multiplying by the reciprocal maps offset+scale to 1 exactly. Both
+// steps round monotonically, so the centroids stay sorted. The compressed
+// state is copied rather than added, so that the result is not compressed
+// again.
+func (t *TDigest) affine(offset, scale float64) *TDigest {
+	t.Flush()
+	opts := []Option{WithScaleFunction(t.scale)}
+	if t.ranges {
+		opts = append(opts, WithCentroidRanges())
+	}
+	r := NewWithCompression(t.compression, opts...)
+	f := func(x float64) float64 { return (x - offset) / scale }
+	for _, c := range t.processed {
+		r.processed = append(r.processed, Centroid{Mean: f(c.Mean), Weight: c.Weight})
+	}
+	for _, cr := range t.processedRanges {
+		r.processedRanges = append(r.processedRanges, CentroidRange{f(cr.Min), f(cr.Max)})
+	}
+	r.cumulative = append(r.cumulative, t.cumulative...)
+	r.processedWeight = t.processedWeight
+	r.min, r.max = f(t.min), f(t.max)
+	r.exactCount, r.inexact = t.exactCount, t.inexact
+	return r
+}
@@ -0,0 +1,64 @@
+package tdigest_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestTdigest_Normalized(t *testing.T) {
+	for _, opts := range [][]tdigest.Option{nil, {tdigest.WithCentroidRanges()}} {
+		td := tdigest.NewWithCompression(100, opts...)
+		for _, x := range NormalData {
+			td.Add(x*1000+50, 1)
+		}
+		min, max := td.Min(), td.Max()
+		n, err := td.Normalized()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.Min() != 0 || n.Max() != 1 || n.Count() != td.Count() || n.Compression() != td.Compression() {
+			t.Fatalf("normalized digest has min %g, max %g, count %g and compression %g", n.Min(), n.Max(), n.Count(), n.Compression())
+		}
+		if got, want := len(n.Centroids()), len(td.Centroids()); got != want {
+			t.Errorf("normalized digest has %d centroids, want %d", got, want)
+		}
+		for q := 0.0; q <= 1; q += 0.0005 {
+			want := (td.Quantile(q) - min) / (max - min)
+			if got := n.Quantile(q); math.Abs(got-want) > 1e-12 {
+				t.Errorf("Quantile(%g) = %g, want %g", q, got, want)
+			}
+		}
+		// The result is an ordinary digest that takes more data.
+		for i := 0; i < 10000; i++ {
+			n.Add(float64(i)/10000, 1)
+		}
+		if err := n.CheckInvariants(); err != nil {
+			t.Error(err)
+		}
+		// The original is unchanged.
+		if td.Min() != min || td.Max() != max {
+			t.Errorf("original digest now spans [%g, %g], want [%g, %g]", td.Min(), td.Max(), min, max)
+		}
+	}
+}
+
+func TestTdigest_NormalizedErrors(t *testing.T) {
+	if _, err := tdigest.New().Normalized(); !errors.Is(err, tdigest.ErrEmptyDigest) {
+		t.Errorf("empty digest: got %v", err)
+	}
+	constant := tdigest.New()
+	constant.Add(3, 1)
+	constant.Add(3, 2)
+	if _, err := constant.Normalized(); !errors.Is(err, tdigest.ErrDegenerateRange) {
+		t.Errorf("constant digest: got %v", err)
+	}
+	wide := tdigest.New()
+	wide.Add(-math.MaxFloat64, 1)
+	wide.Add(math.MaxFloat64, 1)
+	if _, err := wide.Normalized(); !errors.Is(err, tdigest.ErrDegenerateRange) {
+		t.Errorf("digest spanning every float: got %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package tdigest
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteOpenMetrics processes pending data and writes the digest to w as an
+// OpenMetrics summary as FrozenDigest.WriteOpenMetrics does.
+func (t *TDigest) WriteOpenMetrics(w io.Writer, name string, labels map[string]string, qs []float64) error {
+	t.Flush()
+	f := t.frozen()
+	return f.WriteOpenMetrics(w, name, labels, qs)
+}
+
+// WriteOpenMetricsAt is like WriteOpenMetrics but gives every sample the
+// timestamp ts.
+func (t *TDigest) WriteOpenMetricsAt(w io.Writer, name string, labels map[string]string, qs []float64, ts time.Time) error {
+	t.Flush()
+	f := t.frozen()
+	return f.WriteOpenMetricsAt(w, name, labels, qs, ts)
+}
+
+// WriteOpenMetrics writes the snapshot to w as a metric family of type
+// summary in the OpenMetrics text format: a sample of the family name for
+// each quantile of qs, followed by name_sum and name_count. Every sample has
+// labels, sorted by name, and the quantile samples add a quantile label.
+//
+// It writes the single family only. An exposition must end with a line
+// "# EOF" once all of its families are written.
+//
+// It returns an error without writing anything if name or a label name is not
+// valid, if labels has a quantile label, or if a quantile of qs is not in
+// [0, 1].
+func (f *FrozenDigest) WriteOpenMetrics(w io.Writer, name string, labels map[string]string, qs []float64) error {
+	return f.writeOpenMetrics(w, name, labels, qs, "")
+}
+
+// WriteOpenMetricsAt is like WriteOpenMetrics but gives every sample the
+// timestamp ts, to the millisecond.
+func (f *FrozenDigest) WriteOpenMetricsAt(w io.Writer, name string, labels map[string]string, qs []float64, ts time.Time) error {
+	ms := ts.UnixNano() / int64(time.Millisecond)
+	return f.writeOpenMetrics(w, name, labels, qs, " "+openMetricsFloat(float64(ms)/1000))
+}
+
+func (f *FrozenDigest) writeOpenMetrics(w io.Writer, name string, labels map[string]string, qs []float64, ts string) error {
+	if !validMetricName(name) {
+		return fmt.Errorf("tdigest: invalid metric name %q", name)
+	}
+	names := make([]string, 0, len(labels))
+	for l := range labels {
+		if !validLabelName(l) || l == "quantile" {
+			return fmt.Errorf("tdigest: invalid label name %q", l)
+		}
+		names = append(names, l)
+	}
+	sort.Strings(names)
+	for _, q := range qs {
+		if !(q >= 0 && q <= 1) {
+			return fmt.Errorf("tdigest: invalid quantile %v", q)
+		}
+	}
+
+	var b strings.Builder
+	for _, l := range names {
+		b.WriteString(",")
+		b.WriteString(l)
+		b.WriteString(`="`)
+		b.WriteString(openMetricsEscaper.Replace(labels[l]))
+		b.WriteString(`"`)
+	}
+	pairs := b.String()
+	b.Reset()
+
+	sample := func(suffix, extra, value string) {
+		b.WriteString(name)
+		b.WriteString(suffix)
+		if l := strings.TrimPrefix(pairs+extra, ","); l != "" {
+			b.WriteString("{" + l + "}")
+		}
+		b.WriteString(" " + value + ts + "\n")
+	}
+	b.WriteString("# TYPE " + name + " summary\n")
+	for _, q := range qs {
+		sample("", `,quantile="`+openMetricsFloat(q)+`"`, openMetricsFloat(f.Quantile(q)))
+	}
+	sample("_sum", "", openMetricsFloat(f.Sum()))
+	count := openMetricsFloat(f.count)
+	if f.count == math.Trunc(f.count) && f.count < 1<<63 {
+		count = strconv.FormatInt(int64(f.count), 10)
+	}
+	sample("_count", "", count)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var openMetricsEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// openMetricsFloat formats v as OpenMetrics requires, with a decimal point
+// or exponent in every finite value.
+func openMetricsFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(s, "e.") {
+		s += ".0"
+	}
+	return s
+}
+
+func validMetricName(s string) bool {
+	for i, c := range s {
+		if !(c == '_' || c == ':' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func validLabelName(s string) bool {
+	return validMetricName(s) && !strings.Contains(s, ":")
+}
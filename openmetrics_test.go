@@ -0,0 +1,70 @@
+package tdigest_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestFrozenDigest_WriteOpenMetrics(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	td.AddValues([]float64{1, 2, 3, 4})
+	var buf bytes.Buffer
+	labels := map[string]string{"path": `/a"b\c` + "\n", "method": "GET"}
+	if err := td.Snapshot().WriteOpenMetrics(&buf, "latency_seconds", labels, []float64{0.5, 1}); err != nil {
+		t.Fatal(err)
+	}
+	// promtdigest checks the output against the Prometheus encoder.
+	want := `# TYPE latency_seconds summary
+latency_seconds{method="GET",path="/a\"b\\c\n",quantile="0.5"} 2.5
+latency_seconds{method="GET",path="/a\"b\\c\n",quantile="1.0"} 4.0
+latency_seconds_sum{method="GET",path="/a\"b\\c\n"} 10.0
+latency_seconds_count{method="GET",path="/a\"b\\c\n"} 4
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestTdigest_WriteOpenMetricsEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := tdigest.NewWithCompression(100).WriteOpenMetrics(&buf, "empty", nil, []float64{0.5}); err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE empty summary
+empty{quantile="0.5"} NaN
+empty_sum 0.0
+empty_count 0
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestTdigest_WriteOpenMetricsInvalid(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	tests := []struct {
+		name   string
+		labels map[string]string
+		qs     []float64
+	}{
+		{"", nil, nil},
+		{"1abc", nil, nil},
+		{"a-b", nil, nil},
+		{"ok", map[string]string{"a:b": "x"}, nil},
+		{"ok", map[string]string{"quantile": "x"}, nil},
+		{"ok", nil, []float64{1.5}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		err := td.WriteOpenMetrics(&buf, tt.name, tt.labels, tt.qs)
+		if err == nil || buf.Len() != 0 {
+			t.Errorf("%q %v %v: got error %v and output %q", tt.name, tt.labels, tt.qs, err, buf.String())
+		}
+		if err != nil && !strings.HasPrefix(err.Error(), "tdigest: ") {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+}
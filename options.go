@@ -0,0 +1,131 @@
+package tdigest
+
+import "fmt"
+
+// Option configures a TDigest at construction.
+type Option func(t *TDigest)
+
+// invalidOption records an error wrapping ErrInvalidOption, described by
+// format and args, for NewChecked to return, unless an earlier option was
+// already invalid.
+func (t *TDigest) invalidOption(format string, args ...interface{}) {
+	if t.optionErr == nil {
+		t.optionErr = fmt.Errorf(format+": %w", append(args, ErrInvalidOption)...)
+	}
+}
+
+// WithProcessTrigger controls when buffered centroids are compressed into the
+// digest. Compression runs once more than countLimit centroids are buffered,
+// or once the buffered weight exceeds weightFraction times the weight already
+// compressed. A countLimit of zero keeps the default of 8*ceil(compression)
+// and a weightFraction of zero disables the weight trigger. A negative
+// countLimit, or a weightFraction that is NaN or outside [0, 1], leaves both
+// triggers at their defaults, and makes NewChecked return an error.
+//
+// The default weight fraction of 1 never fires before the count limit for
+// unit weight samples, but keeps digests that absorb heavy pre-aggregated
+// centroids from carrying most of their weight uncompressed.
+func WithProcessTrigger(countLimit int, weightFraction float64) Option {
+	return func(t *TDigest) {
+		if countLimit < 0 || !(weightFraction >= 0 && weightFraction <= 1) {
+			t.invalidOption("process trigger (%d, %v)", countLimit, weightFraction)
+			return
+		}
+		t.maxUnprocessed = countLimit
+		t.weightFraction = weightFraction
+	}
+}
+
+// WithClamp clamps added values, including infinite ones, into [min, max]
+// instead of dropping infinite values. NaN values are still dropped. A NaN
+// bound, or a min above max, leaves values unclamped, and makes NewChecked
+// return an error.
+func WithClamp(min, max float64) Option {
+	return func(t *TDigest) {
+		if !(min <= max) {
+			t.invalidOption("clamp [%v, %v]", min, max)
+			return
+		}
+		t.clamp = true
+		t.clampMin = min
+		t.clampMax = max
+	}
+}
+
+// WithDeterministic defers all processing until Flush, or a query, and makes
+// it depend only on the multiset of centroids added since the last Flush. The
+// same values and weights then give identical centroids however they were
+// grouped into calls and in whatever order they arrived, which lets replicas
+// of a stream produce byte-identical digests.
+//
+// The cost is memory: every centroid added between flushes is kept, at 16
+// bytes each, rather than a buffer of 8*ceil(compression). ShrinkToFit
+// releases the buffer after a Flush.
+func WithDeterministic() Option {
+	return func(t *TDigest) {
+		t.deterministic = true
+	}
+}
+
+// WithPersistentStats keeps the counters of Stats, and Dropped, across
+// Reset, so that they describe the whole life of a digest that is reset
+// periodically.
+func WithPersistentStats() Option {
+	return func(t *TDigest) {
+		t.keepStats = true
+	}
+}
+
+// WithCentroidRanges makes the digest keep the smallest and largest value
+// merged into each centroid. Quantile and CDF then interpolate between two
+// neighbouring centroids through the ends of their ranges rather than
+// straight from one mean to the next, which is tighter where the data is
+// sparse, as in the tail of a heavy-tailed distribution.
+//
+// The ranges take another 16 bytes for every buffered centroid. They are
+// kept through Merge and MarshalBinary; a digest merged in that does not
+// keep them counts each of its centroids as spanning its whole minimum to
+// maximum.
+func WithCentroidRanges() Option {
+	return func(t *TDigest) {
+		t.ranges = true
+	}
+}
+
+// WithQueryCache makes the digest remember the results of the last few
+// distinct quantiles and CDFs it computed, returning them again without
+// searching the centroids until the digest next changes. It suits digests
+// that are queried for the same handful of values much more often than they
+// are processed. Adding data, Merge and Reset invalidate the cache as they
+// process the digest.
+func WithQueryCache() Option {
+	return func(t *TDigest) {
+		t.cache = &queryCache{}
+	}
+}
+
+// ScaleFunction selects how a digest computes the size limits of its
+// centroids.
+type ScaleFunction int
+
+const (
+	// ScaleK1 is the k1 scale function of the t-digest paper, which keeps
+	// centroids small near the tails. It is the default.
+	ScaleK1 ScaleFunction = iota
+
+	// ScaleK1Portable is the portable profile of ScaleK1. It uses only
+	// addition, subtraction, multiplication, division and square roots,
+	// each rounded separately so that no fused multiply-add can change the
+	// result. IEEE 754 defines all of them exactly, so the same input gives
+	// bit-identical centroids on every platform and Go version, which
+	// math.Sin and math.Cos, used by ScaleK1, do not promise. Its limits
+	// differ from those of ScaleK1 by an ulp or so.
+	ScaleK1Portable
+)
+
+// WithScaleFunction sets the scale function of the digest.
+func WithScaleFunction(f ScaleFunction) Option {
+	return func(t *TDigest) {
+		t.scale = f
+	}
+}
@@ -0,0 +1,243 @@
+package tdigest
+
+import (
+	"fmt"
+	"math"
+)
+
+// The limits of the scale of an OpenTelemetry exponential histogram.
+const (
+	MinExponentialScale = -10
+	MaxExponentialScale = 20
+)
+
+// MaxExponentialBuckets is the largest number of buckets
+// ToExponentialHistogram puts on either side of zero. It is the default
+// maximum size of the OpenTelemetry SDK.
+const MaxExponentialBuckets = 160
+
+// ErrInvalidHistogram is returned by FromExponentialHistogram for a histogram
+// that does not describe values a digest can hold.
+const ErrInvalidHistogram = Error("invalid exponential histogram")
+
+// ExponentialHistogram holds the data of an OpenTelemetry exponential
+// histogram. The buckets of index i cover the magnitudes (base^i, base^(i+1)]
+// where base = 2^(2^-Scale), on the positive and negative side of zero.
+type ExponentialHistogram struct {
+	Scale     int32
+	ZeroCount uint64
+	Positive  ExponentialBuckets
+	Negative  ExponentialBuckets
+	Count     uint64
+	Sum       float64
+	// Min and Max are NaN if they are not known.
+	Min float64
+	Max float64
+}
+
+// ExponentialBuckets holds the counts of consecutive buckets, the first of
+// which has index Offset.
+type ExponentialBuckets struct {
+	Offset       int32
+	BucketCounts []uint64
+}
+
+// ToExponentialHistogram processes pending data and returns t as an
+// exponential histogram, as FrozenDigest.ToExponentialHistogram does.
+func (t *TDigest) ToExponentialHistogram(scale int32) ExponentialHistogram {
+	t.Flush()
+	f := t.frozen()
+	return f.ToExponentialHistogram(scale)
+}
+
+// ToExponentialHistogram returns the snapshot as an exponential histogram of
+// the given scale, clamped into [MinExponentialScale, MaxExponentialScale].
+// As the OpenTelemetry SDK does, it lowers the scale until each side of zero
+// needs at most MaxExponentialBuckets buckets; the Scale of the result is the
+// one used.
+//
+// The weight of each bucket is estimated from CDF at its boundaries and
+// rounded so that the counts add up to the rounded weight of the digest.
+// Centroids with a mean of exactly zero make up the zero count.
+func (f *FrozenDigest) ToExponentialHistogram(scale int32) ExponentialHistogram {
+	return f.exponentialHistogram(scale, MinExponentialScale, MaxExponentialScale, 0)
+}
+
+// exponentialHistogram returns the snapshot as an exponential histogram with
+// the scale clamped into [minScale, maxScale] and lowered as far as minScale
+// to fit the buckets. The centroids with means within zeroThreshold of zero
+// make up the zero count.
+func (f *FrozenDigest) exponentialHistogram(scale, minScale, maxScale int32, zeroThreshold float64) ExponentialHistogram {
+	if scale < minScale {
+		scale = minScale
+	}
+	if scale > maxScale {
+		scale = maxScale
+	}
+	h := ExponentialHistogram{Scale: scale, Min: math.NaN(), Max: math.NaN()}
+	n := f.processed.Len()
+	if n == 0 {
+		return h
+	}
+	h.Count = uint64(math.Round(f.count))
+	h.Sum = f.Sum()
+	h.Min, h.Max = f.min, f.max
+
+	// The weight of the centroids with means below the zero bucket, and
+	// with means up to its top.
+	zero, positive := f.processed.CeilIndexByMean(-zeroThreshold), f.processed.FloorIndexByMean(zeroThreshold)+1
+	below, upTo := f.weightBefore(zero), f.weightBefore(positive)
+	h.ZeroCount = uint64(math.Round(upTo) - math.Round(below))
+
+	// The magnitudes each side covers, from the mean nearest zero out to
+	// the bound.
+	var posLo, posHi, negLo, negHi float64
+	if positive < n {
+		posLo, posHi = f.processed[positive].Mean, f.max
+		if f.min > zeroThreshold {
+			posLo = f.min
+		}
+	}
+	if zero > 0 {
+		negLo, negHi = -f.processed[zero-1].Mean, -f.min
+		if f.max < -zeroThreshold {
+			negLo = -f.max
+		}
+	}
+	for h.Scale > minScale &&
+		(bucketSpan(posLo, posHi, h.Scale) > MaxExponentialBuckets ||
+			bucketSpan(negLo, negHi, h.Scale) > MaxExponentialBuckets) {
+		h.Scale--
+	}
+
+	// rank returns the estimated weight below x, kept within [lo, hi].
+	rank := func(x, lo, hi float64) float64 {
+		return math.Round(math.Max(lo, math.Min(f.count*f.CDF(x), hi)))
+	}
+	if positive < n {
+		first, last := bucketIndex(posLo, h.Scale), bucketIndex(posHi, h.Scale)
+		h.Positive.Offset = first
+		prev := math.Round(upTo)
+		for i := first; i <= last; i++ {
+			next := math.Round(f.count)
+			if i < last {
+				next = rank(bucketBound(i+1, h.Scale), prev, next)
+			}
+			h.Positive.BucketCounts = append(h.Positive.BucketCounts, uint64(next-prev))
+			prev = next
+		}
+	}
+	if zero > 0 {
+		first, last := bucketIndex(negLo, h.Scale), bucketIndex(negHi, h.Scale)
+		h.Negative.Offset = first
+		h.Negative.BucketCounts = make([]uint64, last-first+1)
+		prev := 0.0
+		for i := last; i >= first; i-- {
+			next := math.Round(below)
+			if i > first {
+				next = rank(-bucketBound(i, h.Scale), prev, next)
+			}
+			h.Negative.BucketCounts[i-first] = uint64(next - prev)
+			prev = next
+		}
+	}
+	return h
+}
+
+// maxBucketPieces is the most centroids FromExponentialHistogram splits a
+// bucket into. Only buckets of the lowest scales, each spanning many powers
+// of two, reach it.
+const maxBucketPieces = 64
+
+// FromExponentialHistogram returns a digest with the given compression
+// holding the data of h. Each bucket becomes a centroid at the geometric
+// midpoint of its range, clamped by h.Min and h.Max when they are known, and
+// buckets wider than a factor of sqrt(2) are split into that many centroids
+// of equal weight, up to maxBucketPieces. Buckets that reach beyond the range
+// of float64 are cut to it. It returns an error wrapping ErrInvalidHistogram
+// if the scale is out of range or a bucket with a count lies entirely outside
+// the range of float64.
+func FromExponentialHistogram(h ExponentialHistogram, compression float64) (*TDigest, error) {
+	if h.Scale < MinExponentialScale || h.Scale > MaxExponentialScale {
+		return nil, fmt.Errorf("scale %d: %w", h.Scale, ErrInvalidHistogram)
+	}
+	t := NewWithCompression(compression)
+	if h.ZeroCount > 0 {
+		t.AddCentroid(Centroid{Mean: 0, Weight: float64(h.ZeroCount)})
+	}
+	for _, side := range []struct {
+		buckets ExponentialBuckets
+		sign    float64
+	}{{h.Positive, 1}, {h.Negative, -1}} {
+		for k, count := range side.buckets.BucketCounts {
+			if count == 0 {
+				continue
+			}
+			i := side.buckets.Offset + int32(k)
+			lo, hi := bucketBound(i, h.Scale), bucketBound(i+1, h.Scale)
+			if math.IsInf(lo, 0) || hi == 0 {
+				return nil, fmt.Errorf("bucket %d at scale %d: %w", i, h.Scale, ErrInvalidHistogram)
+			}
+			// At low scales a bucket can reach past the range of float64
+			// while still holding values inside it; its bounds are cut to
+			// that range before being clamped to the known ones.
+			lo = math.Max(lo, math.SmallestNonzeroFloat64)
+			hi = math.Min(hi, math.MaxFloat64)
+			// Clamp the magnitudes to those of the known bounds.
+			if side.sign > 0 {
+				lo, hi = clampRange(lo, hi, h.Min, h.Max)
+			} else {
+				lo, hi = clampRange(lo, hi, -h.Max, -h.Min)
+			}
+			// The split is computed on the logarithms of the bounds, since
+			// hi/lo can overflow.
+			logLo, logHi := math.Log2(lo), math.Log2(hi)
+			pieces := math.Min(maxBucketPieces, math.Max(1, math.Ceil(2*(logHi-logLo))))
+			step := (logHi - logLo) / pieces
+			w := float64(count) / pieces
+			for j := 0.0; j < pieces; j++ {
+				mean := math.Exp2(logLo + (j+0.5)*step)
+				t.AddCentroid(Centroid{Mean: side.sign * math.Max(lo, math.Min(mean, hi)), Weight: w})
+			}
+		}
+	}
+	if t.Count() > 0 {
+		for _, x := range []float64{h.Min, h.Max} {
+			if x-x == 0 {
+				t.updateBounds(x)
+			}
+		}
+	}
+	return t, nil
+}
+
+// clampRange returns [lo, hi] narrowed to [min, max] where it overlaps them,
+// ignoring bounds that are NaN.
+func clampRange(lo, hi, min, max float64) (float64, float64) {
+	if min > lo && min < hi {
+		lo = min
+	}
+	if max < hi && max > lo {
+		hi = max
+	}
+	return lo, hi
+}
+
+// bucketIndex returns the index of the bucket holding the magnitude x.
+func bucketIndex(x float64, scale int32) int32 {
+	return int32(math.Ceil(math.Ldexp(math.Log2(x), int(scale)))) - 1
+}
+
+// bucketBound returns the lower bound of the bucket of index i.
+func bucketBound(i int32, scale int32) float64 {
+	return math.Exp2(math.Ldexp(float64(i), -int(scale)))
+}
+
+// bucketSpan returns the number of buckets needed for the magnitudes from lo
+// to hi, or 0 if there are none.
+func bucketSpan(lo, hi float64, scale int32) int {
+	if !(lo > 0) {
+		return 0
+	}
+	return int(bucketIndex(hi, scale)) - int(bucketIndex(lo, scale)) + 1
+}
@@ -0,0 +1,139 @@
+package tdigest_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestTdigest_ExponentialHistogramRoundTrip(t *testing.T) {
+	td := tdigest.NewWithCompression(1000)
+	for _, x := range NormalData[:100000] {
+		// Values around zero on both sides, with exact zeros.
+		td.Add(math.Trunc((x-Mu)*4)/4, 1)
+	}
+	const scale = 3
+	h := td.ToExponentialHistogram(scale)
+	if h.Scale != scale {
+		t.Fatalf("unexpected scale %d", h.Scale)
+	}
+	if h.ZeroCount == 0 || len(h.Negative.BucketCounts) == 0 || len(h.Positive.BucketCounts) == 0 {
+		t.Fatalf("expected zero, negative and positive counts, got %+v", h)
+	}
+	total := h.ZeroCount
+	for _, c := range append(h.Negative.BucketCounts, h.Positive.BucketCounts...) {
+		total += c
+	}
+	if total != h.Count || h.Count != 100000 {
+		t.Errorf("buckets hold %d values, count is %d", total, h.Count)
+	}
+	if h.Min != td.Min() || h.Max != td.Max() {
+		t.Errorf("unexpected bounds %v, %v", h.Min, h.Max)
+	}
+
+	back, err := tdigest.FromExponentialHistogram(h, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Count() != td.Count() || back.Min() != td.Min() || back.Max() != td.Max() {
+		t.Errorf("unexpected count or bounds %v [%v, %v]", back.Count(), back.Min(), back.Max())
+	}
+	// Quantiles agree to within about a bucket, the width of which is a
+	// factor of 2^(1/8).
+	width := math.Exp2(math.Exp2(-scale)) - 1
+	for q := 0.01; q < 1; q += 0.01 {
+		want, got := td.Quantile(q), back.Quantile(q)
+		if math.Abs(got-want) > 2*width*math.Abs(want)+0.25 {
+			t.Errorf("q=%v: got %v, want %v", q, got, want)
+		}
+	}
+}
+
+func TestTdigest_ExponentialHistogramLowestScale(t *testing.T) {
+	// At scale -10 the buckets next to 1 are [2^-1024, 1) and [1, 2^1024),
+	// whose bounds are subnormal and beyond float64.
+	td := tdigest.NewWithCompression(100)
+	td.AddValues([]float64{0.25, 0.5, 2, 10, 1e300, -3, -0.5})
+	h := td.ToExponentialHistogram(tdigest.MinExponentialScale)
+	if h.Scale != tdigest.MinExponentialScale {
+		t.Fatalf("unexpected scale %d", h.Scale)
+	}
+	back, err := tdigest.FromExponentialHistogram(h, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Count() != td.Count() || back.Min() != td.Min() || back.Max() != td.Max() {
+		t.Errorf("unexpected count or bounds %v [%v, %v]", back.Count(), back.Min(), back.Max())
+	}
+	if n := len(back.Centroids()); n > 4*64 {
+		t.Errorf("%d centroids from %d buckets", n, len(h.Positive.BucketCounts)+len(h.Negative.BucketCounts))
+	}
+	if err := back.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTdigest_ExponentialHistogramScale(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	td.AddValues([]float64{1e-10, 1, 1e10})
+	h := td.ToExponentialHistogram(tdigest.MaxExponentialScale)
+	if len(h.Positive.BucketCounts) > tdigest.MaxExponentialBuckets {
+		t.Errorf("%d buckets at scale %d", len(h.Positive.BucketCounts), h.Scale)
+	}
+	if h.Scale != 1 {
+		t.Errorf("unexpected scale %d", h.Scale)
+	}
+	if h.Negative.BucketCounts != nil || h.ZeroCount != 0 {
+		t.Errorf("unexpected negative or zero counts %+v", h)
+	}
+
+	empty := tdigest.NewWithCompression(100).ToExponentialHistogram(0)
+	if empty.Count != 0 || !math.IsNaN(empty.Min) || !math.IsNaN(empty.Max) {
+		t.Errorf("unexpected empty histogram %+v", empty)
+	}
+	back, err := tdigest.FromExponentialHistogram(empty, 100)
+	if err != nil || back.Count() != 0 {
+		t.Errorf("unexpected digest from empty histogram %v, %v", back, err)
+	}
+}
+
+func TestFromExponentialHistogram_Invalid(t *testing.T) {
+	for _, h := range []tdigest.ExponentialHistogram{
+		{Scale: tdigest.MaxExponentialScale + 1},
+		{Scale: 0, Positive: tdigest.ExponentialBuckets{Offset: 1024, BucketCounts: []uint64{1}}},
+		{Scale: 0, Negative: tdigest.ExponentialBuckets{Offset: -1200, BucketCounts: []uint64{1}}},
+	} {
+		if _, err := tdigest.FromExponentialHistogram(h, 100); !errors.Is(err, tdigest.ErrInvalidHistogram) {
+			t.Errorf("%+v: unexpected error %v", h, err)
+		}
+	}
+	// Empty buckets out of range are ignored.
+	h := tdigest.ExponentialHistogram{Positive: tdigest.ExponentialBuckets{Offset: 1024, BucketCounts: []uint64{0}}}
+	if _, err := tdigest.FromExponentialHistogram(h, 100); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFromExponentialHistogram_WideBuckets(t *testing.T) {
+	// A single bucket covering (16, 256] at scale -2 is split into centroids
+	// across its range.
+	h := tdigest.ExponentialHistogram{
+		Scale:    -2,
+		Positive: tdigest.ExponentialBuckets{Offset: 1, BucketCounts: []uint64{80}},
+		Count:    80,
+		Min:      math.NaN(),
+		Max:      math.NaN(),
+	}
+	td, err := tdigest.FromExponentialHistogram(h, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if td.Count() != 80 || td.Min() <= 16 || td.Max() >= 256 {
+		t.Errorf("unexpected digest of %v [%v, %v]", td.Count(), td.Min(), td.Max())
+	}
+	if m := td.Quantile(0.5); math.Abs(m-64) > 2 {
+		t.Errorf("unexpected median %v, want about 64", m)
+	}
+}
@@ -0,0 +1,58 @@
+package tdigest
+
+import (
+	"math"
+	"sort"
+)
+
+// OverlapCoefficient returns the overlap of the distributions of a and b:
+// the integral of the smaller of their densities, from 0 for distributions
+// with no values in common to 1 for identical ones. It suits comparing, say,
+// the latencies of a canary with those of the rest of a fleet. It processes
+// pending data of both digests but does not otherwise modify them.
+//
+// The integral is evaluated at n points, half of them spread evenly over the
+// quantiles of each digest from its minimum to its maximum, so that they are
+// dense where either distribution is. Between two neighbouring points it
+// takes the smaller of the two increases of CDF, which needs no density, and
+// a point mass at one of the points is compared with whatever weight the
+// other digest has there; the weight below the first point and above the
+// last is counted the same way. The result is symmetric in a and b. It
+// returns NaN if either digest is empty or n is less than 4.
+func OverlapCoefficient(a, b *TDigest, n int) float64 {
+	if a.Count() == 0 || b.Count() == 0 || n < 4 {
+		return math.NaN()
+	}
+	a.Flush()
+	b.Flush()
+	fa, fb := a.frozen(), b.frozen()
+	k := n / 2
+	points := make([]float64, 0, 2*k)
+	for _, f := range [...]*FrozenDigest{&fa, &fb} {
+		for i := 0; i < k; i++ {
+			points = append(points, f.quantile(float64(i)/float64(k-1)))
+		}
+	}
+	sort.Float64s(points)
+
+	var overlap kahanSum
+	var prevA, prevB float64
+	for i, x := range points {
+		if i > 0 && x == points[i-1] {
+			continue
+		}
+		// CDF puts a value holding a point mass part way up its step, so
+		// the weight on either side of x is taken from just below and just
+		// above it, and a point mass is compared as a whole.
+		below, above := math.Nextafter(x, math.Inf(-1)), math.Nextafter(x, math.Inf(1))
+		// Where points are adjacent floats, below is the previous point, and
+		// its CDF is kept from falling back from the one above that point.
+		belowA, belowB := math.Max(prevA, fa.cdf(below)), math.Max(prevB, fb.cdf(below))
+		aboveA, aboveB := fa.cdf(above), fb.cdf(above)
+		overlap.add(math.Min(belowA-prevA, belowB-prevB))
+		overlap.add(math.Min(aboveA-belowA, aboveB-belowB))
+		prevA, prevB = aboveA, aboveB
+	}
+	overlap.add(math.Min(1-prevA, 1-prevB))
+	return math.Max(0, math.Min(overlap.value(), 1))
+}
@@ -0,0 +1,83 @@
+package tdigest_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestOverlapCoefficient(t *testing.T) {
+	// Normals of equal spread whose means are d standard deviations apart
+	// overlap by 2Φ(-d/2).
+	r := rand.New(rand.NewSource(1))
+	for _, d := range []float64{0, 0.5, 1, 2, 4} {
+		a, b := tdigest.NewWithCompression(100), tdigest.NewWithCompression(100)
+		for i := 0; i < 100000; i++ {
+			a.Add(r.NormFloat64(), 1)
+			b.Add(r.NormFloat64()+d, 1)
+		}
+		want := math.Erfc(d / 2 / math.Sqrt2)
+		got := tdigest.OverlapCoefficient(a, b, 200)
+		if math.Abs(got-want) > 0.025 {
+			t.Errorf("normals %g apart: overlap %g, want %g", d, got, want)
+		}
+		if reversed := tdigest.OverlapCoefficient(b, a, 200); reversed != got {
+			t.Errorf("normals %g apart: overlap %g one way and %g the other", d, got, reversed)
+		}
+		if self := tdigest.OverlapCoefficient(a, a, 200); math.Abs(self-1) > 1e-12 {
+			t.Errorf("overlap of a digest with itself is %g", self)
+		}
+	}
+}
+
+func TestOverlapCoefficientEdges(t *testing.T) {
+	low, high := tdigest.New(), tdigest.New()
+	for i := 0; i < 1000; i++ {
+		low.Add(float64(i)/1000, 1)
+		high.Add(2+float64(i)/1000, 1)
+	}
+	if got := tdigest.OverlapCoefficient(low, high, 100); got != 0 {
+		t.Errorf("disjoint digests overlap by %g", got)
+	}
+	copied := tdigest.New()
+	copied.Merge(low)
+	if got := tdigest.OverlapCoefficient(low, copied, 100); math.Abs(got-1) > 1e-12 {
+		t.Errorf("a digest and its copy overlap by %g", got)
+	}
+
+	// Point masses overlap by the weight they share.
+	one, two, mixed := tdigest.New(), tdigest.New(), tdigest.New()
+	one.Add(1, 10)
+	two.Add(2, 10)
+	mixed.Add(1, 10)
+	mixed.Add(2, 30)
+	for _, tt := range []struct {
+		name string
+		a, b *tdigest.TDigest
+		want float64
+	}{
+		{"same point", one, one, 1},
+		{"different points", one, two, 0},
+		{"shared point", one, mixed, 0.25},
+	} {
+		if got := tdigest.OverlapCoefficient(tt.a, tt.b, 10); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("%s: overlap %g, want %g", tt.name, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		a, b *tdigest.TDigest
+		n    int
+	}{
+		{"empty", tdigest.New(), low, 100},
+		{"empty second", low, tdigest.New(), 100},
+		{"too few points", low, high, 3},
+	} {
+		if got := tdigest.OverlapCoefficient(tt.a, tt.b, tt.n); !math.IsNaN(got) {
+			t.Errorf("%s: got %g, want NaN", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Package promtdigest exposes t-digests to Prometheus as summaries.
+package promtdigest
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/influxdata/tdigest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefObjectives are the quantiles a Collector reports if Opts.Objectives is
+// empty.
+var DefObjectives = []float64{0.5, 0.9, 0.99}
+
+// Source is a digest that can be snapshotted while it is being written.
+// *tdigest.ConcurrentTDigest is a Source, holding its lock only to copy its
+// centroids. Use Published for a *tdigest.TDigest.
+type Source interface {
+	Snapshot() *tdigest.FrozenDigest
+}
+
+// Published returns a Source that reads the snapshots last published by
+// t.Publish. It takes no locks, so the goroutine writing t must publish to
+// make new data visible to scrapes.
+func Published(t *tdigest.TDigest) Source {
+	return published{t}
+}
+
+type published struct {
+	t *tdigest.TDigest
+}
+
+func (p published) Snapshot() *tdigest.FrozenDigest {
+	return p.t.Published()
+}
+
+// Opts configures a Collector. The fields other than Objectives are those of
+// prometheus.SummaryOpts.
+type Opts struct {
+	Namespace   string
+	Subsystem   string
+	Name        string
+	Help        string
+	ConstLabels prometheus.Labels
+
+	// Objectives are the quantiles to report, each in [0, 1].
+	Objectives []float64
+
+	// Compression is the compression of the digests a SummaryVec creates.
+	// Zero means tdigest.DefaultCompression.
+	Compression float64
+}
+
+// Collector is a prometheus.Collector that reports each of a set of digests
+// as a summary with the quantiles of Opts.Objectives and the count and sum
+// of the digest.
+type Collector struct {
+	desc       *prometheus.Desc
+	labels     int
+	objectives []float64
+
+	mu      sync.Mutex
+	digests []labeledSource
+}
+
+type labeledSource struct {
+	src    Source
+	values []string
+}
+
+// NewCollector returns a Collector with the given options whose digests are
+// distinguished by labelNames. It panics if an objective is not in [0, 1].
+func NewCollector(opts Opts, labelNames ...string) *Collector {
+	objectives := opts.Objectives
+	if len(objectives) == 0 {
+		objectives = DefObjectives
+	}
+	for _, q := range objectives {
+		if !(q >= 0 && q <= 1) {
+			panic(fmt.Sprintf("promtdigest: objective %g is not in [0, 1]", q))
+		}
+	}
+	return &Collector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+			opts.Help, labelNames, opts.ConstLabels),
+		labels:     len(labelNames),
+		objectives: append([]float64(nil), objectives...),
+	}
+}
+
+// Add adds src to the digests reported by c, with the given label values.
+// It returns an error if their number does not match the label names of c.
+func (c *Collector) Add(src Source, labelValues ...string) error {
+	if len(labelValues) != c.labels {
+		return fmt.Errorf("promtdigest: %d label values for %d label names", len(labelValues), c.labels)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.digests = append(c.digests, labeledSource{src, append([]string(nil), labelValues...)})
+	return nil
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector. It takes one snapshot of each
+// digest, so that the quantiles, count and sum it reports agree.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	digests := append([]labeledSource(nil), c.digests...)
+	c.mu.Unlock()
+
+	for _, d := range digests {
+		f := d.src.Snapshot()
+		quantiles := make(map[float64]float64, len(c.objectives))
+		for _, q := range c.objectives {
+			quantiles[q] = f.Quantile(q)
+		}
+		m, err := prometheus.NewConstSummary(c.desc, uint64(math.Round(f.Count())), f.Sum(), quantiles, d.values...)
+		if err != nil {
+			m = prometheus.NewInvalidMetric(c.desc, err)
+		}
+		ch <- m
+	}
+}
@@ -0,0 +1,112 @@
+package promtdigest_test
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+	"github.com/influxdata/tdigest/promtdigest"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func collect(t *testing.T, c prometheus.Collector) []*dto.Metric {
+	t.Helper()
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	var metrics []*dto.Metric
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		metrics = append(metrics, &pb)
+	}
+	return metrics
+}
+
+func TestCollector(t *testing.T) {
+	c := promtdigest.NewCollector(promtdigest.Opts{Name: "x", Help: "x"}, "shard")
+	if err := c.Add(tdigest.NewConcurrent(100)); err == nil {
+		t.Error("expected an error for a missing label value")
+	}
+
+	conc := tdigest.NewConcurrent(100)
+	pub := tdigest.NewWithCompression(100)
+	for i := 0; i < 100; i++ {
+		conc.Add(float64(i), 1)
+		pub.Add(float64(i), 2)
+	}
+	if err := c.Add(conc, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Add(promtdigest.Published(pub), "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Data added since the last Publish is not visible.
+	metrics := collect(t, c)
+	if len(metrics) != 2 {
+		t.Fatalf("unexpected number of metrics %d", len(metrics))
+	}
+	if got := metrics[1].GetSummary().GetSampleCount(); got != 0 {
+		t.Errorf("unexpected count before Publish %d", got)
+	}
+	pub.Publish()
+	for i, want := range []struct {
+		count uint64
+		sum   float64
+	}{{100, 4950}, {200, 9900}} {
+		s := collect(t, c)[i].GetSummary()
+		if s.GetSampleCount() != want.count || s.GetSampleSum() != want.sum {
+			t.Errorf("digest %d: unexpected count %d and sum %g", i, s.GetSampleCount(), s.GetSampleSum())
+		}
+		if n := len(s.GetQuantile()); n != len(promtdigest.DefObjectives) {
+			t.Errorf("digest %d: unexpected number of quantiles %d", i, n)
+		}
+		for _, q := range s.GetQuantile() {
+			if got, want := q.GetValue(), q.GetQuantile()*100; math.Abs(got-want) > 1 {
+				t.Errorf("digest %d: unexpected quantile %g, got %g want %g", i, q.GetQuantile(), got, want)
+			}
+		}
+	}
+}
+
+func TestCollector_InvalidObjective(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for an objective outside [0, 1]")
+		}
+	}()
+	promtdigest.NewCollector(promtdigest.Opts{Name: "x", Objectives: []float64{1.5}})
+}
+
+func TestCollector_ConcurrentWrites(t *testing.T) {
+	td := tdigest.NewConcurrent(100)
+	c := promtdigest.NewCollector(promtdigest.Opts{Name: "x", Help: "x"})
+	if err := c.Add(td); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100000; i++ {
+			td.Add(float64(i%1000), 1)
+		}
+	}()
+	prev := uint64(0)
+	for i := 0; i < 100; i++ {
+		s := collect(t, c)[0].GetSummary()
+		if n := s.GetSampleCount(); n < prev {
+			t.Errorf("count went backwards from %d to %d", prev, n)
+		} else {
+			prev = n
+		}
+	}
+	wg.Wait()
+}
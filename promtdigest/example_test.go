@@ -0,0 +1,43 @@
+package promtdigest_test
+
+import (
+	"os"
+
+	"github.com/influxdata/tdigest"
+	"github.com/influxdata/tdigest/promtdigest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+func Example() {
+	latency := tdigest.NewConcurrent(100)
+	for i := 1; i <= 1000; i++ {
+		latency.Add(float64(i)/1000, 1)
+	}
+
+	c := promtdigest.NewCollector(promtdigest.Opts{
+		Name:       "request_duration_seconds",
+		Help:       "Request latency.",
+		Objectives: []float64{0.5, 0.99},
+	}, "handler")
+	if err := c.Add(latency, "/api"); err != nil {
+		panic(err)
+	}
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+
+	families, err := reg.Gather()
+	if err != nil {
+		panic(err)
+	}
+	for _, mf := range families {
+		expfmt.MetricFamilyToText(os.Stdout, mf)
+	}
+	// Output:
+	// # HELP request_duration_seconds Request latency.
+	// # TYPE request_duration_seconds summary
+	// request_duration_seconds{handler="/api",quantile="0.5"} 0.5005
+	// request_duration_seconds{handler="/api",quantile="0.99"} 0.9905
+	// request_duration_seconds_sum{handler="/api"} 500.5
+	// request_duration_seconds_count{handler="/api"} 1000
+}
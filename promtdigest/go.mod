@@ -0,0 +1,12 @@
+module github.com/influxdata/tdigest/promtdigest
+
+go 1.16
+
+require (
+	github.com/influxdata/tdigest v0.0.0
+	github.com/prometheus/client_golang v1.11.1
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.26.0
+)
+
+replace github.com/influxdata/tdigest => ../
@@ -0,0 +1,71 @@
+package promtdigest_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/influxdata/tdigest"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// referenceOpenMetrics encodes the summary of f with the Prometheus encoder.
+func referenceOpenMetrics(t *testing.T, f *tdigest.FrozenDigest, name string, labels []*dto.LabelPair, qs []float64, ts *int64) string {
+	t.Helper()
+	count, sum := uint64(f.Count()), f.Sum()
+	summary := &dto.Summary{SampleCount: &count, SampleSum: &sum}
+	for _, q := range qs {
+		q, v := q, f.Quantile(q)
+		summary.Quantile = append(summary.Quantile, &dto.Quantile{Quantile: &q, Value: &v})
+	}
+	typ := dto.MetricType_SUMMARY
+	var buf bytes.Buffer
+	_, err := expfmt.MetricFamilyToOpenMetrics(&buf, &dto.MetricFamily{
+		Name:   &name,
+		Type:   &typ,
+		Metric: []*dto.Metric{{Label: labels, Summary: summary, TimestampMs: ts}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func labelPair(name, value string) *dto.LabelPair {
+	return &dto.LabelPair{Name: &name, Value: &value}
+}
+
+// TestWriteOpenMetrics checks tdigest's OpenMetrics writer, which has no
+// Prometheus dependency, against the Prometheus encoder.
+func TestWriteOpenMetrics(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	td := tdigest.NewWithCompression(100)
+	for i := 0; i < 1000; i++ {
+		td.Add(r.NormFloat64()*3+10, 1)
+	}
+	f := td.Snapshot()
+	qs := []float64{0, 0.5, 0.99, 1}
+
+	var buf bytes.Buffer
+	labels := map[string]string{"path": `/a"b\c` + "\n", "method": "GET"}
+	if err := f.WriteOpenMetrics(&buf, "latency_seconds", labels, qs); err != nil {
+		t.Fatal(err)
+	}
+	want := referenceOpenMetrics(t, f, "latency_seconds",
+		[]*dto.LabelPair{labelPair("method", "GET"), labelPair("path", `/a"b\c`+"\n")}, qs, nil)
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	ts := time.Unix(1600000000, 123456789)
+	if err := f.WriteOpenMetricsAt(&buf, "latency_seconds", nil, qs, ts); err != nil {
+		t.Fatal(err)
+	}
+	ms := int64(1600000000123)
+	if want := referenceOpenMetrics(t, f, "latency_seconds", nil, qs, &ms); buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
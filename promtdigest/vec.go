@@ -0,0 +1,169 @@
+package promtdigest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/influxdata/tdigest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SummaryVec is a prometheus.ObserverVec backed by t-digests. It creates a
+// *tdigest.ConcurrentTDigest for each combination of label values when it is
+// first used and reports them all as a Collector does, so it can replace a
+// prometheus.SummaryVec or HistogramVec in instrumentation such as
+// promhttp.InstrumentHandlerDuration.
+type SummaryVec struct {
+	*vec
+	curry []curriedLabel // sorted by index
+}
+
+var (
+	_ prometheus.ObserverVec = (*SummaryVec)(nil)
+	_ prometheus.Observer    = (*tdigest.ConcurrentTDigest)(nil)
+)
+
+// vec is the state shared by a SummaryVec and the vectors curried from it.
+type vec struct {
+	*Collector
+	labelNames  []string
+	compression float64
+
+	mu      sync.Mutex
+	digests map[string]*tdigest.ConcurrentTDigest
+}
+
+type curriedLabel struct {
+	index int
+	value string
+}
+
+// NewSummaryVec returns a SummaryVec with the given options and label names.
+// It panics if an objective is not in [0, 1].
+func NewSummaryVec(opts Opts, labelNames ...string) *SummaryVec {
+	compression := opts.Compression
+	if compression == 0 {
+		compression = tdigest.DefaultCompression
+	}
+	return &SummaryVec{vec: &vec{
+		Collector:   NewCollector(opts, labelNames...),
+		labelNames:  append([]string(nil), labelNames...),
+		compression: compression,
+		digests:     make(map[string]*tdigest.ConcurrentTDigest),
+	}}
+}
+
+// GetMetricWithLabelValues returns the digest for the given values of the
+// labels that are not curried, in the order of the label names, creating it
+// if needed.
+func (v *SummaryVec) GetMetricWithLabelValues(lvs ...string) (prometheus.Observer, error) {
+	if want := len(v.labelNames) - len(v.curry); len(lvs) != want {
+		return nil, fmt.Errorf("promtdigest: %d label values for %d labels", len(lvs), want)
+	}
+	values := make([]string, 0, len(v.labelNames))
+	curry := v.curry
+	for i := range v.labelNames {
+		if len(curry) > 0 && curry[0].index == i {
+			values = append(values, curry[0].value)
+			curry = curry[1:]
+			continue
+		}
+		values = append(values, lvs[0])
+		lvs = lvs[1:]
+	}
+	return v.digest(values), nil
+}
+
+// GetMetricWith returns the digest for the given labels, which must be
+// exactly the labels that are not curried, creating it if needed.
+func (v *SummaryVec) GetMetricWith(labels prometheus.Labels) (prometheus.Observer, error) {
+	if want := len(v.labelNames) - len(v.curry); len(labels) != want {
+		return nil, fmt.Errorf("promtdigest: %d labels for %d labels", len(labels), want)
+	}
+	values := make([]string, 0, len(v.labelNames))
+	curry := v.curry
+	for i, name := range v.labelNames {
+		if len(curry) > 0 && curry[0].index == i {
+			values = append(values, curry[0].value)
+			curry = curry[1:]
+			continue
+		}
+		value, ok := labels[name]
+		if !ok {
+			return nil, fmt.Errorf("promtdigest: label %q missing", name)
+		}
+		values = append(values, value)
+	}
+	return v.digest(values), nil
+}
+
+// WithLabelValues is like GetMetricWithLabelValues but panics on error.
+func (v *SummaryVec) WithLabelValues(lvs ...string) prometheus.Observer {
+	o, err := v.GetMetricWithLabelValues(lvs...)
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+// With is like GetMetricWith but panics on error.
+func (v *SummaryVec) With(labels prometheus.Labels) prometheus.Observer {
+	o, err := v.GetMetricWith(labels)
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+// CurryWith returns a view of v with the given labels fixed. The view shares
+// the digests of v, and collecting either reports all of them. It returns an
+// error for a label that v does not have or has already curried.
+func (v *SummaryVec) CurryWith(labels prometheus.Labels) (prometheus.ObserverVec, error) {
+	curry := append([]curriedLabel(nil), v.curry...)
+	for name, value := range labels {
+		index := -1
+		for i, n := range v.labelNames {
+			if n == name {
+				index = i
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("promtdigest: unknown label %q", name)
+		}
+		for _, c := range v.curry {
+			if c.index == index {
+				return nil, fmt.Errorf("promtdigest: label %q already curried", name)
+			}
+		}
+		curry = append(curry, curriedLabel{index, value})
+	}
+	sort.Slice(curry, func(i, j int) bool { return curry[i].index < curry[j].index })
+	return &SummaryVec{vec: v.vec, curry: curry}, nil
+}
+
+// MustCurryWith is like CurryWith but panics on error.
+func (v *SummaryVec) MustCurryWith(labels prometheus.Labels) prometheus.ObserverVec {
+	o, err := v.CurryWith(labels)
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+// digest returns the digest for the full list of label values, creating and
+// registering it with the collector on first use.
+func (v *vec) digest(values []string) *tdigest.ConcurrentTDigest {
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	d, ok := v.digests[key]
+	if !ok {
+		d = tdigest.NewConcurrent(v.compression)
+		v.digests[key] = d
+		// The number of values is always right here.
+		_ = v.Collector.Add(d, values...)
+	}
+	return d
+}
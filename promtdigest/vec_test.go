@@ -0,0 +1,79 @@
+package promtdigest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/influxdata/tdigest/promtdigest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestSummaryVec_InstrumentHandler(t *testing.T) {
+	vec := promtdigest.NewSummaryVec(promtdigest.Opts{
+		Name: "request_duration_seconds",
+		Help: "Request latency.",
+	}, "handler", "code", "method")
+	handler := promhttp.InstrumentHandlerDuration(
+		vec.MustCurryWith(prometheus.Labels{"handler": "/api"}),
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				w.WriteHeader(http.StatusCreated)
+			}
+		}))
+	for i := 0; i < 10; i++ {
+		method := http.MethodGet
+		if i%5 == 0 {
+			method = http.MethodPost
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/api", nil))
+	}
+
+	counts := make(map[string]uint64)
+	for _, m := range collect(t, vec) {
+		labels := make(map[string]string)
+		for _, l := range m.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		if labels["handler"] != "/api" {
+			t.Errorf("unexpected labels %v", labels)
+		}
+		counts[labels["code"]+" "+labels["method"]] = m.GetSummary().GetSampleCount()
+	}
+	if len(counts) != 2 || counts["200 get"] != 8 || counts["201 post"] != 2 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+}
+
+func TestSummaryVec(t *testing.T) {
+	vec := promtdigest.NewSummaryVec(promtdigest.Opts{Name: "x", Help: "x"}, "a", "b")
+	vec.WithLabelValues("1", "2").Observe(1)
+	vec.With(prometheus.Labels{"a": "1", "b": "2"}).Observe(2)
+	curried := vec.MustCurryWith(prometheus.Labels{"b": "2"})
+	curried.WithLabelValues("1").Observe(3)
+	curried.With(prometheus.Labels{"a": "3"}).Observe(4)
+
+	metrics := collect(t, curried)
+	if len(metrics) != 2 {
+		t.Fatalf("unexpected number of metrics %d", len(metrics))
+	}
+	if got := metrics[0].GetSummary().GetSampleCount(); got != 3 {
+		t.Errorf("unexpected count %d for a=1, b=2", got)
+	}
+	if got := metrics[1].GetSummary().GetSampleSum(); got != 4 {
+		t.Errorf("unexpected sum %g for a=3, b=2", got)
+	}
+
+	for _, labels := range []prometheus.Labels{{"b": "3"}, {"c": "1"}} {
+		if _, err := curried.CurryWith(labels); err == nil {
+			t.Errorf("expected an error currying %v", labels)
+		}
+	}
+	if _, err := curried.GetMetricWithLabelValues("1", "2"); err == nil {
+		t.Error("expected an error for a curried label value")
+	}
+	if _, err := vec.GetMetricWith(prometheus.Labels{"a": "1", "c": "2"}); err == nil {
+		t.Error("expected an error for an unknown label")
+	}
+}
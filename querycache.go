@@ -0,0 +1,87 @@
+package tdigest
+
+import (
+	"math"
+	"sync"
+)
+
+// queryCacheSize is the number of quantile results, and of CDF results, a
+// query cache holds. Dashboards ask for the same few quantiles over and over.
+const queryCacheSize = 8
+
+// queryCache memoizes the results of Quantile and CDF until the digest next
+// changes. Its lock lets concurrent readers, such as those of a
+// ConcurrentTDigest under its read lock, fill it.
+type queryCache struct {
+	mu        sync.Mutex
+	quantiles cachedQueries
+	cdfs      cachedQueries
+}
+
+// cachedQueries holds results by the bits of their argument, replacing the
+// oldest when full.
+type cachedQueries struct {
+	args    [queryCacheSize]uint64
+	results [queryCacheSize]float64
+	n, next int
+}
+
+func (c *cachedQueries) get(arg float64) (float64, bool) {
+	bits := math.Float64bits(arg)
+	for i := 0; i < c.n; i++ {
+		if c.args[i] == bits {
+			return c.results[i], true
+		}
+	}
+	return 0, false
+}
+
+func (c *cachedQueries) put(arg, result float64) {
+	c.args[c.next], c.results[c.next] = math.Float64bits(arg), result
+	c.next = (c.next + 1) % queryCacheSize
+	if c.n < queryCacheSize {
+		c.n++
+	}
+}
+
+// invalidate forgets every cached result.
+func (c *queryCache) invalidate() {
+	c.mu.Lock()
+	c.quantiles = cachedQueries{}
+	c.cdfs = cachedQueries{}
+	c.mu.Unlock()
+}
+
+// quantile returns the cached Quantile(q), computing it with f on a miss.
+func (c *queryCache) quantile(f *FrozenDigest, q float64) (float64, error) {
+	c.mu.Lock()
+	v, ok := c.quantiles.get(q)
+	c.mu.Unlock()
+	if ok {
+		return v, nil
+	}
+	v, err := f.QuantileE(q)
+	if err == nil {
+		c.mu.Lock()
+		c.quantiles.put(q, v)
+		c.mu.Unlock()
+	}
+	return v, err
+}
+
+// cdf returns the cached CDF(x), computing it with f on a miss.
+func (c *queryCache) cdf(f *FrozenDigest, x float64) (float64, error) {
+	c.mu.Lock()
+	v, ok := c.cdfs.get(x)
+	c.mu.Unlock()
+	if ok {
+		return v, nil
+	}
+	v, err := f.CDFE(x)
+	if err == nil {
+		c.mu.Lock()
+		c.cdfs.put(x, v)
+		c.mu.Unlock()
+	}
+	return v, err
+}
@@ -0,0 +1,105 @@
+package tdigest_test
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestTdigest_QueryCache(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	cached := tdigest.NewWithCompression(100, tdigest.WithQueryCache())
+	plain := tdigest.NewWithCompression(100)
+	qs := []float64{0, 0.5, 0.9, 0.99, 0.999, 1}
+	xs := []float64{-1, 0, 0.5, 1, 2}
+	check := func(step string) {
+		t.Helper()
+		// More distinct arguments than the cache holds, asked twice.
+		for i := 0; i < 2; i++ {
+			for _, q := range qs {
+				if got, want := cached.Quantile(q), plain.Quantile(q); got != want {
+					t.Fatalf("%s: Quantile(%g) = %g, want %g", step, q, got, want)
+				}
+			}
+			for _, x := range xs {
+				if got, want := cached.CDF(x), plain.CDF(x); got != want {
+					t.Fatalf("%s: CDF(%g) = %g, want %g", step, x, got, want)
+				}
+			}
+		}
+	}
+
+	// A single repeated value is folded into one processed centroid
+	// without processing.
+	for i := 0; i < 3; i++ {
+		cached.Add(1, 1)
+		plain.Add(1, 1)
+		check("single value")
+	}
+	for i := 0; i < 50; i++ {
+		for j := 0; j < r.Intn(300); j++ {
+			x := r.NormFloat64()
+			cached.Add(x, 1)
+			plain.Add(x, 1)
+		}
+		check("adding")
+	}
+	o := tdigest.NewWithCompression(100)
+	o.AddValues(UniformData[:1000])
+	cached.Merge(o)
+	plain.Merge(o)
+	check("merge")
+	cached.Reset()
+	plain.Reset()
+	cached.Add(5, 1)
+	plain.Add(5, 1)
+	check("reset")
+}
+
+func TestConcurrentTDigest_QueryCache(t *testing.T) {
+	c := tdigest.NewConcurrent(100, tdigest.WithQueryCache())
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				if g == 0 {
+					c.Add(NormalData[i], 1)
+					continue
+				}
+				c.Quantile(0.5)
+				c.CDF(0)
+			}
+		}(g)
+	}
+	wg.Wait()
+	want := tdigest.NewWithCompression(100)
+	want.AddValues(NormalData[:2000])
+	if got := c.Quantile(0.5); got != want.Quantile(0.5) {
+		t.Errorf("Quantile(0.5) = %g, want %g", got, want.Quantile(0.5))
+	}
+}
+
+func BenchmarkTDigest_QueryCache(b *testing.B) {
+	for _, tc := range []struct {
+		name string
+		opts []tdigest.Option
+	}{
+		{"uncached", nil},
+		{"cached", []tdigest.Option{tdigest.WithQueryCache()}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			td := tdigest.NewWithCompression(1000, tc.opts...)
+			td.AddValues(NormalData)
+			b.ReportAllocs()
+			b.ResetTimer()
+			var x float64
+			for n := 0; n < b.N; n++ {
+				x += td.Quantile(0.5) + td.Quantile(0.9) + td.Quantile(0.99) + td.CDF(1)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package tdigest
+
+// CentroidRange is the smallest and largest value merged into a centroid.
+// Digests created WithCentroidRanges keep one for every centroid.
+type CentroidRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// union returns the smallest range containing both r and o.
+func (r CentroidRange) union(o CentroidRange) CentroidRange {
+	if o.Min < r.Min {
+		r.Min = o.Min
+	}
+	if o.Max > r.Max {
+		r.Max = o.Max
+	}
+	return r
+}
+
+// include returns r widened to contain x. The mean of a merged centroid can
+// round to just outside the range of the values merged into it.
+func (r CentroidRange) include(x float64) CentroidRange {
+	return r.union(CentroidRange{x, x})
+}
+
+// CentroidRanges processes pending data and returns the range of each
+// processed centroid, in the order of Centroids, or nil if t was not created
+// WithCentroidRanges. Like Centroids, the list is a read-only view that is
+// only valid until t is next modified.
+func (t *TDigest) CentroidRanges() []CentroidRange {
+	t.Flush()
+	return t.processedRanges
+}
+
+// CentroidRanges returns the range of each centroid of the snapshot, in the
+// order of Centroids, or nil if the digest did not keep them. They must not
+// be modified.
+func (f *FrozenDigest) CentroidRanges() []CentroidRange {
+	return f.ranges
+}
+
+// addCentroidListRanges is addCentroidList for centroids with the ranges r.
+// A nil r gives every centroid the range whole.
+func (t *TDigest) addCentroidListRanges(l CentroidList, r []CentroidRange, whole CentroidRange, count bool) {
+	for len(l) > 0 {
+		n := t.maxUnprocessed + 1 - t.unprocessed.Len()
+		if n > len(l) || n <= 0 {
+			n = len(l)
+		}
+		for i, c := range l[:n] {
+			cr := whole
+			if r != nil {
+				cr = r[i]
+			}
+			if t.appendCentroidRange(c, cr) && count {
+				t.countWeights(c.Weight, 1)
+			}
+		}
+		l = l[n:]
+		if r != nil {
+			r = r[n:]
+		}
+
+		if t.shouldProcess() {
+			t.process()
+		}
+	}
+}
+
+// appendCentroidRange adds c, whose values span r, to the pending data of a
+// digest that keeps ranges. The range is clamped as the mean is and widened
+// to contain it. It reports whether c was added, as appendCentroid does.
+func (t *TDigest) appendCentroidRange(c Centroid, r CentroidRange) bool {
+	mean, ok := t.admit(c.Mean)
+	if !ok || !validWeight(c.Weight) {
+		return t.appendCentroid(c)
+	}
+	if r.Min, ok = t.admit(r.Min); !ok || r.Min > mean {
+		r.Min = mean
+	}
+	if r.Max, ok = t.admit(r.Max); !ok || r.Max < mean {
+		r.Max = mean
+	}
+	n := t.unprocessed.Len()
+	t.appendCentroid(c)
+	switch {
+	case t.unprocessed.Len() > n:
+		t.unprocessedRanges[n] = r
+	case t.unprocessed.Len() > 0:
+		// c was folded into the only centroid, which has the same mean.
+		t.unprocessedRanges[0] = t.unprocessedRanges[0].union(r)
+	default:
+		t.processedRanges[0] = t.processedRanges[0].union(r)
+	}
+	t.updateBounds(r.Min)
+	t.updateBounds(r.Max)
+	return true
+}
+
+// rangedCentroids sorts centroids together with their ranges. Centroids that
+// lessCentroid finds equal are ordered by range, so that the result does not
+// depend on the order they were added in.
+type rangedCentroids struct {
+	c CentroidList
+	r []CentroidRange
+}
+
+func (s rangedCentroids) Len() int { return len(s.c) }
+
+func (s rangedCentroids) Less(i, j int) bool {
+	switch {
+	case lessCentroid(s.c[i], s.c[j]):
+		return true
+	case lessCentroid(s.c[j], s.c[i]):
+		return false
+	case s.r[i].Min != s.r[j].Min:
+		return s.r[i].Min < s.r[j].Min
+	}
+	return s.r[i].Max < s.r[j].Max
+}
+
+func (s rangedCentroids) Swap(i, j int) {
+	s.c[i], s.c[j] = s.c[j], s.c[i]
+	s.r[i], s.r[j] = s.r[j], s.r[i]
+}
+
+// shrinkRanges is shrinkCentroids for a list of ranges.
+func shrinkRanges(r []CentroidRange, size int) []CentroidRange {
+	if size < len(r) {
+		size = len(r)
+	}
+	if cap(r) <= size {
+		return r
+	}
+	shrunk := make([]CentroidRange, len(r), size)
+	copy(shrunk, r)
+	return shrunk
+}
+
+// rangedQuantile interpolates quantile between the centroids lower-1 and
+// lower when their ranges do not overlap, and reports whether they did not.
+// The upper half of the weight of the first is spread from its mean to the
+// top of its range, and the lower half of the weight of the second from the
+// bottom of its range to its mean, leaving the gap between the two ranges
+// empty.
+func (f *FrozenDigest) rangedQuantile(lower int, index float64) (float64, bool) {
+	ra, rb := f.ranges[lower-1], f.ranges[lower]
+	if ra.Max > rb.Min {
+		return 0, false
+	}
+	left, right := f.cumulative[lower-1], f.cumulative[lower]
+	mid := f.weightBefore(lower)
+	if index <= mid {
+		return weightedAverage(f.processed[lower-1].Mean, mid-index, ra.Max, index-left), true
+	}
+	return weightedAverage(rb.Min, right-index, f.processed[lower].Mean, index-mid), true
+}
+
+// rangedCDF is the inverse of rangedQuantile for an x strictly between the
+// means of the centroids upper-1 and upper.
+func (f *FrozenDigest) rangedCDF(upper int, x float64) (float64, bool) {
+	ra, rb := f.ranges[upper-1], f.ranges[upper]
+	if ra.Max > rb.Min {
+		return 0, false
+	}
+	left, right := f.cumulative[upper-1], f.cumulative[upper]
+	mid := f.weightBefore(upper)
+	switch {
+	case x < ra.Max:
+		return weightedAverage(left, ra.Max-x, mid, x-f.processed[upper-1].Mean) / f.count, true
+	case x > rb.Min:
+		return weightedAverage(mid, f.processed[upper].Mean-x, right, x-rb.Min) / f.count, true
+	}
+	return mid / f.count, true
+}
@@ -0,0 +1,148 @@
+package tdigest_test
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+// paretoData returns n values of a Pareto distribution with shape 1.2 in
+// ascending order.
+func paretoData(n int) []float64 {
+	r := rand.New(rand.NewSource(1))
+	xs := make([]float64, n)
+	for i := range xs {
+		xs[i] = math.Pow(1-r.Float64(), -1/1.2)
+	}
+	sort.Float64s(xs)
+	return xs
+}
+
+func TestCentroidRanges_HeavyTail(t *testing.T) {
+	xs := paretoData(1000000)
+	plain := tdigest.NewWithCompression(100)
+	ranged := tdigest.NewWithCompression(100, tdigest.WithCentroidRanges())
+	plain.AddValues(xs)
+	ranged.AddValues(xs)
+
+	for _, q := range []float64{0.99, 0.999} {
+		want := xs[int(q*float64(len(xs)))]
+		p := math.Abs(plain.Quantile(q)-want) / want
+		r := math.Abs(ranged.Quantile(q)-want) / want
+		if r >= p/2 {
+			t.Errorf("q=%g: relative error %g with ranges, %g without", q, r, p)
+		}
+		p = math.Abs(plain.CDF(want) - q)
+		r = math.Abs(ranged.CDF(want) - q)
+		if r >= p {
+			t.Errorf("CDF at q=%g: error %g with ranges, %g without", q, r, p)
+		}
+	}
+}
+
+func TestCentroidRanges(t *testing.T) {
+	td := tdigest.NewWithCompression(100, tdigest.WithCentroidRanges())
+	td.AddValues(NormalData[:10000])
+	cs, rs := td.Centroids(), td.CentroidRanges()
+	if len(rs) != len(cs) {
+		t.Fatalf("%d ranges for %d centroids", len(rs), len(cs))
+	}
+	if rs[0].Min != td.Min() || rs[len(rs)-1].Max != td.Max() {
+		t.Errorf("ranges %v and %v do not reach min %g and max %g", rs[0], rs[len(rs)-1], td.Min(), td.Max())
+	}
+	for i, r := range rs {
+		if cs[i].Weight == 1 && (r.Min != cs[i].Mean || r.Max != cs[i].Mean) {
+			t.Errorf("single value centroid %v has range %v", cs[i], r)
+		}
+	}
+	if got := td.Snapshot().CentroidRanges(); !reflect.DeepEqual(got, rs) {
+		t.Error("snapshot ranges differ")
+	}
+	if rs := tdigest.New().CentroidRanges(); rs != nil {
+		t.Errorf("digest without ranges returned %v", rs)
+	}
+
+	// Random order leaves the ranges of neighbours overlapping, so the
+	// quantiles are those of a digest without ranges.
+	plain := tdigest.NewWithCompression(100)
+	plain.AddValues(NormalData[:10000])
+	for _, q := range []float64{0.01, 0.5, 0.99} {
+		if got, want := td.Quantile(q), plain.Quantile(q); got != want {
+			t.Errorf("Quantile(%g) = %g, want %g", q, got, want)
+		}
+	}
+}
+
+func TestCentroidRanges_Merge(t *testing.T) {
+	xs := paretoData(100000)
+	a := tdigest.NewWithCompression(100, tdigest.WithCentroidRanges())
+	b := tdigest.NewWithCompression(100, tdigest.WithCentroidRanges())
+	a.AddValues(xs[:50000])
+	b.AddValues(xs[50000:])
+	a.Merge(b)
+	a.Merge(a)
+	if err := a.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	if a.Count() != 200000 {
+		t.Errorf("count %g, want 200000", a.Count())
+	}
+
+	// Centroids of a digest without ranges span all of its values.
+	c := tdigest.NewWithCompression(100, tdigest.WithCentroidRanges())
+	plain := tdigest.NewWithCompression(100)
+	plain.AddValues([]float64{1, 2, 3})
+	plain.AddCentroid(tdigest.Centroid{Mean: 2, Weight: 5})
+	c.Merge(plain)
+	for _, r := range c.CentroidRanges() {
+		if r.Min != 1 || r.Max != 3 {
+			t.Errorf("range %v, want [1, 3]", r)
+		}
+	}
+}
+
+func TestCentroidRanges_MarshalBinary(t *testing.T) {
+	td := tdigest.NewWithCompression(100, tdigest.WithCentroidRanges())
+	td.AddValues(paretoData(10000))
+	back, err := tdigest.FromBytes(mustMarshal(t, td))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back.Centroids(), td.Centroids()) || !reflect.DeepEqual(back.CentroidRanges(), td.CentroidRanges()) {
+		t.Error("centroids or ranges differ after a round trip")
+	}
+	if back.Quantile(0.999) != td.Quantile(0.999) {
+		t.Errorf("Quantile(0.999) = %g, want %g", back.Quantile(0.999), td.Quantile(0.999))
+	}
+
+	// Data without ranges gives every centroid the whole range.
+	plain := tdigest.NewWithCompression(100)
+	plain.AddValues([]float64{1, 2, 3})
+	ranged, err := tdigest.FromBytes(mustMarshal(t, plain), tdigest.WithCentroidRanges())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range ranged.CentroidRanges() {
+		if r.Min != 1 || r.Max != 3 {
+			t.Errorf("range %v, want [1, 3]", r)
+		}
+	}
+}
+
+func TestCentroidRanges_FromBytesInvalid(t *testing.T) {
+	td := tdigest.NewWithCompression(100, tdigest.WithCentroidRanges())
+	td.AddValues([]float64{1, 2, 3})
+	b := mustMarshal(t, td)
+	// Make the maximum of the last centroid 0, below its mean of 3.
+	for i := len(b) - 8; i < len(b); i++ {
+		b[i] = 0
+	}
+	if _, err := tdigest.FromBytes(b); !errors.Is(err, tdigest.ErrInvalidEncoding) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
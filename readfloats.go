@@ -0,0 +1,114 @@
+package tdigest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// maxFloatToken is the longest token ReadFloats reads. Longer ones fail the
+// read rather than being buffered.
+const maxFloatToken = 1 << 16
+
+// readBatch is the number of values ReadFloats passes to AddValues at once.
+const readBatch = 4096
+
+// MalformedError describes the tokens ReadFloats could not parse as numbers.
+type MalformedError struct {
+	Line  int64  // line of the first malformed token, counting from 1
+	Token string // the first malformed token
+	Count int64  // number of malformed tokens
+}
+
+func (e *MalformedError) Error() string {
+	if e.Count > 1 {
+		return fmt.Sprintf("line %d: malformed number %q, and %d more", e.Line, e.Token, e.Count-1)
+	}
+	return fmt.Sprintf("line %d: malformed number %q", e.Line, e.Token)
+}
+
+// ReadFloats adds the numbers read from r to t, each with a weight of 1, and
+// returns how many it read. Numbers are separated by whitespace or commas,
+// and may be anything strconv.ParseFloat accepts; values t does not accept,
+// such as NaN, are dropped as by Add. It reads through a bounded buffer and
+// adds values in batches, so it suits inputs of any size.
+//
+// Tokens that are not numbers are skipped, and if there were any the error
+// is a *MalformedError counting them once r is exhausted. Other errors are
+// those of reading r, or bufio.ErrTooLong for a token over 64KiB.
+func ReadFloats(r io.Reader, t *TDigest) (n int64, err error) {
+	return readFloats(r, t, false)
+}
+
+// ReadFloatsStrict is like ReadFloats but stops at the first token that is
+// not a number, returning a *MalformedError for it. The numbers before it
+// have been added.
+func ReadFloatsStrict(r io.Reader, t *TDigest) (n int64, err error) {
+	return readFloats(r, t, true)
+}
+
+func readFloats(r io.Reader, t *TDigest, strict bool) (int64, error) {
+	var (
+		line      int64 = 1
+		tokenLine int64
+		n         int64
+		malformed *MalformedError
+		batch     = make([]float64, 0, readBatch)
+	)
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64<<10), maxFloatToken)
+	s.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		start := 0
+		for ; start < len(data) && isFloatSeparator(data[start]); start++ {
+			if data[start] == '\n' {
+				line++
+			}
+		}
+		for i := start; i < len(data); i++ {
+			if isFloatSeparator(data[i]) {
+				tokenLine = line
+				return i, data[start:i], nil
+			}
+		}
+		if atEOF && start < len(data) {
+			tokenLine = line
+			return len(data), data[start:], nil
+		}
+		return start, nil, nil
+	})
+	for s.Scan() {
+		x, err := strconv.ParseFloat(s.Text(), 64)
+		if err != nil {
+			if malformed == nil {
+				malformed = &MalformedError{Line: tokenLine, Token: s.Text()}
+			}
+			malformed.Count++
+			if strict {
+				break
+			}
+			continue
+		}
+		if batch = append(batch, x); len(batch) == cap(batch) {
+			t.AddValues(batch)
+			batch = batch[:0]
+		}
+		n++
+	}
+	t.AddValues(batch)
+	if err := s.Err(); err != nil {
+		return n, err
+	}
+	if malformed != nil {
+		return n, malformed
+	}
+	return n, nil
+}
+
+func isFloatSeparator(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '\v', '\f', ',':
+		return true
+	}
+	return false
+}
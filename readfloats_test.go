@@ -0,0 +1,91 @@
+package tdigest_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestReadFloats(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	n, err := tdigest.ReadFloats(strings.NewReader("1 2,3\n\n\t4e0,,5\r\n-6 NaN\n"), td)
+	if err != nil || n != 7 {
+		t.Fatalf("read %d values, %v", n, err)
+	}
+	if td.Count() != 6 || td.Min() != -6 || td.Max() != 5 || td.Dropped() != 1 {
+		t.Errorf("unexpected digest %v", td)
+	}
+}
+
+func TestReadFloats_Malformed(t *testing.T) {
+	input := "1 2\nx 3\n\n4,5y,6\nz"
+
+	td := tdigest.NewWithCompression(100)
+	n, err := tdigest.ReadFloats(strings.NewReader(input), td)
+	var malformed *tdigest.MalformedError
+	if !errors.As(err, &malformed) {
+		t.Fatalf("got error %v, want a MalformedError", err)
+	}
+	want := tdigest.MalformedError{Line: 2, Token: "x", Count: 3}
+	if *malformed != want || n != 5 || td.Count() != 5 {
+		t.Errorf("got %+v after %d values, want %+v after 5", *malformed, n, want)
+	}
+	if got := err.Error(); got != `line 2: malformed number "x", and 2 more` {
+		t.Errorf("unexpected message %q", got)
+	}
+
+	td = tdigest.NewWithCompression(100)
+	n, err = tdigest.ReadFloatsStrict(strings.NewReader(input), td)
+	if !errors.As(err, &malformed) || *malformed != (tdigest.MalformedError{Line: 2, Token: "x", Count: 1}) {
+		t.Errorf("got error %v", err)
+	}
+	if n != 2 || td.Count() != 2 {
+		t.Errorf("read %d values into a digest of %v before failing, want 2", n, td.Count())
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestReadFloats_Errors(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	r := io.MultiReader(strings.NewReader("1 2 "), failingReader{})
+	if n, err := tdigest.ReadFloats(r, td); err != io.ErrUnexpectedEOF || n != 2 || td.Count() != 2 {
+		t.Errorf("read %d values, %v", n, err)
+	}
+
+	long := strings.Repeat("1", 1<<17)
+	if _, err := tdigest.ReadFloats(strings.NewReader(long), td); err != bufio.ErrTooLong {
+		t.Errorf("got %v, want ErrTooLong", err)
+	}
+}
+
+func BenchmarkReadFloats(b *testing.B) {
+	// 10^7 numbers, made of a chunk of 10^5 repeated.
+	var chunk []byte
+	for _, x := range NormalData[:100000] {
+		chunk = strconv.AppendFloat(chunk, x, 'f', 3, 64)
+		chunk = append(chunk, '\n')
+	}
+	b.SetBytes(100 * int64(len(chunk)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		readers := make([]io.Reader, 100)
+		for j := range readers {
+			readers[j] = bytes.NewReader(chunk)
+		}
+		td := tdigest.NewWithCompression(100)
+		if n, err := tdigest.ReadFloats(io.MultiReader(readers...), td); err != nil || n != 1e7 {
+			b.Fatal(n, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package tdigest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Snapshotter is a digest that can be snapshotted while it is being written,
+// such as a *ConcurrentTDigest.
+type Snapshotter interface {
+	Snapshot() *FrozenDigest
+}
+
+// Registry is a set of named digests, such as those Handler serves. It is
+// safe for concurrent use.
+type Registry struct {
+	mu      sync.RWMutex
+	digests map[string]Snapshotter
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{digests: make(map[string]Snapshotter)}
+}
+
+// Register adds s to the registry under name. It returns an error if the
+// name is already in use.
+func (r *Registry) Register(name string, s Snapshotter) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.digests[name]; ok {
+		return fmt.Errorf("tdigest: digest %q is already registered", name)
+	}
+	r.digests[name] = s
+	return nil
+}
+
+// RegisterPublished adds t to the registry under name, reading the snapshots
+// last published by t.Publish so that t is never locked.
+func (r *Registry) RegisterPublished(name string, t *TDigest) error {
+	return r.Register(name, published{t})
+}
+
+type published struct {
+	t *TDigest
+}
+
+func (p published) Snapshot() *FrozenDigest {
+	return p.t.Published()
+}
+
+// Unregister removes the digest registered under name, if any.
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.digests, name)
+}
+
+// Get returns the digest registered under name and whether there is one.
+func (r *Registry) Get(name string) (Snapshotter, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.digests[name]
+	return s, ok
+}
+
+// Names returns the sorted names of the registered digests.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.digests))
+	for name := range r.digests {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
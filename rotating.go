@@ -0,0 +1,107 @@
+package tdigest
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// RotatingTDigest is a digest for scrape-and-clear use that keeps writers
+// running while a scrape takes place. Data is added to one of two internal
+// digests; Rotate makes the other one active and returns a snapshot of the
+// one it replaced, so the cost of the snapshot is paid away from writers.
+//
+// An Add costs one atomic load more than on a ConcurrentTDigest.
+type RotatingTDigest struct {
+	active atomic.Value // *rotatingBuffer
+	bufs   [2]rotatingBuffer
+
+	mu sync.Mutex // serializes Rotate
+}
+
+type rotatingBuffer struct {
+	mu      sync.Mutex
+	t       *TDigest
+	retired bool // the buffer is no longer active; writers must reload it
+}
+
+// NewRotating returns a RotatingTDigest whose two digests are created with the
+// compression and options given.
+func NewRotating(compression float64, opts ...Option) *RotatingTDigest {
+	r := new(RotatingTDigest)
+	for i := range r.bufs {
+		r.bufs[i].t = NewWithCompression(compression, opts...)
+		r.bufs[i].t.queueHooks = true
+	}
+	r.bufs[1].retired = true
+	r.active.Store(&r.bufs[0])
+	return r
+}
+
+// lock locks and returns the active buffer.
+func (r *RotatingTDigest) lock() *rotatingBuffer {
+	for {
+		b := r.active.Load().(*rotatingBuffer)
+		b.mu.Lock()
+		if !b.retired {
+			return b
+		}
+		// Rotate retired b after it was loaded.
+		b.mu.Unlock()
+	}
+}
+
+func (r *RotatingTDigest) Add(x, w float64) {
+	b := r.lock()
+	b.t.Add(x, w)
+	b.unlock()
+}
+
+func (r *RotatingTDigest) AddValues(xs []float64) {
+	b := r.lock()
+	b.t.AddValues(xs)
+	b.unlock()
+}
+
+func (r *RotatingTDigest) AddCentroid(c Centroid) {
+	b := r.lock()
+	b.t.AddCentroid(c)
+	b.unlock()
+}
+
+// unlock releases the lock of b and then runs the hooks triggered while it
+// was held.
+func (b *rotatingBuffer) unlock() {
+	events := b.t.takeHookEvents()
+	b.mu.Unlock()
+	b.t.runHooks(events)
+}
+
+// Rotate switches writers to the other digest and returns a snapshot of the
+// data added since the previous Rotate. Rotate waits for adds that are in
+// progress on the digest it drains, but later adds go to the new active
+// digest without waiting for the snapshot to be taken. The drained digest
+// is then reset, keeping its buffers for the next rotation.
+func (r *RotatingTDigest) Rotate() *FrozenDigest {
+	r.mu.Lock()
+
+	old := r.active.Load().(*rotatingBuffer)
+	next := &r.bufs[0]
+	if old == next {
+		next = &r.bufs[1]
+	}
+	next.mu.Lock()
+	next.retired = false
+	next.mu.Unlock()
+	r.active.Store(next)
+
+	// Once old is retired no writer modifies it, so the snapshot can be
+	// taken without holding its lock.
+	old.mu.Lock()
+	old.retired = true
+	old.mu.Unlock()
+	f := old.t.SnapshotAndReset()
+	events := old.t.takeHookEvents()
+	r.mu.Unlock()
+	old.t.runHooks(events)
+	return f
+}
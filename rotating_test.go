@@ -0,0 +1,65 @@
+package tdigest_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestRotatingTDigest(t *testing.T) {
+	const writers, values, rotations = 4, 20000, 1000
+	td := tdigest.NewRotating(100)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			data := NormalData[w*values : (w+1)*values]
+			for i, x := range data {
+				if i%2 == 0 {
+					td.Add(x, 1)
+				} else {
+					td.AddCentroid(tdigest.Centroid{Mean: x, Weight: 1})
+				}
+			}
+		}(w)
+	}
+
+	var total float64
+	for i := 0; i < rotations; i++ {
+		total += td.Rotate().Count()
+	}
+	wg.Wait()
+	total += td.Rotate().Count()
+	if want := float64(writers * values); total != want {
+		t.Errorf("unexpected total count across rotations, got %g want %g", total, want)
+	}
+	if got := td.Rotate().Count(); got != 0 {
+		t.Errorf("unexpected count after final rotation: %g", got)
+	}
+
+	td.AddValues(UniformData[:1000])
+	f := td.Rotate()
+	if got, want := f.Count(), 1000.0; got != want {
+		t.Errorf("unexpected count, got %g want %g", got, want)
+	}
+	if q := f.Quantile(0.5); q < 45 || q > 55 {
+		t.Errorf("unexpected median %g", q)
+	}
+}
+
+func BenchmarkRotatingTDigest_Add(b *testing.B) {
+	b.Run("ConcurrentTDigest", func(b *testing.B) {
+		td := tdigest.NewConcurrent(1000)
+		for i := 0; i < b.N; i++ {
+			td.Add(NormalData[i%len(NormalData)], 1)
+		}
+	})
+	b.Run("RotatingTDigest", func(b *testing.B) {
+		td := tdigest.NewRotating(1000)
+		for i := 0; i < b.N; i++ {
+			td.Add(NormalData[i%len(NormalData)], 1)
+		}
+	})
+}
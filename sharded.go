@@ -0,0 +1,157 @@
+package tdigest
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// ShardedTDigest spreads ingestion from many goroutines over independent
+// digests, each behind its own lock, so that writers rarely contend.
+//
+// Queries are answered from a merge of all shards. The merge is redone on
+// demand when any shard has changed since the last one, so a query reflects
+// every Add that completed before it started. Shards are locked one after
+// another while merging, so data added concurrently with a query may or may
+// not be included.
+type ShardedTDigest struct {
+	compression float64
+	opts        []Option
+	shards      []shard
+	next        uint32
+
+	mu     sync.Mutex // guards merged and serializes merging
+	merged *TDigest
+}
+
+// cacheLineSize is the size of a cache line on common hardware.
+const cacheLineSize = 64
+
+type shardState struct {
+	mu    sync.Mutex
+	t     *TDigest
+	dirty bool
+}
+
+// shard pads shardState to a multiple of the cache line size, so that
+// writers locking neighbouring shards do not share a line.
+type shard struct {
+	shardState
+	_ [cacheLineSize - unsafe.Sizeof(shardState{})%cacheLineSize]byte
+}
+
+// NewSharded returns a ShardedTDigest with the given number of shards, each
+// created with the compression and options given. A shards value of zero or
+// less uses GOMAXPROCS.
+func NewSharded(shards int, compression float64, opts ...Option) *ShardedTDigest {
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+	s := &ShardedTDigest{
+		compression: compression,
+		opts:        opts,
+		shards:      make([]shard, shards),
+	}
+	for i := range s.shards {
+		s.shards[i].t = NewWithCompression(compression, opts...)
+		s.shards[i].t.queueHooks = true
+	}
+	return s
+}
+
+// Shards returns the number of shards.
+func (s *ShardedTDigest) Shards() int {
+	return len(s.shards)
+}
+
+// Add adds x with weight w to the next shard in round-robin order.
+func (s *ShardedTDigest) Add(x, w float64) {
+	i := atomic.AddUint32(&s.next, 1)
+	s.AddToShard(int(i%uint32(len(s.shards))), x, w)
+}
+
+// AddToShard adds x with weight w to the given shard. Producers that each
+// use their own shard avoid contending with each other entirely.
+func (s *ShardedTDigest) AddToShard(i int, x, w float64) {
+	sh := &s.shards[i]
+	sh.mu.Lock()
+	sh.t.Add(x, w)
+	sh.dirty = true
+	events := sh.t.takeHookEvents()
+	sh.mu.Unlock()
+	sh.t.runHooks(events)
+}
+
+// Flush merges all shards for subsequent queries.
+func (s *ShardedTDigest) Flush() {
+	s.mu.Lock()
+	s.flush()
+	s.mu.Unlock()
+}
+
+func (s *ShardedTDigest) flush() {
+	dirty := s.merged == nil
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		dirty = dirty || sh.dirty
+		sh.mu.Unlock()
+	}
+	if !dirty {
+		return
+	}
+	merged := NewWithCompression(s.compression, s.opts...)
+	merged.onProcess, merged.onDrop = nil, nil
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		merged.Merge(sh.t)
+		sh.dirty = false
+		sh.mu.Unlock()
+	}
+	merged.Flush()
+	s.merged = merged
+}
+
+func (s *ShardedTDigest) Quantile(q float64) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flush()
+	return s.merged.Quantile(q)
+}
+
+func (s *ShardedTDigest) CDF(x float64) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flush()
+	return s.merged.CDF(x)
+}
+
+func (s *ShardedTDigest) Count() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flush()
+	return s.merged.Count()
+}
+
+// Merged returns a new digest with the data of all shards.
+func (s *ShardedTDigest) Merged() *TDigest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flush()
+	return MergeAll(s.merged)
+}
+
+// Reset clears all shards.
+func (s *ShardedTDigest) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		sh.t.Reset()
+		sh.dirty = true
+		sh.mu.Unlock()
+	}
+}
@@ -0,0 +1,115 @@
+package tdigest_test
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"unsafe"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestShardedTDigest(t *testing.T) {
+	const producers, values = 8, 10000
+	s := tdigest.NewSharded(4, 100)
+	if s.Shards() != 4 {
+		t.Fatalf("unexpected number of shards: %d", s.Shards())
+	}
+
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i, x := range NormalData[p*values : (p+1)*values] {
+				if i%2 == 0 {
+					s.Add(x, 1)
+				} else {
+					s.AddToShard(p%s.Shards(), x, 1)
+				}
+				if i%1000 == 0 {
+					if q := s.Quantile(0.5); q < 5 || q > 15 {
+						t.Errorf("unexpected median %g", q)
+					}
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	want := tdigest.NewWithCompression(100)
+	want.AddValues(NormalData[:producers*values])
+	if got := s.Count(); got != want.Count() {
+		t.Errorf("unexpected count, got %g want %g", got, want.Count())
+	}
+	for _, q := range []float64{0.01, 0.5, 0.99} {
+		if got, want := s.Quantile(q), want.Quantile(q); math.Abs(got-want) > 0.05 {
+			t.Errorf("unexpected quantile %g, got %g want %g", q, got, want)
+		}
+	}
+	if got, want := s.CDF(10), want.CDF(10); math.Abs(got-want) > 0.01 {
+		t.Errorf("unexpected CDF, got %g want %g", got, want)
+	}
+	if got := s.Merged().Count(); got != want.Count() {
+		t.Errorf("unexpected merged count, got %g want %g", got, want.Count())
+	}
+
+	s.Reset()
+	if got := s.Count(); got != 0 {
+		t.Errorf("unexpected count after reset: %g", got)
+	}
+}
+
+func BenchmarkShardedTDigest_Add(b *testing.B) {
+	b.Run("ConcurrentTDigest", func(b *testing.B) {
+		td := tdigest.NewConcurrent(1000)
+		b.SetParallelism(16)
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				td.Add(NormalData[i%len(NormalData)], 1)
+			}
+		})
+	})
+	b.Run("ShardedTDigest", func(b *testing.B) {
+		td := tdigest.NewSharded(0, 1000)
+		b.SetParallelism(16)
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				td.Add(NormalData[i%len(NormalData)], 1)
+			}
+		})
+	})
+}
+
+func TestShardedTDigest_ShardSize(t *testing.T) {
+	if size := unsafe.Sizeof(tdigest.Shard{}); size%tdigest.CacheLineSize != 0 {
+		t.Errorf("shards take %d bytes, not a multiple of %d", size, tdigest.CacheLineSize)
+	}
+}
+
+// BenchmarkShardedTDigest_Padding locks neighbouring shards from separate
+// goroutines, one shard each. Without padding, shards share cache lines and
+// every lock invalidates the line of the neighbours, which shows with
+// several CPUs.
+func BenchmarkShardedTDigest_Padding(b *testing.B) {
+	run := func(b *testing.B, shard func(i int) interface{ Touch() }) {
+		var next uint32
+		b.RunParallel(func(pb *testing.PB) {
+			sh := shard(int(atomic.AddUint32(&next, 1) - 1))
+			for pb.Next() {
+				sh.Touch()
+			}
+		})
+	}
+	n := runtime.GOMAXPROCS(0)
+	b.Run("Unpadded", func(b *testing.B) {
+		shards := make([]tdigest.ShardState, n)
+		run(b, func(i int) interface{ Touch() } { return &shards[i] })
+	})
+	b.Run("Padded", func(b *testing.B) {
+		shards := make([]tdigest.Shard, n)
+		run(b, func(i int) interface{ Touch() } { return &shards[i] })
+	})
+}
@@ -0,0 +1,40 @@
+//go:build go1.21
+// +build go1.21
+
+package tdigest
+
+import "log/slog"
+
+// LogValue implements slog.LogValuer, so that a digest passed to a logger
+// is logged as a group of its count, min, max, p50, p95 and p99. Like
+// Quantile, it processes pending data, so it must not be called while t is
+// being modified.
+func (t *TDigest) LogValue() slog.Value {
+	t.Flush()
+	f := t.frozen()
+	return f.LogValue()
+}
+
+// LogValue is like TDigest.LogValue, computing the values under the lock so
+// that they are consistent.
+func (c *ConcurrentTDigest) LogValue() slog.Value {
+	c.mu.Lock()
+	defer c.unlock()
+	return c.t.LogValue()
+}
+
+// LogValue implements slog.LogValuer as TDigest.LogValue does. An empty
+// snapshot is logged as just count=0.
+func (f *FrozenDigest) LogValue() slog.Value {
+	if f.processed.Len() == 0 {
+		return slog.GroupValue(slog.Float64("count", 0))
+	}
+	return slog.GroupValue(
+		slog.Float64("count", f.count),
+		slog.Float64("min", f.min),
+		slog.Float64("max", f.max),
+		slog.Float64("p50", f.Quantile(0.5)),
+		slog.Float64("p95", f.Quantile(0.95)),
+		slog.Float64("p99", f.Quantile(0.99)),
+	)
+}
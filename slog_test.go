@@ -0,0 +1,62 @@
+//go:build go1.21
+// +build go1.21
+
+package tdigest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func logJSON(t *testing.T, v interface{}) map[string]interface{} {
+	t.Helper()
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Info("batch done", "latency", v)
+	var entry struct {
+		Latency map[string]interface{} `json:"latency"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("%v in %s", err, buf.Bytes())
+	}
+	return entry.Latency
+}
+
+func TestTdigest_LogValue(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	if got := logJSON(t, td); len(got) != 1 || got["count"] != 0.0 {
+		t.Errorf("empty digest logged as %v", got)
+	}
+
+	for i := 1; i <= 100; i++ {
+		td.Add(float64(i), 1)
+	}
+	want := map[string]interface{}{
+		"count": 100.0,
+		"min":   1.0,
+		"max":   100.0,
+		"p50":   td.Quantile(0.5),
+		"p95":   td.Quantile(0.95),
+		"p99":   td.Quantile(0.99),
+	}
+	for _, v := range []interface{}{td, td.Snapshot()} {
+		got := logJSON(t, v)
+		if len(got) != len(want) {
+			t.Errorf("logged %v, want %v", got, want)
+		}
+		for k, w := range want {
+			if got[k] != w {
+				t.Errorf("%s: logged %v, want %v", k, got[k], w)
+			}
+		}
+	}
+
+	c := tdigest.NewConcurrent(100)
+	c.Add(5, 1)
+	if got := logJSON(t, c); got["p50"] != 5.0 {
+		t.Errorf("concurrent digest logged as %v", got)
+	}
+}
@@ -0,0 +1,181 @@
+package tdigest
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshotTimeFormat is the format of the timestamps in the names of the
+// files a SnapshotWriter writes.
+const snapshotTimeFormat = "20060102T150405"
+
+// SnapshotWriter periodically saves the digests of a Registry to files in a
+// directory, keeping a rolling history of them for later inspection. Each
+// snapshot of a digest is saved with SaveFile to a file named by the name of
+// the digest and the UTC time, such as latency-20240101T120000.td, and can
+// be read back with LoadFile.
+//
+// The fields must not be changed while the writer is started.
+type SnapshotWriter struct {
+	// Dir is the directory to write to. It must exist.
+	Dir string
+
+	// Registry holds the digests to save.
+	Registry *Registry
+
+	// Names are the names of the digests to save. Empty means all of
+	// those registered.
+	Names []string
+
+	// Interval is the time between snapshots.
+	Interval time.Duration
+
+	// MaxFiles is the number of files kept for each digest, the oldest
+	// being removed after each snapshot. Zero keeps every file.
+	MaxFiles int
+
+	// OnError, if not nil, is called with each error of writing or
+	// removing files in the background.
+	OnError func(error)
+
+	now func() time.Time
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Start starts writing a snapshot every Interval. It returns an error if the
+// writer is already started or Interval is not positive.
+func (w *SnapshotWriter) Start() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stop != nil {
+		return errors.New("tdigest: snapshot writer already started")
+	}
+	if w.Interval <= 0 {
+		return fmt.Errorf("tdigest: invalid snapshot interval %v", w.Interval)
+	}
+	w.stop, w.done = make(chan struct{}), make(chan struct{})
+	go w.run(w.stop, w.done)
+	return nil
+}
+
+func (w *SnapshotWriter) run(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.write(w.report)
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (w *SnapshotWriter) report(err error) {
+	if w.OnError != nil {
+		w.OnError(err)
+	}
+}
+
+// Stop stops writing snapshots, waiting for one in progress to finish. The
+// writer may be started again.
+func (w *SnapshotWriter) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stop == nil {
+		return
+	}
+	close(w.stop)
+	<-w.done
+	w.stop, w.done = nil, nil
+}
+
+// WriteNow writes a snapshot of each digest and removes old files, as the
+// writer does every Interval. It carries on past errors and returns the
+// first.
+func (w *SnapshotWriter) WriteNow() error {
+	var first error
+	w.write(func(err error) {
+		if first == nil {
+			first = err
+		}
+	})
+	return first
+}
+
+// write saves each digest and prunes its files, passing errors to report.
+func (w *SnapshotWriter) write(report func(error)) {
+	now := time.Now
+	if w.now != nil {
+		now = w.now
+	}
+	stamp := now().UTC().Format(snapshotTimeFormat)
+	names := w.Names
+	if len(names) == 0 {
+		names = w.Registry.Names()
+	}
+	for _, name := range names {
+		s, ok := w.Registry.Get(name)
+		if !ok {
+			report(fmt.Errorf("tdigest: no digest %q", name))
+			continue
+		}
+		prefix := snapshotPrefix(name)
+		if err := s.Snapshot().SaveFile(filepath.Join(w.Dir, prefix+stamp+".td")); err != nil {
+			report(err)
+			continue
+		}
+		if w.MaxFiles > 0 {
+			if err := w.prune(prefix); err != nil {
+				report(err)
+			}
+		}
+	}
+}
+
+// prune removes all but the newest MaxFiles snapshots whose names start
+// with prefix.
+func (w *SnapshotWriter) prune(prefix string) error {
+	infos, err := ioutil.ReadDir(w.Dir)
+	if err != nil {
+		return err
+	}
+	var files []string
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".td") {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".td")
+		if _, err := time.Parse(snapshotTimeFormat, stamp); err == nil {
+			files = append(files, name)
+		}
+	}
+	// The timestamps sort in time order.
+	sort.Strings(files)
+	var first error
+	for len(files) > w.MaxFiles {
+		if err := os.Remove(filepath.Join(w.Dir, files[0])); err != nil && first == nil {
+			first = err
+		}
+		files = files[1:]
+	}
+	return first
+}
+
+// snapshotPrefix returns the start of the names of the files of the digest
+// name, with path separators replaced so that the files stay in Dir.
+func snapshotPrefix(name string) string {
+	return strings.NewReplacer("/", "_", `\`, "_").Replace(name) + "-"
+}
@@ -0,0 +1,126 @@
+package tdigest_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/tdigest"
+)
+
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	return names
+}
+
+func TestSnapshotWriter_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	r := tdigest.NewRegistry()
+	latency := tdigest.NewConcurrent(100)
+	r.Register("latency", latency)
+	r.Register("a/b", tdigest.NewConcurrent(50))
+	r.Register("ignored", tdigest.NewConcurrent(50))
+	// A file of another digest whose name shares the prefix must be kept.
+	if err := ioutil.WriteFile(filepath.Join(dir, "latency-x-20000101T000000.td"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &tdigest.SnapshotWriter{
+		Dir:      dir,
+		Registry: r,
+		Names:    []string{"latency", "a/b"},
+		MaxFiles: 2,
+	}
+	clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	w.SetClock(func() time.Time { return clock })
+	counts := map[string]float64{}
+	for i := 0; i < 4; i++ {
+		latency.AddValues(NormalData[i*1000 : (i+1)*1000])
+		if err := w.WriteNow(); err != nil {
+			t.Fatal(err)
+		}
+		counts[clock.Format("20060102T150405")] = latency.Count()
+		clock = clock.Add(time.Minute)
+	}
+
+	want := []string{
+		"a_b-20240101T120200.td",
+		"a_b-20240101T120300.td",
+		"latency-20240101T120200.td",
+		"latency-20240101T120300.td",
+		"latency-x-20000101T000000.td",
+	}
+	if got := listDir(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+	for _, stamp := range []string{"20240101T120200", "20240101T120300"} {
+		td, err := tdigest.LoadFile(filepath.Join(dir, "latency-"+stamp+".td"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if td.Count() != counts[stamp] || td.Compression() != 100 {
+			t.Errorf("%s: loaded count %v and compression %v, want %v and 100", stamp, td.Count(), td.Compression(), counts[stamp])
+		}
+	}
+	if td, err := tdigest.LoadFile(filepath.Join(dir, "a_b-20240101T120300.td")); err != nil || td.Count() != 0 {
+		t.Errorf("unexpected empty digest %v, %v", td, err)
+	}
+}
+
+func TestSnapshotWriter_StartStop(t *testing.T) {
+	dir := t.TempDir()
+	r := tdigest.NewRegistry()
+	r.Register("latency", tdigest.NewConcurrent(100))
+	errs := make(chan error, 100)
+	w := &tdigest.SnapshotWriter{
+		Dir:      dir,
+		Registry: r,
+		Interval: time.Millisecond,
+		OnError:  func(err error) { errs <- err },
+	}
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Start(); err == nil {
+		t.Error("second Start succeeded")
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(listDir(t, dir)) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("no snapshot written")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	w.Stop()
+	w.Stop()
+	select {
+	case err := <-errs:
+		t.Errorf("unexpected error %v", err)
+	default:
+	}
+
+	// Errors are reported rather than stopping the writer.
+	w.Dir = filepath.Join(dir, "missing")
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Error("no error reported")
+	}
+	w.Stop()
+
+	if err := (&tdigest.SnapshotWriter{Registry: r}).Start(); err == nil {
+		t.Error("Start without an interval succeeded")
+	}
+}
@@ -0,0 +1,107 @@
+package tdigest
+
+import (
+	"time"
+	"unsafe"
+)
+
+// Stats describes the internal state of a digest.
+type Stats struct {
+	// Processed and Unprocessed are the number of compressed and buffered
+	// centroids.
+	Processed   int
+	Unprocessed int
+
+	// The capacities of the internal buffers, in elements.
+	ProcessedCap   int
+	UnprocessedCap int
+	ScratchCap     int
+	CumulativeCap  int
+
+	// Processes is the number of times pending data has been processed,
+	// and ProcessTime the time spent doing so.
+	Processes   uint64
+	ProcessTime time.Duration
+
+	// Adds is the number of values and centroids offered to the digest,
+	// including those merged in and those dropped, and WeightAdded the
+	// total weight of those that were not dropped.
+	Adds        uint64
+	WeightAdded float64
+
+	// Dropped is the number of values dropped, as returned by Dropped.
+	Dropped uint64
+
+	// UnprocessedGrowths is the number of times the buffer of pending data
+	// had to grow to take another centroid.
+	UnprocessedGrowths uint64
+}
+
+// Stats returns the current Stats of t. It does not process pending data.
+// The counters start over on Reset unless t was created with
+// WithPersistentStats.
+func (t *TDigest) Stats() Stats {
+	return Stats{
+		Processed:          t.processed.Len(),
+		Unprocessed:        t.unprocessed.Len(),
+		ProcessedCap:       cap(t.processed),
+		UnprocessedCap:     cap(t.unprocessed),
+		ScratchCap:         cap(t.scratch),
+		CumulativeCap:      cap(t.cumulative),
+		Processes:          t.processes,
+		ProcessTime:        t.processTime,
+		Adds:               t.adds,
+		WeightAdded:        t.weightAdded.value(),
+		Dropped:            t.dropped,
+		UnprocessedGrowths: t.growths,
+	}
+}
+
+var (
+	digestSize   = int(unsafe.Sizeof(TDigest{}))
+	centroidSize = int(unsafe.Sizeof(Centroid{}))
+	rangeSize    = int(unsafe.Sizeof(CentroidRange{}))
+	float64Size  = int(unsafe.Sizeof(float64(0)))
+)
+
+// ByteSize returns the approximate number of bytes of memory held by t. It
+// counts the capacity of the internal buffers rather than their length, so
+// memory retained after a burst of ingestion is included, as are the ranges
+// of a digest created WithCentroidRanges.
+func (t *TDigest) ByteSize() int {
+	ranges := cap(t.processedRanges) + cap(t.unprocessedRanges) + cap(t.scratchRanges)
+	return byteSize(cap(t.processed), cap(t.unprocessed), cap(t.scratch), cap(t.cumulative), ranges)
+}
+
+// MaxCentroids returns the maximum number of centroids held by a processed
+// digest with the given compression, clamped as by NewWithCompression.
+func MaxCentroids(compression float64) int {
+	return processedSize(0, compression)
+}
+
+// MaxBytes returns the ByteSize of a digest with the given compression and
+// default options once its buffers are at their steady-state sizes. It does
+// not cover WithCentroidRanges, which adds 16 bytes for every centroid the
+// processed, unprocessed and scratch buffers hold.
+func MaxBytes(compression float64) int {
+	processed, unprocessed, scratch, cumulative := bufferSizes(
+		processedSize(0, compression),
+		unprocessedSize(0, compression),
+	)
+	return byteSize(processed, unprocessed, scratch, cumulative, 0)
+}
+
+// bufferSizes returns the steady-state capacities of the internal buffers of
+// a digest.
+func bufferSizes(maxProcessed, maxUnprocessed int) (processed, unprocessed, scratch, cumulative int) {
+	return maxProcessed, maxUnprocessed + 1, maxProcessed, maxProcessed + 1
+}
+
+// byteSize returns the ByteSize of a digest with buffers of the given
+// capacities, and ranges centroid ranges in all.
+func byteSize(processed, unprocessed, scratch, cumulative, ranges int) int {
+	return digestSize +
+		(processed+unprocessed+scratch)*centroidSize +
+		cumulative*float64Size +
+		ranges*rangeSize
+}
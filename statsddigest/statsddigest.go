@@ -0,0 +1,245 @@
+// Package statsddigest reports the digests of a tdigest.Registry to statsd
+// as gauges.
+//
+// For each digest it sends a gauge for each quantile, named by the
+// percentile as in name.p99 or name.p999, and the gauges name.count and
+// name.max:
+//
+//	latency.p50:0.012|g
+//	latency.p99:0.2|g|#region:eu,service:api
+//
+// Tags are sent with the DogStatsD extension shown on the second line, and
+// only if some are set with WithTags. A negative value is preceded by a
+// gauge of 0, as in name.p50:0|g, because statsd reads a leading minus sign
+// as a decrement.
+package statsddigest
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/tdigest"
+)
+
+// DefaultQuantiles are the quantiles a Reporter sends unless given others.
+var DefaultQuantiles = []float64{0.5, 0.9, 0.99}
+
+// DefaultMaxPacketSize keeps packets within the payload of a single Ethernet
+// frame, as statsd clients commonly do.
+const DefaultMaxPacketSize = 1432
+
+// Option configures a Reporter.
+type Option func(r *Reporter)
+
+// WithQuantiles sends the quantiles qs instead of DefaultQuantiles.
+func WithQuantiles(qs ...float64) Option {
+	return func(r *Reporter) {
+		r.quantiles = qs
+	}
+}
+
+// WithPrefix prepends prefix to the name of every gauge.
+func WithPrefix(prefix string) Option {
+	return func(r *Reporter) {
+		r.prefix = prefix
+	}
+}
+
+// WithTags sends tags with every gauge.
+func WithTags(tags map[string]string) Option {
+	return func(r *Reporter) {
+		keys := make([]string, 0, len(tags))
+		for k := range tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, len(keys))
+		for i, k := range keys {
+			pairs[i] = tagEscaper.Replace(k) + ":" + tagEscaper.Replace(tags[k])
+		}
+		r.tags = ""
+		if len(pairs) > 0 {
+			r.tags = "|#" + strings.Join(pairs, ",")
+		}
+	}
+}
+
+// WithMaxPacketSize limits packets to n bytes instead of
+// DefaultMaxPacketSize. A gauge longer than n is sent in a packet of its own.
+func WithMaxPacketSize(n int) Option {
+	return func(r *Reporter) {
+		r.maxPacket = n
+	}
+}
+
+// WithErrorHandler calls fn with the errors of sending in the background,
+// which are otherwise ignored.
+func WithErrorHandler(fn func(error)) Option {
+	return func(r *Reporter) {
+		r.onError = fn
+	}
+}
+
+var (
+	nameEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", "\n", "_")
+	tagEscaper  = strings.NewReplacer(":", "_", "|", "_", ",", "_", "#", "_", "\n", "_")
+)
+
+// Reporter periodically sends the digests of a registry to statsd over UDP.
+type Reporter struct {
+	conn      net.Conn
+	registry  *tdigest.Registry
+	interval  time.Duration
+	quantiles []float64
+	prefix    string
+	tags      string
+	maxPacket int
+	onError   func(error)
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewReporter returns a Reporter that sends the digests of registry to the
+// statsd server at addr every interval until it is stopped. It returns an
+// error if interval is not positive, addr cannot be resolved or a quantile
+// is not in [0, 1].
+func NewReporter(addr string, interval time.Duration, registry *tdigest.Registry, opts ...Option) (*Reporter, error) {
+	r := &Reporter{
+		registry:  registry,
+		interval:  interval,
+		quantiles: DefaultQuantiles,
+		maxPacket: DefaultMaxPacketSize,
+		onError:   func(error) {},
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("statsddigest: invalid interval %v", interval)
+	}
+	for _, q := range r.quantiles {
+		if !(q >= 0 && q <= 1) {
+			return nil, fmt.Errorf("statsddigest: invalid quantile %v", q)
+		}
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	r.conn = conn
+	go r.run()
+	return r, nil
+}
+
+func (r *Reporter) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Report(); err != nil {
+				r.onError(err)
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// Stop stops sending and closes the connection, waiting for a report in
+// progress to finish. It may be called more than once.
+func (r *Reporter) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+		<-r.done
+		r.conn.Close()
+	})
+}
+
+// Report sends the digests of the registry now. It sends every packet even
+// if some fail, and returns the first error.
+func (r *Reporter) Report() error {
+	var (
+		first  error
+		packet []byte
+	)
+	send := func() {
+		if len(packet) == 0 {
+			return
+		}
+		// A full socket buffer should delay a report by at most one
+		// interval rather than hold up the next.
+		r.conn.SetWriteDeadline(time.Now().Add(r.interval))
+		if _, err := r.conn.Write(packet); err != nil && first == nil {
+			first = err
+		}
+		packet = packet[:0]
+	}
+	for _, name := range r.registry.Names() {
+		s, ok := r.registry.Get(name)
+		if !ok {
+			continue
+		}
+		for _, line := range r.gauges(name, s.Snapshot()) {
+			if len(packet) > 0 && len(packet)+1+len(line) > r.maxPacket {
+				send()
+			}
+			if len(packet) > 0 {
+				packet = append(packet, '\n')
+			}
+			packet = append(packet, line...)
+		}
+	}
+	send()
+	return first
+}
+
+// gauges returns the lines of the gauges of the digest f named name. An empty
+// digest has only a count.
+func (r *Reporter) gauges(name string, f *tdigest.FrozenDigest) []string {
+	name = r.prefix + nameEscaper.Replace(name)
+	lines := []string{r.gauge(name+".count", f.Count())}
+	if f.Count() == 0 {
+		return lines
+	}
+	for _, q := range r.quantiles {
+		lines = append(lines, r.gauge(name+"."+percentile(q), f.Quantile(q)))
+	}
+	return append(lines, r.gauge(name+".max", f.Quantile(1)))
+}
+
+// gauge returns the line setting the gauge name to v. statsd reads a value
+// with a sign as a change to the gauge rather than a new value, so a
+// negative v is sent after a line setting the gauge to 0, in the same
+// packet.
+func (r *Reporter) gauge(name string, v float64) string {
+	line := name + ":" + strconv.FormatFloat(v, 'g', -1, 64) + "|g" + r.tags
+	if v < 0 {
+		return name + ":0|g" + r.tags + "\n" + line
+	}
+	return line
+}
+
+// percentile names the quantile q by its digits as a percentile, so that
+// 0.5 is p50, 0.99 is p99 and 0.999 is p999.
+func percentile(q float64) string {
+	if !(q > 0 && q < 1) {
+		return "p" + strconv.FormatFloat(math.Round(q*100), 'f', -1, 64)
+	}
+	digits := strings.TrimPrefix(strconv.FormatFloat(q, 'f', -1, 64), "0.")
+	if len(digits) < 2 {
+		digits += "0"
+	}
+	return "p" + digits
+}
@@ -0,0 +1,194 @@
+package statsddigest_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/tdigest"
+	"github.com/influxdata/tdigest/statsddigest"
+	"go.uber.org/goleak"
+)
+
+// listen returns a local UDP listener and a function that reads the next
+// packet from it.
+func listen(t *testing.T) (net.PacketConn, func() string) {
+	t.Helper()
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	buf := make([]byte, 65536)
+	return l, func() string {
+		t.Helper()
+		l.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := l.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+}
+
+func registry(t *testing.T) *tdigest.Registry {
+	t.Helper()
+	r := tdigest.NewRegistry()
+	latency := tdigest.NewConcurrent(100)
+	for i := 1; i <= 100; i++ {
+		latency.Add(float64(i), 1)
+	}
+	if err := r.Register("latency", latency); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register("idle", tdigest.NewConcurrent(100)); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestReporter_Report(t *testing.T) {
+	l, read := listen(t)
+	r, err := statsddigest.NewReporter(l.LocalAddr().String(), time.Hour, registry(t),
+		statsddigest.WithPrefix("app."),
+		statsddigest.WithQuantiles(0.5, 0.999),
+		statsddigest.WithTags(map[string]string{"service": "api", "region": "eu|1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	if err := r.Report(); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"app.idle.count:0|g|#region:eu_1,service:api",
+		"app.latency.count:100|g|#region:eu_1,service:api",
+		"app.latency.p50:50.5|g|#region:eu_1,service:api",
+		"app.latency.p999:100|g|#region:eu_1,service:api",
+		"app.latency.max:100|g|#region:eu_1,service:api",
+	}, "\n")
+	if got := read(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestReporter_Split(t *testing.T) {
+	l, read := listen(t)
+	r, err := statsddigest.NewReporter(l.LocalAddr().String(), time.Hour, registry(t),
+		statsddigest.WithMaxPacketSize(40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	if err := r.Report(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"idle.count:0|g\nlatency.count:100|g",
+		"latency.p50:50.5|g\nlatency.p90:90.5|g",
+		"latency.p99:100|g\nlatency.max:100|g",
+	}
+	for _, w := range want {
+		if got := read(); got != w {
+			t.Errorf("got packet %q, want %q", got, w)
+		}
+	}
+}
+
+func TestReporter_Interval(t *testing.T) {
+	defer goleak.VerifyNone(t)
+	l, read := listen(t)
+	r, err := statsddigest.NewReporter(l.LocalAddr().String(), 10*time.Millisecond, registry(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if got := read(); !strings.HasPrefix(got, "idle.count:0|g\n") {
+			t.Errorf("unexpected packet %q", got)
+		}
+	}
+	r.Stop()
+	r.Stop()
+	if err := r.Report(); err == nil {
+		t.Error("Report after Stop succeeded")
+	}
+}
+
+func TestReporter_Errors(t *testing.T) {
+	defer goleak.VerifyNone(t)
+	l, _ := listen(t)
+	addr := l.LocalAddr().String()
+	l.Close()
+
+	// Nothing is listening, so once the port is found to be unreachable
+	// sends fail, and the reporter keeps going.
+	errs := make(chan error, 10)
+	r, err := statsddigest.NewReporter(addr, time.Millisecond, registry(t),
+		statsddigest.WithErrorHandler(func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-errs:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no error reported")
+		}
+	}
+	r.Stop()
+}
+
+func TestReporter_Negative(t *testing.T) {
+	l, read := listen(t)
+	reg := tdigest.NewRegistry()
+	offset := tdigest.NewConcurrent(100)
+	offset.AddValues([]float64{-3, -2, -1})
+	if err := reg.Register("offset", offset); err != nil {
+		t.Fatal(err)
+	}
+	r, err := statsddigest.NewReporter(l.LocalAddr().String(), time.Hour, reg,
+		statsddigest.WithQuantiles(0.5), statsddigest.WithMaxPacketSize(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	if err := r.Report(); err != nil {
+		t.Fatal(err)
+	}
+	// A negative gauge is reset to 0 first, in the same packet, so that
+	// statsd does not read the value as a decrement.
+	want := []string{
+		"offset.count:3|g",
+		"offset.p50:0|g\noffset.p50:-2|g",
+		"offset.max:0|g\noffset.max:-1|g",
+	}
+	for _, w := range want {
+		if got := read(); got != w {
+			t.Errorf("got packet %q, want %q", got, w)
+		}
+	}
+}
+
+func TestNewReporter_Invalid(t *testing.T) {
+	if _, err := statsddigest.NewReporter("127.0.0.1:8125", time.Second, tdigest.NewRegistry(),
+		statsddigest.WithQuantiles(1.5)); err == nil {
+		t.Error("invalid quantile accepted")
+	}
+	if _, err := statsddigest.NewReporter("no port", time.Second, tdigest.NewRegistry()); err == nil {
+		t.Error("invalid address accepted")
+	}
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if _, err := statsddigest.NewReporter("127.0.0.1:8125", interval, tdigest.NewRegistry()); err == nil {
+			t.Errorf("interval %v accepted", interval)
+		}
+	}
+}
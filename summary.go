@@ -0,0 +1,66 @@
+package tdigest
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
+// Summary describes a digest by a few numbers. Min, Max, Mean and the
+// quantiles are NaN for an empty digest.
+type Summary struct {
+	Count     float64
+	Min       float64
+	Max       float64
+	Mean      float64
+	Quantiles []QuantileValue
+}
+
+// QuantileValue is the value of a digest at quantile Q.
+type QuantileValue struct {
+	Q     float64
+	Value float64
+}
+
+// Summary returns the Summary of the snapshot with the quantiles qs.
+func (f *FrozenDigest) Summary(qs ...float64) Summary {
+	s := Summary{
+		Count: f.count,
+		Min:   math.NaN(),
+		Max:   math.NaN(),
+		Mean:  math.NaN(),
+	}
+	if f.processed.Len() > 0 {
+		s.Min, s.Max = f.min, f.max
+		s.Mean = f.Sum() / f.count
+	}
+	for _, q := range qs {
+		s.Quantiles = append(s.Quantiles, QuantileValue{Q: q, Value: f.Quantile(q)})
+	}
+	return s
+}
+
+// MarshalJSON encodes s as an object with the fields count, min, max, mean
+// and quantiles, the last mapping each quantile to its value. NaN and
+// infinite values, which JSON cannot represent, are encoded as null.
+func (s Summary) MarshalJSON() ([]byte, error) {
+	quantiles := make(map[string]*float64, len(s.Quantiles))
+	for _, q := range s.Quantiles {
+		quantiles[strconv.FormatFloat(q.Q, 'g', -1, 64)] = jsonFloat(q.Value)
+	}
+	return json.Marshal(struct {
+		Count     *float64            `json:"count"`
+		Min       *float64            `json:"min"`
+		Max       *float64            `json:"max"`
+		Mean      *float64            `json:"mean"`
+		Quantiles map[string]*float64 `json:"quantiles"`
+	}{jsonFloat(s.Count), jsonFloat(s.Min), jsonFloat(s.Max), jsonFloat(s.Mean), quantiles})
+}
+
+// jsonFloat returns a pointer to x, or nil if JSON cannot represent it.
+func jsonFloat(x float64) *float64 {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return nil
+	}
+	return &x
+}
@@ -0,0 +1,39 @@
+package tdigest_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestFrozenDigest_Summary(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	td.AddValues([]float64{1, 2, 3, 4})
+	s := td.Snapshot().Summary(0, 0.5, 1)
+	if s.Count != 4 || s.Min != 1 || s.Max != 4 || s.Mean != 2.5 {
+		t.Errorf("unexpected summary %+v", s)
+	}
+	want := []tdigest.QuantileValue{{Q: 0, Value: 1}, {Q: 0.5, Value: 2.5}, {Q: 1, Value: 4}}
+	for i, q := range s.Quantiles {
+		if q != want[i] {
+			t.Errorf("unexpected quantile %+v, want %+v", q, want[i])
+		}
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `{"count":4,"min":1,"max":4,"mean":2.5,"quantiles":{"0":1,"0.5":2.5,"1":4}}`; got != want {
+		t.Errorf("unexpected JSON\ngot  %s\nwant %s", got, want)
+	}
+
+	// An empty digest has no min, max, mean or quantiles.
+	b, err = json.Marshal(tdigest.NewWithCompression(100).Snapshot().Summary(0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `{"count":0,"min":null,"max":null,"mean":null,"quantiles":{"0.5":null}}`; got != want {
+		t.Errorf("unexpected JSON\ngot  %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,168 @@
+package tdigest
+
+import (
+	"fmt"
+	"math"
+)
+
+// ErrTailFit is returned by QuantileExtrapolated for a digest whose tail has
+// too few distinct centroids above the threshold to fit.
+const ErrTailFit = Error("too few tail centroids to fit a generalized Pareto distribution")
+
+// TailOption configures QuantileExtrapolated.
+type TailOption func(*tailConfig)
+
+type tailConfig struct {
+	centroids int
+}
+
+// defaultTailCentroids is the number of centroids QuantileExtrapolated fits
+// by default.
+const defaultTailCentroids = 20
+
+// WithTailCentroids fits the tail to the top k centroids rather than 20. The
+// fit needs at least 3 of them above the threshold. Fewer centroids reach
+// further into the tail but fit it less steadily.
+func WithTailCentroids(k int) TailOption {
+	return func(c *tailConfig) {
+		if k > 0 {
+			c.centroids = k
+		}
+	}
+}
+
+// QuantileExtrapolated processes pending data and returns the value at
+// quantile q as FrozenDigest.QuantileExtrapolated does.
+func (t *TDigest) QuantileExtrapolated(q float64, opts ...TailOption) (v float64, extrapolated bool, err error) {
+	t.Flush()
+	f := t.frozen()
+	return f.QuantileExtrapolated(q, opts...)
+}
+
+// QuantileExtrapolated is like Quantile, except for quantiles too high for
+// the data to show. Above 1 - 1/Count, where less than one value is expected,
+// it fits a generalized Pareto distribution to the top centroids and returns
+// its quantile instead, which may lie beyond the maximum, along with true to
+// flag the extrapolation. It returns the errors of QuantileE, and one
+// wrapping ErrTailFit if it cannot fit the tail.
+//
+// The fit takes the value below the top centroids as its threshold u, and
+// the share ζ of the weight above it, and fits the tail quantile function
+// x(p) = u + σ((ζ/p)^ξ - 1)/ξ, for exceedance probabilities p, to the means
+// of the centroids at their cumulative weights. It minimizes the squared
+// error of log(x-u), which weighs the centroids alike whatever their
+// distance from u and gives σ in closed form for each shape ξ. An
+// extrapolation is only as good as the assumption that the tail goes on as
+// it began.
+func (f *FrozenDigest) QuantileExtrapolated(q float64, opts ...TailOption) (v float64, extrapolated bool, err error) {
+	v, err = f.QuantileE(q)
+	if err != nil || q <= 1-1/f.count {
+		return v, false, err
+	}
+	c := tailConfig{centroids: defaultTailCentroids}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	fit, err := f.fitTail(c.centroids)
+	if err != nil {
+		return math.NaN(), false, fmt.Errorf("quantile %v: %w", q, err)
+	}
+	// The fit meets the data at the top of the supported range, and the
+	// result does not fall below it.
+	floor, _ := f.QuantileE(1 - 1/f.count)
+	return math.Max(floor, fit.quantile(1-q)), true, nil
+}
+
+// tailFit is a generalized Pareto tail above the threshold u, which holds
+// the share zeta of the weight.
+type tailFit struct {
+	u, zeta   float64
+	sigma, xi float64
+}
+
+// quantile returns the value exceeded with probability p.
+func (t tailFit) quantile(p float64) float64 {
+	return t.u + t.sigma*gpdExcess(p/t.zeta, t.xi)
+}
+
+// gpdExcess returns the excess over the threshold, in units of the scale,
+// that a generalized Pareto distribution of shape xi exceeds with
+// probability s: (s^-xi - 1)/xi, or -log(s) at xi = 0.
+func gpdExcess(s, xi float64) float64 {
+	if math.Abs(xi) < 1e-9 {
+		return -math.Log(s)
+	}
+	return math.Expm1(-xi*math.Log(s)) / xi
+}
+
+// fitTail fits a generalized Pareto tail to the top k centroids.
+func (f *FrozenDigest) fitTail(k int) (tailFit, error) {
+	n := f.processed.Len()
+	if k > n-1 {
+		// The threshold lies between the fitted centroids and the rest.
+		k = n - 1
+	}
+	if k < 3 {
+		return tailFit{}, ErrTailFit
+	}
+	first := n - k
+	below := f.weightBefore(first)
+	fit := tailFit{
+		u:    f.quantile(below / f.count),
+		zeta: (f.count - below) / f.count,
+	}
+	// Each centroid gives the log of its excess, y, at the log of the
+	// excess of a unit-scale distribution at its exceedance probability.
+	var ys, ss []float64
+	for i := first; i < n; i++ {
+		excess := f.processed[i].Mean - fit.u
+		if !(excess > 0) {
+			continue
+		}
+		ys = append(ys, math.Log(excess))
+		ss = append(ss, (f.count-f.cumulative[i])/(f.count-below))
+	}
+	if len(ys) < 3 {
+		return tailFit{}, ErrTailFit
+	}
+	// For a given shape, log(sigma) is the mean difference between y and
+	// the log of the unit excess. The shape is found by a coarse scan and
+	// then refined by golden section search.
+	cost := func(xi float64) (float64, float64) {
+		var mean float64
+		for i, s := range ss {
+			mean += ys[i] - math.Log(gpdExcess(s, xi))
+		}
+		mean /= float64(len(ss))
+		var sse float64
+		for i, s := range ss {
+			d := ys[i] - math.Log(gpdExcess(s, xi)) - mean
+			sse += d * d
+		}
+		return sse, mean
+	}
+	const lo, hi, steps = -0.5, 3.0, 35
+	best, bestCost := lo, math.Inf(1)
+	for i := 0; i <= steps; i++ {
+		xi := lo + (hi-lo)*float64(i)/steps
+		if c, _ := cost(xi); c < bestCost {
+			best, bestCost = xi, c
+		}
+	}
+	a, b := math.Max(lo, best-(hi-lo)/steps), math.Min(hi, best+(hi-lo)/steps)
+	const phi = 0.6180339887498949
+	for i := 0; i < 40; i++ {
+		m1, m2 := b-phi*(b-a), a+phi*(b-a)
+		c1, _ := cost(m1)
+		c2, _ := cost(m2)
+		if c1 < c2 {
+			b = m2
+		} else {
+			a = m1
+		}
+	}
+	fit.xi = (a + b) / 2
+	_, logSigma := cost(fit.xi)
+	fit.sigma = math.Exp(logSigma)
+	return fit, nil
+}
@@ -0,0 +1,121 @@
+package tdigest_test
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/influxdata/tdigest"
+)
+
+func TestTdigest_QuantileExtrapolated(t *testing.T) {
+	// 1e5 values show quantiles up to 0.99999; the quantile at 0.999999,
+	// ten times further into the tail, is only known from the fit.
+	const n, q = 100000, 0.999999
+	tests := []struct {
+		name   string
+		sample func(r *rand.Rand) float64
+		truth  float64
+		factor float64
+	}{
+		{
+			name:   "exponential",
+			sample: func(r *rand.Rand) float64 { return r.ExpFloat64() },
+			truth:  -math.Log(1 - q),
+			factor: 1.15,
+		},
+		{
+			name:   "pareto",
+			sample: func(r *rand.Rand) float64 { return math.Pow(1-r.Float64(), -1/3.0) },
+			truth:  math.Pow(1-q, -1/3.0),
+			factor: 1.5,
+		},
+		{
+			name:   "lognormal",
+			sample: func(r *rand.Rand) float64 { return math.Exp(r.NormFloat64()) },
+			truth:  math.Exp(math.Sqrt2 * math.Erfinv(2*q-1)),
+			factor: 1.7,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for seed := int64(1); seed <= 3; seed++ {
+				r := rand.New(rand.NewSource(seed))
+				td := tdigest.NewWithCompression(100)
+				for i := 0; i < n; i++ {
+					td.Add(tt.sample(r), 1)
+				}
+				got, extrapolated, err := td.QuantileExtrapolated(q)
+				if err != nil || !extrapolated {
+					t.Fatalf("seed %d: QuantileExtrapolated(%g) = %g, %v, %v", seed, q, got, extrapolated, err)
+				}
+				if got <= td.Max() {
+					t.Errorf("seed %d: extrapolated %g is not beyond the maximum %g", seed, got, td.Max())
+				}
+				if ratio := got / tt.truth; ratio > tt.factor || ratio < 1/tt.factor {
+					t.Errorf("seed %d: extrapolated %g, true quantile %g", seed, got, tt.truth)
+				}
+			}
+		})
+	}
+}
+
+func TestTdigest_QuantileExtrapolatedSupported(t *testing.T) {
+	td := tdigest.NewWithCompression(100)
+	for _, x := range NormalData {
+		td.Add(x, 1)
+	}
+	for _, q := range []float64{0, 0.5, 0.99, 1 - 1/td.Count()} {
+		got, extrapolated, err := td.QuantileExtrapolated(q)
+		if err != nil || extrapolated || got != td.Quantile(q) {
+			t.Errorf("QuantileExtrapolated(%g) = %g, %v, %v, want %g from Quantile", q, got, extrapolated, err, td.Quantile(q))
+		}
+	}
+
+	// Beyond the supported range the estimate grows with q, and fewer tail
+	// centroids fit it too.
+	prev := td.Quantile(1 - 1/td.Count())
+	for _, p := range []float64{0.5, 0.1, 0.01, 0} {
+		q := 1 - p/td.Count()
+		for _, k := range []int{5, 20, 50} {
+			got, extrapolated, err := td.QuantileExtrapolated(q, tdigest.WithTailCentroids(k))
+			if err != nil || !extrapolated || got < prev {
+				t.Errorf("QuantileExtrapolated(%g) with %d centroids = %g, %v, %v, want at least %g", q, k, got, extrapolated, err, prev)
+			}
+		}
+		prev, _, _ = td.QuantileExtrapolated(q)
+	}
+	if got := td.Quantile(1); got != td.Max() {
+		t.Errorf("Quantile(1) = %g, want the maximum %g", got, td.Max())
+	}
+}
+
+func TestTdigest_QuantileExtrapolatedErrors(t *testing.T) {
+	if _, _, err := tdigest.New().QuantileExtrapolated(0.5); !errors.Is(err, tdigest.ErrEmptyDigest) {
+		t.Errorf("empty digest: got %v", err)
+	}
+	td := tdigest.New()
+	for i := 0; i < 3; i++ {
+		td.Add(float64(i), 1)
+	}
+	if _, _, err := td.QuantileExtrapolated(1.5); !errors.Is(err, tdigest.ErrInvalidQuantile) {
+		t.Errorf("quantile 1.5: got %v", err)
+	}
+	// Three centroids leave two above the threshold.
+	if v, extrapolated, err := td.QuantileExtrapolated(0.9); !errors.Is(err, tdigest.ErrTailFit) || extrapolated || !math.IsNaN(v) {
+		t.Errorf("three values: got %g, %v, %v", v, extrapolated, err)
+	}
+	if v, _, err := td.QuantileExtrapolated(0.5); err != nil || v != td.Quantile(0.5) {
+		t.Errorf("supported quantile of three values: got %g, %v", v, err)
+	}
+	// A constant tail has no excess over its threshold.
+	flat := tdigest.New()
+	for i := 0; i < 1000; i++ {
+		flat.Add(1, 1)
+		flat.Add(float64(i%10), 1)
+	}
+	if _, _, err := flat.QuantileExtrapolated(0.99999); !errors.Is(err, tdigest.ErrTailFit) {
+		t.Errorf("flat tail: got %v", err)
+	}
+}
@@ -3,213 +3,901 @@ package tdigest
 import (
 	"fmt"
 	"math"
+	"math/bits"
 	"sort"
+	"sync/atomic"
+	"time"
 )
 
+// TDigest is not safe for concurrent use. Even methods that only query it,
+// such as Quantile, CDF, Export, ExportTo, Centroids and ForEachCentroid,
+// first process pending data and so modify it. ExportSnapshot,
+// ExportUnmerged, String, DebugString, Count and Stats leave it unchanged and
+// may be called concurrently with each other, but not with any other method.
+// See ConcurrentTDigest for a digest that is safe for concurrent use.
 type TDigest struct {
-	Compression float64
+	compression float64
 
 	maxProcessed      int
 	maxUnprocessed    int
+	weightFraction    float64
 	processed         CentroidList
 	unprocessed       CentroidList
+	unsorted          bool // unprocessed is not known to be sorted by mean
+	dirty             bool // there is data that has not been processed
+	background        bool // compaction is left to a background goroutine
+	deterministic     bool // process only on Flush, see WithDeterministic
+	scale             ScaleFunction
+	scratch           CentroidList
 	cumulative        []float64
-	processedWeight   float64
-	unprocessedWeight float64
+	processedWeight   kahanSum
+	unprocessedWeight kahanSum
 	min               float64
 	max               float64
+	dropped           uint64
+	processes         uint64
+	adds              uint64
+	weightAdded       kahanSum
+	processTime       time.Duration
+	growths           uint64 // times appending grew the unprocessed buffer
+	keepStats         bool   // Reset keeps the counters, see WithPersistentStats
+	clamp             bool   // clamp values into [clampMin, clampMax]
+	clampMin          float64
+	clampMax          float64
+	published         atomic.Value // *FrozenDigest
+	onProcess         func(ProcessStats)
+	onDrop            func(reason DropReason, x, w float64)
+	queueHooks        bool // queue hook events for a locking wrapper to run
+	hookEvents        []hookEvent
+	ranges            bool // keep the range of every centroid, see WithCentroidRanges
+	processedRanges   []CentroidRange
+	unprocessedRanges []CentroidRange
+	scratchRanges     []CentroidRange
+	exactCount        uint64      // total weight as an integer, see ExactCount
+	inexact           bool        // exactCount does not hold the total weight
+	cache             *queryCache // nil unless WithQueryCache
+	optionErr         error       // the first invalid option, see NewChecked
 }
 
-func New() *TDigest {
-	return NewWithCompression(1000)
+// ErrInvalidCentroid is returned by AddCentroidListChecked for a centroid
+// that AddCentroid would drop, and by CentroidList.Validate for a centroid
+// with a mean or weight that no digest accepts.
+const ErrInvalidCentroid = Error("centroid mean must not be NaN or infinite and its weight must be positive and finite")
+
+// MinCompression is the smallest compression a digest can have. Below it a
+// digest keeps so few centroids that its quantiles are little better than a
+// guess between min and max.
+const MinCompression = 10
+
+// MaxCompression is the largest compression a digest can have. A digest of
+// this compression holds up to 2e5 centroids and buffers up to 8e5 more,
+// taking about 20MB.
+const MaxCompression = 1e5
+
+// ErrInvalidCompression is returned by NewChecked for a compression that is
+// NaN or outside [MinCompression, MaxCompression].
+const ErrInvalidCompression = Error("compression must be between MinCompression and MaxCompression")
+
+// ErrInvalidOption is wrapped by the error NewChecked returns for an option
+// given invalid arguments.
+const ErrInvalidOption = Error("invalid option")
+
+// DefaultCompression is the compression of a digest created by New.
+const DefaultCompression = 1000
+
+// New returns a digest with DefaultCompression.
+func New(opts ...Option) *TDigest {
+	return NewWithCompression(DefaultCompression, opts...)
 }
-func NewWithCompression(c float64) *TDigest {
+
+// NewWithCompression returns a digest with compression c. A c that is NaN or
+// less than MinCompression is replaced by MinCompression, and one greater
+// than MaxCompression by MaxCompression; use NewChecked to reject them
+// instead.
+func NewWithCompression(c float64, opts ...Option) *TDigest {
 	t := &TDigest{
-		Compression: c,
+		compression:    clampCompression(c),
+		weightFraction: 1,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.maxProcessed = processedSize(0, t.compression)
+	t.maxUnprocessed = unprocessedSize(t.maxUnprocessed, t.compression)
+	processed, unprocessed, scratch, cumulative := bufferSizes(t.maxProcessed, t.maxUnprocessed)
+	t.processed = make([]Centroid, 0, processed)
+	t.unprocessed = make([]Centroid, 0, unprocessed)
+	t.scratch = make([]Centroid, 0, scratch)
+	t.cumulative = make([]float64, 0, cumulative)
+	if t.ranges {
+		t.processedRanges = make([]CentroidRange, 0, processed)
+		t.unprocessedRanges = make([]CentroidRange, 0, unprocessed)
+		t.scratchRanges = make([]CentroidRange, 0, scratch)
 	}
-	t.maxProcessed = processedSize(0, t.Compression)
-	t.maxUnprocessed = unprocessedSize(0, t.Compression)
-	t.processed = make([]Centroid, 0, t.maxProcessed)
-	t.unprocessed = make([]Centroid, 0, t.maxUnprocessed+1)
 	t.min = math.MaxFloat64
 	t.max = -math.MaxFloat64
 	return t
 }
 
+// NewChecked is like NewWithCompression but returns ErrInvalidCompression for
+// a compression that is NaN or outside [MinCompression, MaxCompression], and
+// an error wrapping ErrInvalidOption for an option given invalid arguments,
+// which NewWithCompression ignores.
+func NewChecked(compression float64, opts ...Option) (*TDigest, error) {
+	if !(compression >= MinCompression && compression <= MaxCompression) {
+		return nil, ErrInvalidCompression
+	}
+	t := NewWithCompression(compression, opts...)
+	if t.optionErr != nil {
+		return nil, t.optionErr
+	}
+	return t, nil
+}
+
+// Compression returns the compression of the digest.
+func (t *TDigest) Compression() float64 {
+	return t.compression
+}
+
+// Reset clears the digest so that it can be reused. Allocated buffers are
+// kept.
+func (t *TDigest) Reset() {
+	if t.cache != nil {
+		t.cache.invalidate()
+	}
+	t.processed.Clear()
+	t.unprocessed.Clear()
+	t.unsorted = false
+	t.dirty = false
+	t.scratch.Clear()
+	t.cumulative = t.cumulative[:0]
+	t.processedRanges = t.processedRanges[:0]
+	t.unprocessedRanges = t.unprocessedRanges[:0]
+	t.scratchRanges = t.scratchRanges[:0]
+	t.processedWeight = kahanSum{}
+	t.unprocessedWeight = kahanSum{}
+	t.min = math.MaxFloat64
+	t.max = -math.MaxFloat64
+	t.exactCount = 0
+	t.inexact = false
+	if !t.keepStats {
+		t.dropped = 0
+		t.processes = 0
+		t.adds = 0
+		t.weightAdded = kahanSum{}
+		t.processTime = 0
+		t.growths = 0
+	}
+}
+
+// ShrinkToFit processes pending data and releases buffer capacity beyond what
+// the digest needs in steady state, such as after a burst of ingestion grew
+// its buffers. Buffers are otherwise never shrunk.
+func (t *TDigest) ShrinkToFit() {
+	t.process()
+	processed, unprocessed, scratch, cumulative := bufferSizes(t.maxProcessed, t.maxUnprocessed)
+	t.processed = shrinkCentroids(t.processed, processed)
+	t.unprocessed = shrinkCentroids(t.unprocessed, unprocessed)
+	t.scratch = shrinkCentroids(t.scratch[:0], scratch)
+	if t.ranges {
+		t.processedRanges = shrinkRanges(t.processedRanges, processed)
+		t.unprocessedRanges = shrinkRanges(t.unprocessedRanges, unprocessed)
+		t.scratchRanges = shrinkRanges(t.scratchRanges[:0], scratch)
+	}
+	if size := len(t.cumulative); cap(t.cumulative) > size && cap(t.cumulative) > cumulative {
+		if size < cumulative {
+			size = cumulative
+		}
+		shrunk := make([]float64, len(t.cumulative), size)
+		copy(shrunk, t.cumulative)
+		t.cumulative = shrunk
+	}
+}
+
+// Add adds x with weight w. NaN values are dropped and counted in Dropped, as
+// are infinite ones unless the digest was created WithClamp, and values whose
+// weight is not positive and finite.
 func (t *TDigest) Add(x, w float64) {
-	if math.IsNaN(x) {
+	_ = t.add(Centroid{Mean: x, Weight: w})
+}
+
+// AddN adds x with a weight of n, for a value known to have occurred n times.
+// Unlike Add, the count is kept exactly as an integer for ExactCount, however
+// large it is. A zero n is dropped as an invalid weight is, as are the values
+// Add drops.
+func (t *TDigest) AddN(x float64, n uint64) {
+	if n == 0 {
+		t.adds++
+		t.drop(x, 0)
 		return
 	}
-	t.AddCentroid(Centroid{Mean: x, Weight: w})
+	if t.appendCentroid(Centroid{Mean: x, Weight: float64(n)}) {
+		t.countN(n)
+	}
+	if t.shouldProcess() {
+		t.process()
+	}
+}
+
+// AddValues adds each of xs with a weight of 1. It is equivalent to calling
+// Add for each value, but checks whether to process once per chunk of the
+// buffer rather than once per value. Processing dominates the cost of adding,
+// so this saves only about 10% over a loop of Add.
+func (t *TDigest) AddValues(xs []float64) {
+	t.AddValuesWeighted(xs, 1)
 }
 
-func (t *TDigest) AddCentroidList(c CentroidList) {
-	l := c.Len()
-	for i := 0; i < l; i++ {
-		diff := l - i
-		room := t.maxUnprocessed - t.unprocessed.Len()
-		mid := i + diff
-		if room < diff {
-			mid = i + room
+// AddValuesWeighted adds each of xs with weight w. Values are dropped or
+// clamped as by Add.
+func (t *TDigest) AddValuesWeighted(xs []float64, w float64) {
+	t.adds += uint64(len(xs))
+	if !validWeight(w) {
+		if t.onDrop == nil {
+			t.dropped += uint64(len(xs))
+			return
 		}
-		for i < mid {
-			t.AddCentroid(c[i])
-			i++
+		for _, x := range xs {
+			t.drop(x, w)
+		}
+		return
+	}
+	for len(xs) > 0 {
+		// Fill the unprocessed list up to its count limit, checking the
+		// weight trigger once per chunk.
+		chunk := t.maxUnprocessed + 1 - t.unprocessed.Len()
+		if chunk > len(xs) || chunk <= 0 {
+			chunk = len(xs)
+		}
+		admitted := 0
+		for _, x := range xs[:chunk] {
+			x, ok := t.admit(x)
+			if !ok {
+				t.drop(x, w)
+				continue
+			}
+			admitted++
+			t.appendAdmitted(Centroid{Mean: x, Weight: w})
+		}
+		t.weightAdded.add(float64(admitted) * w)
+		t.countWeights(w, admitted)
+		xs = xs[chunk:]
+
+		if t.shouldProcess() {
+			t.process()
 		}
 	}
 }
 
+// Dropped returns the number of values that were not added to the digest
+// because they were NaN or infinite, or their weight was not positive and
+// finite.
+func (t *TDigest) Dropped() uint64 {
+	return t.dropped
+}
+
+// AddCentroidList adds each centroid of l as AddCentroid does, dropping or
+// clamping them as Add does. The centroids are appended in chunks that fill
+// the buffer of pending data, and pending data is processed between chunks
+// rather than after individual centroids, so a long list is compressed at the
+// same points however it is split into calls.
+func (t *TDigest) AddCentroidList(l CentroidList) {
+	t.addCentroidList(l, true)
+}
+
+// addCentroidList implements AddCentroidList, counting the weights of the
+// centroids added for ExactCount if count is true.
+func (t *TDigest) addCentroidList(l CentroidList, count bool) {
+	for len(l) > 0 {
+		n := t.maxUnprocessed + 1 - t.unprocessed.Len()
+		if n > len(l) || n <= 0 {
+			n = len(l)
+		}
+		for _, c := range l[:n] {
+			if t.appendCentroid(c) && count {
+				t.countWeights(c.Weight, 1)
+			}
+		}
+		l = l[n:]
+
+		if t.shouldProcess() {
+			t.process()
+		}
+	}
+}
+
+// AddCentroidListChecked is like AddCentroidList but first validates every
+// centroid of l. If any would be dropped it adds none of them and returns an
+// error wrapping ErrInvalidCentroid that gives the index of the first.
+func (t *TDigest) AddCentroidListChecked(l CentroidList) error {
+	if err := l.validateFor(t); err != nil {
+		return err
+	}
+	t.AddCentroidList(l)
+	return nil
+}
+
+// validateFor returns the error of AddCentroidListChecked for adding l to t.
+func (l CentroidList) validateFor(t *TDigest) error {
+	for i, c := range l {
+		if _, ok := t.admit(c.Mean); !ok || !validWeight(c.Weight) {
+			return fmt.Errorf("centroid %d {Mean: %g, Weight: %g}: %w", i, c.Mean, c.Weight, ErrInvalidCentroid)
+		}
+	}
+	return nil
+}
+
+// AddCentroid adds c. It is dropped or clamped as by Add.
 func (t *TDigest) AddCentroid(c Centroid) {
+	_ = t.add(c)
+}
+
+// add implements Add, AddE and AddCentroid. It returns ErrInvalidValue or
+// ErrInvalidWeight itself if c is dropped, leaving AddE to describe it.
+func (t *TDigest) add(c Centroid) error {
+	err := t.appendChecked(c)
+	if err == nil {
+		t.countWeights(c.Weight, 1)
+	}
+	if t.shouldProcess() {
+		t.process()
+	}
+	return err
+}
+
+// appendCentroid adds c to the pending data without processing it, and
+// reports whether it was added rather than dropped. It leaves counting the
+// weight for ExactCount to the caller.
+func (t *TDigest) appendCentroid(c Centroid) bool {
+	return t.appendChecked(c) == nil
+}
+
+// appendChecked is appendCentroid, returning ErrInvalidValue or
+// ErrInvalidWeight rather than false if c is dropped.
+func (t *TDigest) appendChecked(c Centroid) error {
+	t.adds++
+	var ok bool
+	if c.Mean, ok = t.admit(c.Mean); !ok {
+		t.drop(c.Mean, c.Weight)
+		return ErrInvalidValue
+	}
+	if !validWeight(c.Weight) {
+		t.drop(c.Mean, c.Weight)
+		return ErrInvalidWeight
+	}
+	t.weightAdded.add(c.Weight)
+	t.appendAdmitted(c)
+	return nil
+}
+
+// appendAdmitted appends c, whose mean t has admitted and whose weight is
+// valid, to the unprocessed list, without counting it as added.
+func (t *TDigest) appendAdmitted(c Centroid) {
+	t.updateBounds(c.Mean)
+	if t.processed.Len()+t.unprocessed.Len() == 1 && t.addToSingle(c.Mean, c.Weight) {
+		return
+	}
+	if n := t.unprocessed.Len(); n > 0 && lessCentroid(c, t.unprocessed[n-1]) {
+		t.unsorted = true
+	}
+	if len(t.unprocessed) == cap(t.unprocessed) {
+		t.growths++
+	}
 	t.unprocessed = append(t.unprocessed, c)
-	t.unprocessedWeight += c.Weight
+	if t.ranges {
+		t.unprocessedRanges = append(t.unprocessedRanges, CentroidRange{c.Mean, c.Mean})
+	}
+	t.unprocessedWeight.add(c.Weight)
+	t.dirty = true
+}
 
-	if t.processed.Len() > t.maxProcessed ||
-		t.unprocessed.Len() > t.maxUnprocessed {
+// admit returns x as it is to be added to t, and false if it is to be
+// dropped instead: NaN always is, and infinite values are unless t clamps.
+func (t *TDigest) admit(x float64) (float64, bool) {
+	if t.clamp {
+		if math.IsNaN(x) {
+			return x, false
+		}
+		return math.Max(t.clampMin, math.Min(x, t.clampMax)), true
+	}
+	// x-x is NaN for both NaN and infinite x.
+	return x, x-x == 0
+}
+
+// validWeight reports whether w is positive and finite. A zero weight would
+// divide by zero when interpolating and a negative one would make the
+// cumulative weights decrease.
+func validWeight(w float64) bool {
+	return w > 0 && w-w == 0
+}
+
+// updateBounds records x in the minimum and maximum. They are tracked from
+// the values as they are added, since merging moves the means of the extreme
+// centroids inward.
+func (t *TDigest) updateBounds(x float64) {
+	if x < t.min {
+		t.min = x
+	}
+	if x > t.max {
+		t.max = x
+	}
+}
+
+// countN adds n to the exact count, which becomes inexact if the sum
+// overflows.
+func (t *TDigest) countN(n uint64) {
+	sum, carry := bits.Add64(t.exactCount, n, 0)
+	t.exactCount = sum
+	t.inexact = t.inexact || carry != 0
+}
+
+// countWeights adds n weights of w to the exact count. A weight that is not a
+// whole number, or is beyond 2^53 where whole numbers may already have been
+// rounded to get it, makes the count inexact.
+func (t *TDigest) countWeights(w float64, n int) {
+	if t.inexact || n == 0 {
+		return
+	}
+	if !(w <= 1<<53 && w == math.Trunc(w)) {
+		t.inexact = true
+		return
+	}
+	hi, lo := bits.Mul64(uint64(w), uint64(n))
+	t.inexact = hi != 0
+	t.countN(lo)
+}
+
+// addToSingle adds weight w to the only centroid of t if its mean is x and
+// reports whether it did. While every value added is identical, this keeps
+// the digest at a single centroid without ever sorting or merging.
+func (t *TDigest) addToSingle(x, w float64) bool {
+	switch {
+	case t.deterministic:
+		// Folding depends on the order values arrive in.
+		return false
+	case t.processed.Len() == 0 && t.unprocessed[0].Mean == x:
+		t.unprocessedWeight.add(w)
+		t.unprocessed[0].Weight = t.unprocessedWeight.value()
+	case t.unprocessed.Len() == 0 && t.processed[0].Mean == x:
+		if t.cache != nil {
+			t.cache.invalidate()
+		}
+		t.processedWeight.add(w)
+		t.processed[0].Weight = t.processedWeight.value()
+		t.cumulative[0] = t.processed[0].Weight / 2.0
+		t.cumulative[1] = t.processed[0].Weight
+	default:
+		return false
+	}
+	return true
+}
+
+// Min returns the smallest value added to the digest, or NaN if it is empty.
+func (t *TDigest) Min() float64 {
+	if t.min > t.max {
+		return math.NaN()
+	}
+	return t.min
+}
+
+// Max returns the largest value added to the digest, or NaN if it is empty.
+func (t *TDigest) Max() float64 {
+	if t.min > t.max {
+		return math.NaN()
+	}
+	return t.max
+}
+
+// Count returns the total weight added to the digest.
+func (t *TDigest) Count() float64 {
+	s := t.processedWeight
+	s.addSum(t.unprocessedWeight)
+	return s.value()
+}
+
+// ExactCount returns the total weight added to the digest as an integer, and
+// whether it is exact. It is exact, however large, while every weight added
+// has been a count: that of AddN, or a whole number no greater than 2^53
+// given to the other methods, including those of merged digests whose own
+// count is exact. It becomes inexact for good, until Reset, on any other
+// weight or if the total overflows a uint64. Count, which is a float64,
+// cannot hold counts beyond 2^53 exactly.
+func (t *TDigest) ExactCount() (n uint64, exact bool) {
+	return t.exactCount, !t.inexact
+}
+
+// Flush processes any pending data. Until t is next modified, the query
+// methods (Quantile, CDF, Count, Export, Centroids and ForEachCentroid) then
+// only read t and may be called concurrently from several goroutines.
+func (t *TDigest) Flush() {
+	if t.dirty {
 		t.process()
 	}
 }
 
+// Export processes pending data and returns a copy of the centroids.
 func (t *TDigest) Export() CentroidList {
-	t.process()
+	t.Flush()
 	return t.processed.Clone()
 }
 
+// ExportTo processes pending data and appends the centroids to dst, returning
+// the extended list. Passing a reused dst[:0] avoids the allocation of Export.
+func (t *TDigest) ExportTo(dst CentroidList) CentroidList {
+	t.Flush()
+	return append(dst, t.processed...)
+}
+
+// ExportUnmerged returns copies of the processed centroids and of the pending
+// centroids that have not been processed yet, in the order they were added.
+// It does not modify t, so it shows the digest exactly as it is, which Export
+// cannot.
+func (t *TDigest) ExportUnmerged() (processed, unprocessed CentroidList) {
+	return t.processed.Clone(), t.unprocessed.Clone()
+}
+
+// ExportSnapshot returns a copy of the centroids without processing pending
+// data, which is appended as-is after the processed centroids. Unlike Export
+// it does not modify t.
+func (t *TDigest) ExportSnapshot() CentroidList {
+	cl := make(CentroidList, 0, len(t.processed)+len(t.unprocessed))
+	cl = append(cl, t.processed...)
+	return append(cl, t.unprocessed...)
+}
+
+// Centroids returns the processed centroids of the digest without copying them.
+// The list is a read-only view: it is only valid until t is next modified and
+// callers must neither retain nor mutate it. Use Export for an owned copy.
+func (t *TDigest) Centroids() CentroidList {
+	t.Flush()
+	return t.processed
+}
+
+// ForEachCentroid calls fn for each centroid in ascending order of mean,
+// stopping early if fn returns false. Pending data is processed first and no
+// copy of the centroids is made.
+func (t *TDigest) ForEachCentroid(fn func(c Centroid) bool) {
+	t.Flush()
+	for _, c := range t.processed {
+		if !fn(c) {
+			return
+		}
+	}
+}
+
+// String returns a short summary of t, of bounded length whatever its size,
+// so that it is safe to log. It does not modify t.
 func (t *TDigest) String() string {
+	return fmt.Sprintf("{compression: %g, count: %g, centroids: %d, pending: %d, min: %g, max: %g}",
+		t.compression, t.Count(), t.processed.Len(), t.unprocessed.Len(), t.Min(), t.Max())
+}
+
+// DebugString formats every processed and pending centroid of t. Its length
+// grows with the compression. It does not modify t.
+func (t *TDigest) DebugString() string {
 	return fmt.Sprintf("{processed: %v, unprocessed: %v}", t.processed, t.unprocessed)
 }
 
+// shouldProcess reports whether the buffered centroids are due to be
+// compressed.
+func (t *TDigest) shouldProcess() bool {
+	if t.deterministic {
+		return false
+	}
+	if t.processed.Len() > t.maxProcessed ||
+		t.unprocessed.Len() > t.maxUnprocessed {
+		return true
+	}
+	if t.background {
+		return false
+	}
+	processed := t.processedWeight.value()
+	return t.weightFraction > 0 && processed > 0 &&
+		t.unprocessedWeight.value() > t.weightFraction*processed
+}
+
 func (t *TDigest) process() {
+	t.processes++
+	if t.cache != nil {
+		t.cache.invalidate()
+	}
+	start := time.Now()
+	if t.onProcess != nil {
+		defer t.reportProcess(start, t.processed.Len()+t.unprocessed.Len(), t.unprocessedWeight.value())
+	}
 	if t.unprocessed.Len() > 0 ||
 		t.processed.Len() > t.maxProcessed {
 
-		// Append all processed centroids to the unprocessed list and sort
-		t.unprocessed = append(t.unprocessed, t.processed...)
-		sort.Sort(&t.unprocessed)
+		// The unprocessed list is sorted in place and merged with the already
+		// sorted processed list as the sweep reads them, writing the compressed
+		// centroids into the scratch list which then becomes the processed list.
+		if t.unsorted {
+			if t.ranges {
+				sort.Sort(rangedCentroids{t.unprocessed, t.unprocessedRanges})
+			} else {
+				sortCentroids(t.unprocessed)
+			}
+		}
+		a, b := t.processed, t.unprocessed
+		m := centroidMerger{a: a, b: b}
+		out := append(t.scratch[:0], m.next())
+		// With ranges, the range of each output centroid is built alongside
+		// it from the ranges of the centroids merged into it.
+		var ranges []CentroidRange
+		var rng CentroidRange
+		if t.ranges {
+			ranges = t.scratchRanges[:0]
+			rng = m.lastRange(t.processedRanges, t.unprocessedRanges)
+		}
 
-		// Reset processed list with first centroid
-		t.processed.Clear()
-		t.processed = append(t.processed, t.unprocessed[0])
+		// The cumulative weights are emitted as each centroid is completed,
+		// in the same pass that builds the processed list.
+		t.cumulative = t.cumulative[:0]
+		var cumulative kahanSum
 
-		t.processedWeight += t.unprocessedWeight
-		t.unprocessedWeight = 0
-		soFar := t.unprocessed[0].Weight
-		limit := t.processedWeight * t.integratedQ(1.0)
-		for _, centroid := range t.unprocessed[1:] {
+		t.processedWeight.addSum(t.unprocessedWeight)
+		t.unprocessedWeight = kahanSum{}
+		total := t.processedWeight.value()
+		if t.deterministic {
+			// The running total depends on the order weights were added
+			// in, so sum them again in sorted order.
+			var sum kahanSum
+			for _, c := range a {
+				sum.add(c.Weight)
+			}
+			for _, c := range b {
+				sum.add(c.Weight)
+			}
+			total = sum.value()
+		}
+		soFar := out[0].Weight
+		scale := newK1Scale(t.compression, t.scale)
+		limit := total * scale.qLimit(0)
+		// The centroid being built is kept as the mean of its first part plus
+		// a compensated sum of the weighted offsets of the rest from it, so
+		// that light centroids merged into heavy ones still move the mean.
+		var acc centroidSum
+		acc.reset(out[0])
+		for m.more() {
+			centroid := m.next()
 			projected := soFar + centroid.Weight
 			if projected <= limit {
 				soFar = projected
-				(&t.processed[t.processed.Len()-1]).Add(centroid)
+				acc.add(centroid)
+				if t.ranges {
+					rng = rng.union(m.lastRange(t.processedRanges, t.unprocessedRanges))
+				}
 			} else {
-				k1 := t.integratedLocation(soFar / t.processedWeight)
-				limit = t.processedWeight * t.integratedQ(k1+1.0)
+				limit = total * scale.qLimit(soFar/total)
 				soFar += centroid.Weight
-				t.processed = append(t.processed, centroid)
+				out[len(out)-1] = acc.centroid()
+				cur := out[len(out)-1].Weight
+				t.cumulative = append(t.cumulative, cumulative.value()+cur/2.0)
+				cumulative.add(cur)
+				out = append(out, centroid)
+				acc.reset(centroid)
+				if t.ranges {
+					ranges = append(ranges, rng.include(out[len(out)-2].Mean))
+					rng = m.lastRange(t.processedRanges, t.unprocessedRanges)
+				}
 			}
 		}
+		out[len(out)-1] = acc.centroid()
+		cur := out[len(out)-1].Weight
+		t.cumulative = append(t.cumulative, cumulative.value()+cur/2.0)
+		cumulative.add(cur)
+		t.cumulative = append(t.cumulative, cumulative.value())
+		t.processed, t.scratch = out, t.processed
+		if t.ranges {
+			ranges = append(ranges, rng.include(out[len(out)-1].Mean))
+			t.processedRanges, t.scratchRanges = ranges, t.processedRanges
+			t.unprocessedRanges = t.unprocessedRanges[:0]
+		}
+		// The total is taken from the centroids themselves, so that it always
+		// matches their weights and the last cumulative weight.
+		t.processedWeight = cumulative
+
 		t.min = math.Min(t.min, t.processed[0].Mean)
 		t.max = math.Max(t.max, t.processed[t.processed.Len()-1].Mean)
-		t.updateCumulative()
 		t.unprocessed.Clear()
+		t.unsorted = false
+		if t.processed.Len() > t.maxProcessed {
+			t.mergeNeighbours()
+		}
+	}
+	t.dirty = false
+	t.processTime += time.Since(start)
+	if debugInvariants {
+		if err := t.CheckInvariants(); err != nil {
+			panic(err)
+		}
 	}
 }
 
-func (t *TDigest) updateCumulative() {
-	t.cumulative = make([]float64, t.processed.Len()+1)
-	prev := 0.0
-	for i, centroid := range t.processed {
-		cur := centroid.Weight
-		t.cumulative[i] = prev + cur/2.0
-		prev = prev + cur
+// mergeNeighbours merges the adjacent processed centroids of least combined
+// weight until at most maxProcessed remain, and then rebuilds the cumulative
+// weights. The sweep in process can leave more when a few centroids carry
+// most of the weight, since none of them may grow past its limit.
+func (t *TDigest) mergeNeighbours() {
+	for t.processed.Len() > t.maxProcessed {
+		best := 0
+		for i := 1; i+1 < t.processed.Len(); i++ {
+			if t.processed[i].Weight+t.processed[i+1].Weight <
+				t.processed[best].Weight+t.processed[best+1].Weight {
+				best = i
+			}
+		}
+		(&t.processed[best]).Add(t.processed[best+1])
+		t.processed = append(t.processed[:best+1], t.processed[best+2:]...)
+		if t.ranges {
+			r := t.processedRanges[best].union(t.processedRanges[best+1])
+			t.processedRanges[best] = r.include(t.processed[best].Mean)
+			t.processedRanges = append(t.processedRanges[:best+1], t.processedRanges[best+2:]...)
+		}
+	}
+
+	t.cumulative = t.cumulative[:0]
+	var cumulative kahanSum
+	for _, c := range t.processed {
+		t.cumulative = append(t.cumulative, cumulative.value()+c.Weight/2.0)
+		cumulative.add(c.Weight)
 	}
-	t.cumulative[t.processed.Len()] = prev
+	t.cumulative = append(t.cumulative, cumulative.value())
+	t.processedWeight = cumulative
 }
 
+// Quantile processes pending data and returns the value at quantile q as
+// FrozenDigest.Quantile does.
 func (t *TDigest) Quantile(q float64) float64 {
-	t.process()
-	if q < 0 || q > 1 || t.processed.Len() == 0 {
-		return math.NaN()
-	}
-	if t.processed.Len() == 1 {
-		return t.processed[0].Mean
-	}
-	index := q * t.processedWeight
-	if index <= t.processed[0].Weight/2.0 {
-		return t.min + 2.0*index/t.processed[0].Weight*(t.processed[0].Mean-t.min)
-	}
+	v, _ := t.QuantileE(q)
+	return v
+}
 
-	lower := sort.Search(len(t.cumulative), func(i int) bool {
-		return t.cumulative[i] >= index
-	})
+// CDF processes pending data and returns the fraction of the weight below x
+// as FrozenDigest.CDF does.
+func (t *TDigest) CDF(x float64) float64 {
+	v, _ := t.CDFE(x)
+	return v
+}
 
-	if lower+1 != len(t.cumulative) {
-		z1 := index - t.cumulative[lower-1]
-		z2 := t.cumulative[lower] - index
-		return weightedAverage(t.processed[lower-1].Mean, z2, t.processed[lower].Mean, z1)
+// frozen returns a view of the processed state of t for the query methods of
+// FrozenDigest. It shares the buffers of t, so it is only valid until t is
+// next modified.
+func (t *TDigest) frozen() FrozenDigest {
+	return FrozenDigest{
+		processed:   t.processed,
+		cumulative:  t.cumulative,
+		count:       t.processedWeight.value(),
+		min:         t.min,
+		max:         t.max,
+		compression: t.compression,
+		ranges:      t.processedRanges,
 	}
+}
 
-	z1 := index - t.processedWeight - t.processed[lower-1].Weight/2.0
-	z2 := (t.processed[lower-1].Weight / 2.0) - z1
-	return weightedAverage(t.processed[t.processed.Len()-1].Mean, z1, t.max, z2)
+// k1Scale computes the weight limits of the k1 scale function
+// k(q) = compression/pi * (asin(2q-1) + pi/2) used by process.
+type k1Scale struct {
+	cos  float64 // cos(pi/compression)
+	sin  float64 // sin(pi/compression)
+	qMax float64 // q beyond which the limit is 1
 }
 
-func (t *TDigest) CDF(x float64) float64 {
-	t.process()
-	switch t.processed.Len() {
-	case 0:
-		return 0.0
-	case 1:
-		width := t.max - t.min
-		if x <= t.min {
-			return 0.0
-		}
-		if x >= t.max {
-			return 1.0
-		}
-		if (x - t.min) <= width {
-			// min and max are too close together to do any viable interpolation
-			return 0.5
-		}
-		return (x - t.min) / width
+func newK1Scale(compression float64, f ScaleFunction) k1Scale {
+	delta := math.Pi / compression
+	var s k1Scale
+	if f == ScaleK1Portable {
+		s.cos, s.sin = portableCosSin(delta)
+	} else {
+		s.cos, s.sin = math.Cos(delta), math.Sin(delta)
 	}
+	s.qMax = (1 + s.cos) / 2
+	if !(delta > 0 && delta < math.Pi) {
+		// A single step of k covers everything, or the compression is
+		// not valid and a single centroid is the safe choice.
+		s.qMax = 0
+	}
+	return s
+}
 
-	if x <= t.min {
-		return 0.0
+// qLimit returns the quantile up to which a centroid that starts at quantile q
+// may grow, that is q(k(q)+1). With a = asin(2q-1) this is
+// (sin(a + pi/compression) + 1)/2, which the angle addition formula turns
+// into arithmetic on 2q-1 = sin(a) and 2*sqrt(q(1-q)) = cos(a), so that
+// process calls no trigonometric functions.
+func (s k1Scale) qLimit(q float64) float64 {
+	// The running weight is summed separately from the total, so q can
+	// stray outside [0, 1] by rounding; past qMax, or NaN, the limit is
+	// the whole digest, and below 0 the square root would be NaN.
+	if !(q < s.qMax) {
+		return 1
 	}
-	if x >= t.max {
-		return 1.0
+	if q < 0 {
+		q = 0
 	}
-	m0 := t.processed[0].Mean
-	// Left Tail
-	if x <= m0 {
-		if m0-t.min > 0 {
-			return (x - t.min) / (m0 - t.min) * t.processed[0].Weight / t.processedWeight / 2.0
-		}
-		return 0.0
+	// The conversions round each product, so that no platform fuses them
+	// into the following addition.
+	return (float64((2*q-1)*s.cos) + float64(2*math.Sqrt(q*(1-q))*s.sin) + 1) / 2
+}
+
+// portableCosSin returns the cosine and sine of x from their Taylor series,
+// rounding every step so that the results are the same on every platform.
+// For x in [0, pi/MinCompression] the terms left out are far below an ulp.
+func portableCosSin(x float64) (cos, sin float64) {
+	x2 := x * x
+	n := len(cosTaylor) - 1
+	cos, sin = cosTaylor[n], sinTaylor[n]
+	for i := n - 1; i >= 0; i-- {
+		cos = float64(cos*x2) + cosTaylor[i]
+		sin = float64(sin*x2) + sinTaylor[i]
 	}
-	// Right Tail
-	mn := t.processed[t.processed.Len()-1].Mean
-	if x >= mn {
-		if t.max-mn > 0.0 {
-			return 1.0 - (t.max-x)/(t.max-mn)*t.processed[t.processed.Len()-1].Weight/t.processedWeight/2.0
-		}
-		return 1.0
+	return cos, float64(sin * x)
+}
+
+// cosTaylor and sinTaylor hold the coefficients of the Taylor series of
+// cos(x) and sin(x)/x in x^2.
+var cosTaylor = [...]float64{
+	1.0 / 1, -1.0 / 2, 1.0 / 24, -1.0 / 720, 1.0 / 40320, -1.0 / 3628800,
+	1.0 / 479001600, -1.0 / 87178291200, 1.0 / 20922789888000,
+	-1.0 / 6402373705728000,
+}
+
+var sinTaylor = [...]float64{
+	1.0 / 1, -1.0 / 6, 1.0 / 120, -1.0 / 5040, 1.0 / 362880, -1.0 / 39916800,
+	1.0 / 6227020800, -1.0 / 1307674368000, 1.0 / 355687428096000,
+	-1.0 / 121645100408832000,
+}
+
+// kahanSum is a running sum using Neumaier's variant of Kahan summation, so
+// that weights of very different magnitudes accumulate without drift.
+type kahanSum struct {
+	sum float64
+	c   float64
+}
+
+func (s *kahanSum) add(x float64) {
+	t := s.sum + x
+	if math.Abs(s.sum) >= math.Abs(x) {
+		s.c += (s.sum - t) + x
+	} else {
+		s.c += (x - t) + s.sum
 	}
+	s.sum = t
+}
 
-	upper := sort.Search(t.processed.Len(), func(i int) bool {
-		return t.processed[i].Mean > x
-	})
+func (s *kahanSum) addSum(o kahanSum) {
+	s.add(o.sum)
+	s.add(o.c)
+}
+
+func (s kahanSum) value() float64 {
+	return s.sum + s.c
+}
 
-	z1 := x - t.processed[upper-1].Mean
-	z2 := t.processed[upper].Mean - x
-	return weightedAverage(t.cumulative[upper-1], z2, t.cumulative[upper], z1) / t.processedWeight
+// centroidSum accumulates centroids into one as the mean of the first plus
+// the compensated sum of the weighted offsets of the others from it. Unlike
+// Centroid.Add it does not round the mean after every centroid, which would
+// lose the contribution of a light centroid to a heavy one entirely.
+type centroidSum struct {
+	base   float64
+	weight kahanSum
+	offset kahanSum
 }
 
-func (t *TDigest) integratedQ(k float64) float64 {
-	return (math.Sin(math.Min(k, t.Compression)*math.Pi/t.Compression-math.Pi/2.0) + 1.0) / 2.0
+func (s *centroidSum) reset(c Centroid) {
+	*s = centroidSum{base: c.Mean}
+	s.weight.add(c.Weight)
 }
 
-func (t *TDigest) integratedLocation(q float64) float64 {
-	return t.Compression * (math.Asin(2.0*q-1.0) + math.Pi/2.0) / math.Pi
+func (s *centroidSum) add(c Centroid) {
+	s.weight.add(c.Weight)
+	// The conversion keeps the product from being fused into the sum, which
+	// some platforms would otherwise do.
+	s.offset.add(float64(c.Weight * (c.Mean - s.base)))
+}
+
+func (s *centroidSum) centroid() Centroid {
+	w := s.weight.value()
+	return Centroid{Mean: s.base + s.offset.value()/w, Weight: w}
 }
 
 func weightedAverage(x1, w1, x2, w2 float64) float64 {
@@ -219,21 +907,161 @@ func weightedAverage(x1, w1, x2, w2 float64) float64 {
 	return weightedAverageSorted(x2, w2, x1, w1)
 }
 
+// weightedAverageSorted steps from x1 towards x2 by the fraction of the weight
+// on x2. Unlike (x1*w1 + x2*w2) / (w1 + w2), for non-negative weights this
+// never rounds below x1 and does not decrease as w2 grows, so interpolation
+// has no plateaus where a clamp engages. The clamp only guards against the
+// rounding of x2-x1 at the top of the segment.
 func weightedAverageSorted(x1, w1, x2, w2 float64) float64 {
-	x := (x1*w1 + x2*w2) / (w1 + w2)
+	x := x1 + (x2-x1)*(w2/(w1+w2))
 	return math.Max(x1, math.Min(x, x2))
 }
 
+// shrinkCentroids returns l with its capacity reduced to size, or to its
+// length if that is larger.
+func shrinkCentroids(l CentroidList, size int) CentroidList {
+	if size < len(l) {
+		size = len(l)
+	}
+	if cap(l) <= size {
+		return l
+	}
+	shrunk := make(CentroidList, len(l), size)
+	copy(shrunk, l)
+	return shrunk
+}
+
+// lessCentroid orders centroids by mean and then by weight. Centroids that
+// compare equal are identical, so sorting by it gives the same result for any
+// input order, which keeps compression deterministic when many values are
+// equal.
+func lessCentroid(a, b Centroid) bool {
+	return a.Mean < b.Mean || a.Mean == b.Mean && a.Weight < b.Weight
+}
+
+// sortCentroids sorts l by lessCentroid. It is a quicksort specialized to
+// CentroidList, which avoids the interface dispatch of sort.Sort that
+// dominates the cost of process.
+func sortCentroids(l CentroidList) {
+	depth := 0
+	for n := len(l); n > 0; n >>= 1 {
+		depth += 2
+	}
+	quickSortCentroids(l, depth)
+}
+
+func quickSortCentroids(l CentroidList, depth int) {
+	for len(l) > 12 {
+		if depth == 0 {
+			heapSortCentroids(l)
+			return
+		}
+		depth--
+		p := partitionCentroids(l)
+		// Recurse into the smaller half to bound the stack depth.
+		if p < len(l)-p {
+			quickSortCentroids(l[:p], depth)
+			l = l[p+1:]
+		} else {
+			quickSortCentroids(l[p+1:], depth)
+			l = l[:p]
+		}
+	}
+	insertionSortCentroids(l)
+}
+
+// partitionCentroids partitions l around a median of three pivot and returns
+// the final index of the pivot.
+func partitionCentroids(l CentroidList) int {
+	hi := len(l) - 1
+	mid := hi / 2
+	if lessCentroid(l[mid], l[0]) {
+		l[mid], l[0] = l[0], l[mid]
+	}
+	if lessCentroid(l[hi], l[0]) {
+		l[hi], l[0] = l[0], l[hi]
+	}
+	if lessCentroid(l[hi], l[mid]) {
+		l[hi], l[mid] = l[mid], l[hi]
+	}
+	// l[0] <= l[mid] <= l[hi]; park the pivot next to the sentinel at hi.
+	l[mid], l[hi-1] = l[hi-1], l[mid]
+	pivot := l[hi-1]
+	i, j := 0, hi-1
+	for {
+		for i++; lessCentroid(l[i], pivot); i++ {
+		}
+		for j--; lessCentroid(pivot, l[j]); j-- {
+		}
+		if i >= j {
+			break
+		}
+		l[i], l[j] = l[j], l[i]
+	}
+	l[i], l[hi-1] = l[hi-1], l[i]
+	return i
+}
+
+func insertionSortCentroids(l CentroidList) {
+	for i := 1; i < len(l); i++ {
+		c := l[i]
+		j := i
+		for ; j > 0 && lessCentroid(c, l[j-1]); j-- {
+			l[j] = l[j-1]
+		}
+		l[j] = c
+	}
+}
+
+func heapSortCentroids(l CentroidList) {
+	for i := len(l)/2 - 1; i >= 0; i-- {
+		siftDownCentroids(l, i, len(l))
+	}
+	for i := len(l) - 1; i > 0; i-- {
+		l[0], l[i] = l[i], l[0]
+		siftDownCentroids(l, 0, i)
+	}
+}
+
+func siftDownCentroids(l CentroidList, root, n int) {
+	for {
+		child := 2*root + 1
+		if child >= n {
+			return
+		}
+		if child+1 < n && lessCentroid(l[child], l[child+1]) {
+			child++
+		}
+		if !lessCentroid(l[root], l[child]) {
+			return
+		}
+		l[root], l[child] = l[child], l[root]
+		root = child
+	}
+}
+
+// processedSize and unprocessedSize return the default buffer sizes for a
+// compression, which they clamp first so that the conversion to int cannot
+// overflow.
 func processedSize(size int, compression float64) int {
 	if size == 0 {
-		return int(2 * math.Ceil(compression))
+		return int(2 * math.Ceil(clampCompression(compression)))
 	}
 	return size
 }
 
 func unprocessedSize(size int, compression float64) int {
 	if size == 0 {
-		return int(8 * math.Ceil(compression))
+		return int(8 * math.Ceil(clampCompression(compression)))
 	}
 	return size
 }
+
+// clampCompression returns c clamped into [MinCompression, MaxCompression],
+// or MinCompression if c is NaN.
+func clampCompression(c float64) float64 {
+	if !(c >= MinCompression) {
+		return MinCompression
+	}
+	return math.Min(c, MaxCompression)
+}
@@ -0,0 +1,135 @@
+// Package tdigesttest provides helpers for tests and benchmarks: it measures
+// the accuracy of t-digests against the exact quantiles of the data they were
+// built from, and reports latency percentiles from benchmarks.
+package tdigesttest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Digest is a digest whose quantiles can be measured, such as a
+// *tdigest.TDigest, *tdigest.ConcurrentTDigest or *tdigest.FrozenDigest.
+type Digest interface {
+	Quantile(q float64) float64
+}
+
+// QuantileError is the error of a digest at quantile Q.
+type QuantileError struct {
+	Q        float64
+	Estimate float64 // the quantile according to the digest
+	Exact    float64 // the exact quantile of the samples
+
+	// RankError is the distance from Q to the range of ranks, as fractions
+	// of the total weight, that the samples equal to Estimate occupy, and
+	// ValueError the absolute difference between Estimate and Exact.
+	RankError  float64
+	ValueError float64
+}
+
+// Accuracy is the error of a digest at each of a set of quantiles.
+type Accuracy struct {
+	Quantiles     []QuantileError
+	MaxRankError  float64
+	MaxValueError float64
+}
+
+// MeasureAccuracy returns the accuracy of d at the quantiles qs compared with
+// samples, the values d was built from, each with a weight of 1. It does not
+// modify samples.
+func MeasureAccuracy(samples []float64, d Digest, qs []float64) Accuracy {
+	a, _ := MeasureWeightedAccuracy(samples, nil, d, qs)
+	return a
+}
+
+// MeasureWeightedAccuracy is like MeasureAccuracy for values with the given
+// weights. A nil weights gives every value a weight of 1; otherwise it must
+// have the length of values.
+//
+// The exact q quantile is the smallest value whose cumulative weight, that of
+// the value and those before it, reaches q times the total weight.
+func MeasureWeightedAccuracy(values, weights []float64, d Digest, qs []float64) (Accuracy, error) {
+	if weights != nil && len(weights) != len(values) {
+		return Accuracy{}, fmt.Errorf("tdigesttest: %d weights for %d values", len(weights), len(values))
+	}
+	s := newSamples(values, weights)
+	a := Accuracy{Quantiles: make([]QuantileError, len(qs))}
+	for i, q := range qs {
+		e := QuantileError{Q: q, Estimate: d.Quantile(q), Exact: s.quantile(q)}
+		below, upTo := s.rank(e.Estimate)
+		switch {
+		case q < below:
+			e.RankError = below - q
+		case q > upTo:
+			e.RankError = q - upTo
+		}
+		e.ValueError = math.Abs(e.Estimate - e.Exact)
+		a.Quantiles[i] = e
+		a.MaxRankError = math.Max(a.MaxRankError, e.RankError)
+		a.MaxValueError = math.Max(a.MaxValueError, e.ValueError)
+	}
+	return a, nil
+}
+
+// samples holds sorted values and their cumulative weights.
+type samples struct {
+	values     []float64
+	cumulative []float64 // weight of the values up to and including i
+}
+
+func newSamples(values, weights []float64) samples {
+	idx := make([]int, len(values))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool { return values[idx[i]] < values[idx[j]] })
+	s := samples{values: make([]float64, len(values)), cumulative: make([]float64, len(values))}
+	var sum float64
+	for i, j := range idx {
+		w := 1.0
+		if weights != nil {
+			w = weights[j]
+		}
+		sum += w
+		s.values[i] = values[j]
+		s.cumulative[i] = sum
+	}
+	return s
+}
+
+func (s samples) total() float64 {
+	if len(s.cumulative) == 0 {
+		return 0
+	}
+	return s.cumulative[len(s.cumulative)-1]
+}
+
+// quantile returns the exact q quantile, or NaN if there are no samples.
+func (s samples) quantile(q float64) float64 {
+	if len(s.values) == 0 || math.IsNaN(q) {
+		return math.NaN()
+	}
+	i := sort.SearchFloat64s(s.cumulative, q*s.total())
+	if i == len(s.values) {
+		i--
+	}
+	return s.values[i]
+}
+
+// rank returns the fractions of the weight below x and up to x.
+func (s samples) rank(x float64) (below, upTo float64) {
+	total := s.total()
+	if total == 0 {
+		return math.NaN(), math.NaN()
+	}
+	weightBefore := func(i int) float64 {
+		if i == 0 {
+			return 0
+		}
+		return s.cumulative[i-1]
+	}
+	lo := sort.Search(len(s.values), func(i int) bool { return s.values[i] >= x })
+	hi := sort.Search(len(s.values), func(i int) bool { return s.values[i] > x })
+	return weightBefore(lo) / total, weightBefore(hi) / total
+}
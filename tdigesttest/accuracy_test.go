@@ -0,0 +1,62 @@
+package tdigesttest_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/influxdata/tdigest/tdigesttest"
+)
+
+// fixed is a Digest that returns the same value at every quantile.
+type fixed float64
+
+func (f fixed) Quantile(float64) float64 { return float64(f) }
+
+func TestMeasureAccuracy(t *testing.T) {
+	samples := []float64{5, 1, 3, 3, 2}
+	a := tdigesttest.MeasureAccuracy(samples, fixed(3), []float64{0, 0.2, 0.5, 0.9})
+	// 3 occupies the ranks [0.4, 0.8].
+	want := []tdigesttest.QuantileError{
+		{Q: 0, Estimate: 3, Exact: 1, RankError: 0.4, ValueError: 2},
+		{Q: 0.2, Estimate: 3, Exact: 1, RankError: 0.2, ValueError: 2},
+		{Q: 0.5, Estimate: 3, Exact: 3, RankError: 0, ValueError: 0},
+		{Q: 0.9, Estimate: 3, Exact: 5, RankError: 0.1, ValueError: 2},
+	}
+	for i, e := range a.Quantiles {
+		if math.Abs(e.RankError-want[i].RankError) > 1e-12 {
+			t.Errorf("q=%v: rank error %v, want %v", e.Q, e.RankError, want[i].RankError)
+		}
+		e.RankError = want[i].RankError
+		if e != want[i] {
+			t.Errorf("got %+v, want %+v", e, want[i])
+		}
+	}
+	if math.Abs(a.MaxRankError-0.4) > 1e-12 || a.MaxValueError != 2 {
+		t.Errorf("unexpected maxima %v, %v", a.MaxRankError, a.MaxValueError)
+	}
+	if samples[0] != 5 {
+		t.Error("samples were modified")
+	}
+}
+
+func TestMeasureWeightedAccuracy(t *testing.T) {
+	values := []float64{1, 2, 3}
+	weights := []float64{1, 8, 1}
+	a, err := tdigesttest.MeasureWeightedAccuracy(values, weights, fixed(2), []float64{0.05, 0.5, 0.95})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []float64{1, 2, 3} {
+		if e := a.Quantiles[i]; e.Exact != want {
+			t.Errorf("q=%v: exact %v, want %v", e.Q, e.Exact, want)
+		}
+	}
+	// 2 occupies the ranks [0.1, 0.9].
+	if math.Abs(a.MaxRankError-0.05) > 1e-12 || a.Quantiles[1].RankError != 0 {
+		t.Errorf("unexpected rank errors %+v", a)
+	}
+
+	if _, err := tdigesttest.MeasureWeightedAccuracy(values, weights[:2], fixed(2), nil); err == nil {
+		t.Error("mismatched weights were accepted")
+	}
+}
@@ -0,0 +1,95 @@
+package tdigesttest
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/tdigest"
+)
+
+// BenchQuantiles are the quantiles BenchRecorder.Finish reports.
+var BenchQuantiles = []float64{0.5, 0.9, 0.99, 0.999}
+
+// benchCompression keeps recording cheap while leaving the tail quantiles
+// accurate to a small fraction of a percent in rank.
+const benchCompression = 200
+
+// BenchRecorder records the latencies of the operations of a benchmark and
+// reports their percentiles, which the mean time per operation that
+// benchmarks report hides.
+//
+// Record is for benchmarks that run on one goroutine. Under b.RunParallel,
+// each goroutine takes a BenchShard with Shard and records into it, and the
+// shards are merged when the recorder finishes.
+type BenchRecorder struct {
+	b    *testing.B
+	main BenchShard
+
+	mu     sync.Mutex
+	shards []*BenchShard
+}
+
+// NewBenchRecorder returns a BenchRecorder for b.
+func NewBenchRecorder(b *testing.B) *BenchRecorder {
+	return &BenchRecorder{b: b, main: BenchShard{tdigest.NewWithCompression(benchCompression)}}
+}
+
+// Record records an operation that took d. It must not be called
+// concurrently; use Shard for that.
+func (r *BenchRecorder) Record(d time.Duration) {
+	r.main.Record(d)
+}
+
+// Shard returns a new BenchShard of r, to be used by a single goroutine. It
+// is safe to call concurrently.
+func (r *BenchRecorder) Shard() *BenchShard {
+	s := &BenchShard{tdigest.NewWithCompression(benchCompression)}
+	r.mu.Lock()
+	r.shards = append(r.shards, s)
+	r.mu.Unlock()
+	return s
+}
+
+// Finish merges the shards and reports each of BenchQuantiles of the
+// recorded latencies with b.ReportMetric, in nanoseconds under units such as
+// p99-ns/op. It reports nothing if nothing was recorded. It must be called
+// once every goroutine has stopped recording, such as after b.RunParallel
+// returns.
+func (r *BenchRecorder) Finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := r.main.t
+	for _, s := range r.shards {
+		t.Merge(s.t)
+	}
+	if t.Count() == 0 {
+		return
+	}
+	for _, q := range BenchQuantiles {
+		r.b.ReportMetric(t.Quantile(q), "p"+percentile(q)+"-ns/op")
+	}
+}
+
+// percentile gives q as a percentile without the decimal point, so that 0.5
+// is 50 and 0.999 is 999.
+func percentile(q float64) string {
+	digits := strconv.FormatFloat(q, 'f', -1, 64)
+	digits = strings.TrimPrefix(digits, "0.")
+	if len(digits) < 2 {
+		digits += "0"
+	}
+	return digits
+}
+
+// BenchShard records latencies for a single goroutine of a BenchRecorder.
+type BenchShard struct {
+	t *tdigest.TDigest
+}
+
+// Record records an operation that took d.
+func (s *BenchShard) Record(d time.Duration) {
+	s.t.Add(float64(d), 1)
+}
@@ -0,0 +1,88 @@
+package tdigesttest_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/influxdata/tdigest/tdigesttest"
+)
+
+func TestBenchRecorder(t *testing.T) {
+	res := testing.Benchmark(func(b *testing.B) {
+		rec := tdigesttest.NewBenchRecorder(b)
+		for i := 0; i < b.N; i++ {
+			rec.Record(time.Duration(i%100+1) * time.Microsecond)
+		}
+		rec.Finish()
+	})
+	for unit, want := range map[string]float64{"p50-ns/op": 50e3, "p99-ns/op": 99e3} {
+		if got := res.Extra[unit]; got < want*0.95 || got > want*1.05 {
+			t.Errorf("%s is %v, want about %v", unit, got, want)
+		}
+	}
+	if len(res.Extra) != len(tdigesttest.BenchQuantiles) {
+		t.Errorf("unexpected metrics %v", res.Extra)
+	}
+
+	res = testing.Benchmark(func(b *testing.B) {
+		rec := tdigesttest.NewBenchRecorder(b)
+		b.RunParallel(func(pb *testing.PB) {
+			s := rec.Shard()
+			for pb.Next() {
+				s.Record(time.Millisecond)
+			}
+		})
+		rec.Finish()
+	})
+	if got := res.Extra["p999-ns/op"]; got != 1e6 {
+		t.Errorf("p999-ns/op is %v after RunParallel, want 1e6", got)
+	}
+
+	res = testing.Benchmark(func(b *testing.B) {
+		tdigesttest.NewBenchRecorder(b).Finish()
+	})
+	if len(res.Extra) != 0 {
+		t.Errorf("metrics %v reported without records", res.Extra)
+	}
+}
+
+// BenchmarkSHA256 shows a BenchRecorder reporting the percentiles of the
+// time to hash a block alongside the mean.
+func BenchmarkSHA256(b *testing.B) {
+	data := make([]byte, 4096)
+	rec := tdigesttest.NewBenchRecorder(b)
+	defer rec.Finish()
+	b.RunParallel(func(pb *testing.PB) {
+		s := rec.Shard()
+		for pb.Next() {
+			start := time.Now()
+			sha256.Sum256(data)
+			s.Record(time.Since(start))
+		}
+	})
+}
+
+func BenchmarkBenchRecorder_Record(b *testing.B) {
+	rec := tdigesttest.NewBenchRecorder(b)
+	for i := 0; i < b.N; i++ {
+		rec.Record(time.Duration(i))
+	}
+}
+
+func ExampleNewBenchRecorder() {
+	// In a benchmark, record the time of each operation and report the
+	// percentiles at the end.
+	benchmark := func(b *testing.B) {
+		rec := tdigesttest.NewBenchRecorder(b)
+		defer rec.Finish()
+		for i := 0; i < b.N; i++ {
+			start := time.Now()
+			fmt.Fprint(ioutil.Discard, i)
+			rec.Record(time.Since(start))
+		}
+	}
+	testing.Benchmark(benchmark)
+}
@@ -0,0 +1,4 @@
+// Package hdrhistogram checks the HdrHistogram conversions of package tdigest
+// against github.com/HdrHistogram/hdrhistogram-go. It is a module of its own
+// so that the tdigest module does not require hdrhistogram-go.
+package hdrhistogram
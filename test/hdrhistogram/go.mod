@@ -0,0 +1,10 @@
+module github.com/influxdata/tdigest/test/hdrhistogram
+
+go 1.16
+
+require (
+	github.com/HdrHistogram/hdrhistogram-go v1.1.2
+	github.com/influxdata/tdigest v0.0.0
+)
+
+replace github.com/influxdata/tdigest => ../../
@@ -0,0 +1,74 @@
+package hdrhistogram_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/influxdata/tdigest"
+)
+
+// normalData returns n values drawn from a normal distribution with mean 10
+// and standard deviation 3.
+func normalData(n int) []float64 {
+	r := rand.New(rand.NewSource(42))
+	data := make([]float64, n)
+	for i := range data {
+		data[i] = r.NormFloat64()*3 + 10
+	}
+	return data
+}
+
+func TestFromHDR(t *testing.T) {
+	for _, sigfigs := range []int{2, 3} {
+		// Normal values in milliseconds, recorded in microseconds.
+		h := hdrhistogram.New(1, 1e8, sigfigs)
+		for _, x := range normalData(100000) {
+			if x > 0 {
+				h.RecordValue(int64(x * 1000))
+			}
+		}
+		td, err := tdigest.FromHDR(tdigest.HDRSnapshot(*h.Export()), 1e-3, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if td.Count() != float64(h.TotalCount()) {
+			t.Errorf("unexpected count %v, want %v", td.Count(), h.TotalCount())
+		}
+		if td.Min() != float64(h.Min())*1e-3 || td.Max() != float64(h.Max())*1e-3 {
+			t.Errorf("unexpected bounds [%v, %v], want [%v, %v]", td.Min(), td.Max(), h.Min(), h.Max())
+		}
+		// Quantiles agree within the precision of the HDR histogram.
+		precision := math.Pow10(-sigfigs)
+		for q := 0.01; q < 1; q += 0.01 {
+			want, got := float64(h.ValueAtQuantile(q*100))*1e-3, td.Quantile(q)
+			if math.Abs(got-want) > 2*precision*want {
+				t.Errorf("sigfigs=%d q=%v: got %v, want %v", sigfigs, q, got, want)
+			}
+		}
+	}
+}
+
+func TestTdigest_ToHDR(t *testing.T) {
+	td := tdigest.NewWithCompression(1000)
+	for _, x := range normalData(100000) {
+		td.Add(math.Abs(x), 1)
+	}
+	const sigfigs = 3
+	s, err := td.ToHDR(1, 1e8, sigfigs, 1e-3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := hdrhistogram.Import((*hdrhistogram.Snapshot)(&s))
+	if h.TotalCount() != 100000 {
+		t.Errorf("unexpected count %v", h.TotalCount())
+	}
+	precision := math.Pow10(-sigfigs)
+	for q := 0.01; q < 1; q += 0.01 {
+		want, got := td.Quantile(q), float64(h.ValueAtQuantile(q*100))*1e-3
+		if math.Abs(got-want) > 2*precision*want+1e-3 {
+			t.Errorf("q=%v: got %v, want %v", q, got, want)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package tdigest
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DigestVec holds a digest for each combination of label values, creating
+// them on first use, for the map of digests behind a mutex that instrumented
+// code otherwise writes by hand. It is safe for concurrent use.
+//
+// Digests that are no longer used can be evicted with EvictIdle, or by a
+// janitor started with StartJanitor.
+type DigestVec struct {
+	compression float64
+	opts        []Option
+	now         func() time.Time
+
+	mu      sync.RWMutex
+	entries map[vecKey]*vecEntry
+	onEvict func(labels []string, d *ConcurrentTDigest)
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// vecKey identifies a combination of label values. Up to three values are
+// held in an array, so that looking them up does not allocate; any more are
+// encoded into rest.
+type vecKey struct {
+	n     int
+	first [3]string
+	rest  string
+}
+
+type vecEntry struct {
+	// The times of the last use, in Unix nanoseconds, are updated atomically
+	// while holding the read lock, so that EvictIdle, which holds the write
+	// lock, sees every use that returned before it.
+	lastAccess int64
+	lastAdd    int64
+	labels     []string
+	digest     *ConcurrentTDigest
+}
+
+// NewDigestVec returns an empty DigestVec whose digests are created with
+// NewConcurrent(compression, opts...).
+func NewDigestVec(compression float64, opts ...Option) *DigestVec {
+	return &DigestVec{
+		compression: compression,
+		opts:        append([]Option(nil), opts...),
+		entries:     make(map[vecKey]*vecEntry),
+	}
+}
+
+// makeVecKey returns the key of labels. It only allocates for more than three
+// labels.
+func makeVecKey(labels []string) vecKey {
+	k := vecKey{n: len(labels)}
+	copy(k.first[:], labels)
+	if len(labels) > len(k.first) {
+		// Each label is prefixed with its length, so that no two lists of
+		// labels encode the same.
+		var b strings.Builder
+		for _, l := range labels[len(k.first):] {
+			b.WriteString(strconv.Itoa(len(l)))
+			b.WriteByte(':')
+			b.WriteString(l)
+		}
+		k.rest = b.String()
+	}
+	return k
+}
+
+// GetOrCreate returns the digest of the given label values, creating it if
+// there is none, and records an access to it. Values seen before only take a
+// read lock, and for up to three of them do not allocate.
+//
+// A digest that is evicted while the caller still holds it may go on being
+// used. What is added to it before EvictIdle hands it to the eviction
+// callback stays in it; what is added after goes to the digest of the same
+// label values, which is created again if need be, so nothing is lost. Its
+// queries see only the data it held when it was evicted.
+func (v *DigestVec) GetOrCreate(labels ...string) *ConcurrentTDigest {
+	k, now := makeVecKey(labels), v.clock()
+	v.mu.RLock()
+	e, ok := v.entries[k]
+	if ok {
+		atomic.StoreInt64(&e.lastAccess, now)
+	}
+	v.mu.RUnlock()
+	if ok {
+		return e.digest
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.create(k, labels, now).digest
+}
+
+// create returns the entry of k, creating it if there is none, and records
+// an access to it at now. v must be locked for writing.
+func (v *DigestVec) create(k vecKey, labels []string, now int64) *vecEntry {
+	e, ok := v.entries[k]
+	if !ok {
+		e = &vecEntry{
+			labels: append([]string(nil), labels...),
+			digest: NewConcurrent(v.compression, v.opts...),
+		}
+		v.entries[k] = e
+	}
+	atomic.StoreInt64(&e.lastAccess, now)
+	return e
+}
+
+// Add adds x with weight w to the digest of the given label values, creating
+// it if there is none. The digest is looked up and added to under the read
+// lock of v, so that the value is never added to a digest that EvictIdle has
+// already removed: it lands either in the evicted digest, before it is
+// handed to the eviction callback, or in a new one.
+func (v *DigestVec) Add(x, w float64, labels ...string) {
+	k, now := makeVecKey(labels), v.clock()
+	v.mu.RLock()
+	if e, ok := v.entries[k]; ok {
+		atomic.StoreInt64(&e.lastAccess, now)
+		atomic.StoreInt64(&e.lastAdd, now)
+		e.digest.Add(x, w)
+		v.mu.RUnlock()
+		return
+	}
+	v.mu.RUnlock()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	e := v.create(k, labels, now)
+	atomic.StoreInt64(&e.lastAdd, now)
+	e.digest.Add(x, w)
+}
+
+// Get returns the digest of the given label values and whether there is one,
+// without creating it. It records an access to the digest.
+func (v *DigestVec) Get(labels ...string) (*ConcurrentTDigest, bool) {
+	k, now := makeVecKey(labels), v.clock()
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if e, ok := v.entries[k]; ok {
+		atomic.StoreInt64(&e.lastAccess, now)
+		return e.digest, true
+	}
+	return nil, false
+}
+
+// clock returns the current time in Unix nanoseconds.
+func (v *DigestVec) clock() int64 {
+	if v.now != nil {
+		return v.now().UnixNano()
+	}
+	return time.Now().UnixNano()
+}
+
+// OnEvict sets a function that is called with each digest EvictIdle removes,
+// after it is removed and without any lock held, so that its data can be
+// saved first. It replaces any function set before; nil removes it.
+func (v *DigestVec) OnEvict(fn func(labels []string, d *ConcurrentTDigest)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.onEvict = fn
+}
+
+// EvictIdle removes the digests that have not been used for olderThan or
+// longer, and returns how many it removed. A digest is used by GetOrCreate,
+// Get and Add; adding to a digest obtained earlier from GetOrCreate does not
+// count. The function set by OnEvict is called with each removed digest once
+// the adds in progress on it have finished; adds made to it later go to the
+// digest that replaces it.
+func (v *DigestVec) EvictIdle(olderThan time.Duration) int {
+	cutoff := v.clock() - int64(olderThan)
+	v.mu.Lock()
+	var evicted []*vecEntry
+	for k, e := range v.entries {
+		if atomic.LoadInt64(&e.lastAccess) <= cutoff {
+			delete(v.entries, k)
+			evicted = append(evicted, e)
+		}
+	}
+	onEvict := v.onEvict
+	v.mu.Unlock()
+	for _, e := range evicted {
+		e.redirect(v)
+	}
+	if onEvict != nil {
+		for _, e := range evicted {
+			onEvict(e.labels, e.digest)
+		}
+	}
+	return len(evicted)
+}
+
+// redirect sends what is added to the digest of e, which has been removed
+// from v, to the digest of the same labels in v. It waits for the adds in
+// progress to finish.
+func (e *vecEntry) redirect(v *DigestVec) {
+	labels := e.labels
+	e.digest.mu.Lock()
+	e.digest.moved = func() *ConcurrentTDigest { return v.GetOrCreate(labels...) }
+	e.digest.mu.Unlock()
+}
+
+// StartJanitor starts a goroutine that calls EvictIdle(ttl) every interval.
+// It does nothing if a janitor is already running.
+func (v *DigestVec) StartJanitor(ttl, interval time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.stop != nil {
+		return
+	}
+	v.stop = make(chan struct{})
+	v.done = make(chan struct{})
+	go v.janitor(ttl, interval, v.stop, v.done)
+}
+
+func (v *DigestVec) janitor(ttl, interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			v.EvictIdle(ttl)
+		}
+	}
+}
+
+// Stop stops the janitor and waits for the goroutine, including any eviction
+// in progress, to finish.
+func (v *DigestVec) Stop() {
+	v.mu.Lock()
+	stop, done := v.stop, v.done
+	v.stop, v.done = nil, nil
+	v.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Delete removes the digest of the given label values and reports whether
+// there was one. Callers still holding the digest may go on using it, but
+// what they add is lost.
+func (v *DigestVec) Delete(labels ...string) bool {
+	k := makeVecKey(labels)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	_, ok := v.entries[k]
+	delete(v.entries, k)
+	return ok
+}
+
+// Len returns the number of digests.
+func (v *DigestVec) Len() int {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return len(v.entries)
+}
+
+// Range calls fn for each digest and its label values, in no particular
+// order, stopping early if fn returns false. fn is called without any lock
+// held, so it may use v, and sees the digests there were when Range was
+// called. It must not modify labels.
+func (v *DigestVec) Range(fn func(labels []string, d *ConcurrentTDigest) bool) {
+	for _, e := range v.list() {
+		if !fn(e.labels, e.digest) {
+			return
+		}
+	}
+}
+
+// list returns the entries of v.
+func (v *DigestVec) list() []*vecEntry {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	entries := make([]*vecEntry, 0, len(v.entries))
+	for _, e := range v.entries {
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// LabeledSnapshot is the snapshot of one digest of a DigestVec.
+type LabeledSnapshot struct {
+	Labels []string
+	Digest *FrozenDigest
+	// LastAccess is when the digest was last used by GetOrCreate, Get or
+	// Add, and LastAdd when Add last added to it, or the zero time if it
+	// never did.
+	LastAccess time.Time
+	LastAdd    time.Time
+}
+
+// Snapshot returns a snapshot of every digest with its label values, sorted
+// by label values, for exporters. The digests are snapshotted one at a time,
+// so data added meanwhile may be in some snapshots and not others.
+func (v *DigestVec) Snapshot() []LabeledSnapshot {
+	entries := v.list()
+	sort.Slice(entries, func(i, j int) bool {
+		return lessLabels(entries[i].labels, entries[j].labels)
+	})
+	s := make([]LabeledSnapshot, len(entries))
+	for i, e := range entries {
+		s[i] = LabeledSnapshot{
+			Labels:     append([]string(nil), e.labels...),
+			Digest:     e.digest.Snapshot(),
+			LastAccess: time.Unix(0, atomic.LoadInt64(&e.lastAccess)),
+		}
+		if t := atomic.LoadInt64(&e.lastAdd); t != 0 {
+			s[i].LastAdd = time.Unix(0, t)
+		}
+	}
+	return s
+}
+
+// lessLabels orders lists of label values lexicographically.
+func lessLabels(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
@@ -0,0 +1,265 @@
+package tdigest_test
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/influxdata/tdigest"
+)
+
+func TestDigestVec(t *testing.T) {
+	v := tdigest.NewDigestVec(100, tdigest.WithPersistentStats())
+	a := v.GetOrCreate("GET", "/")
+	if v.GetOrCreate("GET", "/") != a {
+		t.Error("GetOrCreate returned a different digest for the same labels")
+	}
+	a.Add(1, 1)
+	v.GetOrCreate("GET", "/", "").Add(2, 1)
+	v.GetOrCreate("a", "b", "c", "d").Add(3, 1)
+	v.GetOrCreate("a", "b", "c", "d", "e").Add(4, 1)
+	v.GetOrCreate("a", "b", "c", "de").Add(5, 1)
+	v.GetOrCreate().Add(6, 1)
+	if v.Len() != 6 {
+		t.Fatalf("Len() = %d, want 6", v.Len())
+	}
+	if c := a.Snapshot().Compression(); c != 100 {
+		t.Errorf("compression %g, want 100", c)
+	}
+
+	var labels [][]string
+	var mins []float64
+	for _, s := range v.Snapshot() {
+		labels = append(labels, s.Labels)
+		mins = append(mins, s.Digest.Quantile(0))
+	}
+	wantLabels := [][]string{{}, {"GET", "/"}, {"GET", "/", ""}, {"a", "b", "c", "d"}, {"a", "b", "c", "d", "e"}, {"a", "b", "c", "de"}}
+	if diff := cmp.Diff(wantLabels, labels, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("unexpected snapshot labels (-want +got):\n%s", diff)
+	}
+	if !reflect.DeepEqual(mins, []float64{6, 1, 2, 3, 4, 5}) {
+		t.Errorf("snapshot minimums %v", mins)
+	}
+
+	n := 0
+	v.Range(func(labels []string, d *tdigest.ConcurrentTDigest) bool {
+		n++
+		return n < 2
+	})
+	if n != 2 {
+		t.Errorf("Range did not stop early: %d calls", n)
+	}
+
+	if !v.Delete("GET", "/") || v.Delete("GET", "/") {
+		t.Error("Delete did not report whether there was a digest")
+	}
+	if _, ok := v.Get("GET", "/"); ok {
+		t.Error("deleted digest still present")
+	}
+	if d, ok := v.Get("a", "b", "c", "d"); !ok || d.Count() != 1 {
+		t.Error("Get did not find a digest")
+	}
+	// Range may delete as it goes.
+	v.Range(func(labels []string, d *tdigest.ConcurrentTDigest) bool {
+		v.Delete(labels...)
+		return true
+	})
+	if v.Len() != 0 {
+		t.Errorf("Len() = %d after deleting all", v.Len())
+	}
+}
+
+func TestDigestVec_NoAllocs(t *testing.T) {
+	v := tdigest.NewDigestVec(100)
+	v.GetOrCreate("a")
+	v.GetOrCreate("a", "b")
+	v.GetOrCreate("a", "b", "c")
+	allocs := testing.AllocsPerRun(100, func() {
+		v.GetOrCreate("a")
+		v.GetOrCreate("a", "b")
+		v.GetOrCreate("a", "b", "c")
+	})
+	if allocs != 0 {
+		t.Errorf("%g allocations per lookup of existing labels", allocs)
+	}
+}
+
+func TestDigestVec_Concurrent(t *testing.T) {
+	v := tdigest.NewDigestVec(50)
+	const goroutines, keys = 32, 2000
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				k := (i + g*97) % keys
+				v.GetOrCreate("route", fmt.Sprint(k%7), fmt.Sprint(k)).Add(float64(k), 1)
+				if i%100 == 0 {
+					v.Snapshot()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if v.Len() != keys {
+		t.Fatalf("Len() = %d, want %d", v.Len(), keys)
+	}
+	for _, s := range v.Snapshot() {
+		if s.Digest.Count() != goroutines {
+			t.Errorf("%q has count %g, want %d", s.Labels, s.Digest.Count(), goroutines)
+		}
+	}
+}
+
+func BenchmarkDigestVec_GetOrCreate(b *testing.B) {
+	v := tdigest.NewDigestVec(100)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			v.GetOrCreate("GET", "/api", "2xx").Add(1, 1)
+		}
+	})
+}
+
+func TestDigestVec_EvictIdle(t *testing.T) {
+	now := time.Unix(1000, 0)
+	v := tdigest.NewDigestVec(100)
+	v.SetClock(func() time.Time { return now })
+	var evicted [][]string
+	var evictedCount float64
+	v.OnEvict(func(labels []string, d *tdigest.ConcurrentTDigest) {
+		evicted = append(evicted, labels)
+		evictedCount += d.Count()
+	})
+
+	v.Add(1, 1, "old")
+	v.GetOrCreate("read")
+	now = now.Add(time.Minute)
+	v.Add(2, 1, "new")
+	v.Get("read")
+
+	for _, s := range v.Snapshot() {
+		switch s.Labels[0] {
+		case "old":
+			if !s.LastAdd.Equal(time.Unix(1000, 0)) || !s.LastAccess.Equal(s.LastAdd) {
+				t.Errorf("old: last access %v, last add %v", s.LastAccess, s.LastAdd)
+			}
+		case "read":
+			if !s.LastAccess.Equal(now) || !s.LastAdd.IsZero() {
+				t.Errorf("read: last access %v, last add %v", s.LastAccess, s.LastAdd)
+			}
+		}
+	}
+
+	if n := v.EvictIdle(2 * time.Minute); n != 0 {
+		t.Errorf("evicted %d digests used within the TTL", n)
+	}
+	if n := v.EvictIdle(time.Minute); n != 1 || len(evicted) != 1 || evicted[0][0] != "old" || evictedCount != 1 {
+		t.Errorf("evicted %d: %q with count %g", n, evicted, evictedCount)
+	}
+	if _, ok := v.Get("old"); ok {
+		t.Error("evicted digest still present")
+	}
+	if n := v.EvictIdle(0); n != 2 || v.Len() != 0 {
+		t.Errorf("EvictIdle(0) evicted %d, left %d", n, v.Len())
+	}
+}
+
+// TestDigestVec_EvictRace adds to one key from many goroutines while another
+// evicts it as fast as it can, so that the digest being added to keeps being
+// evicted and recreated. Every value must end up either in an evicted digest
+// or in the one left at the end, whether it is added by Add or to a digest
+// held from GetOrCreate.
+func TestDigestVec_EvictRace(t *testing.T) {
+	const goroutines, adds = 16, 20000
+	for _, tt := range []struct {
+		name string
+		add  func(v *tdigest.DigestVec, i int) // adds n values
+		n    int
+	}{
+		{"Add", func(v *tdigest.DigestVec, i int) {
+			v.Add(float64(i), 1, "user", "42")
+		}, 1},
+		{"GetOrCreate", func(v *tdigest.DigestVec, i int) {
+			// Hold the digest across several adds, so that some of them
+			// come after it is evicted.
+			d := v.GetOrCreate("user", "42")
+			for j := 0; j < 10; j++ {
+				d.Add(float64(i), 1)
+			}
+		}, 10},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			v := tdigest.NewDigestVec(50)
+			var mu sync.Mutex
+			var evicted float64
+			evictions := 0
+			v.OnEvict(func(labels []string, d *tdigest.ConcurrentTDigest) {
+				mu.Lock()
+				defer mu.Unlock()
+				evicted += d.Count()
+				evictions++
+			})
+
+			stop := make(chan struct{})
+			evictorDone := make(chan struct{})
+			go func() {
+				defer close(evictorDone)
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+						v.EvictIdle(0)
+					}
+				}
+			}()
+			var wg sync.WaitGroup
+			for g := 0; g < goroutines; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < adds/tt.n; i++ {
+						tt.add(v, i)
+					}
+				}()
+			}
+			wg.Wait()
+			close(stop)
+			<-evictorDone
+
+			remaining := 0.0
+			if d, ok := v.Get("user", "42"); ok {
+				remaining = d.Count()
+			}
+			if got := evicted + remaining; got != goroutines*adds {
+				t.Errorf("%g values in evicted digests and %g remaining, want %d in total", evicted, remaining, goroutines*adds)
+			}
+			if evictions == 0 {
+				t.Error("nothing was evicted")
+			}
+		})
+	}
+}
+
+func TestDigestVec_Janitor(t *testing.T) {
+	v := tdigest.NewDigestVec(100)
+	v.StartJanitor(time.Millisecond, time.Millisecond)
+	v.StartJanitor(time.Hour, time.Hour)
+	defer v.Stop()
+	v.Add(1, 1, "a")
+	deadline := time.Now().Add(5 * time.Second)
+	for v.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("janitor did not evict the idle digest")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	v.Stop()
+	v.Stop()
+}